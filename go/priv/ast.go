@@ -0,0 +1,740 @@
+package priv
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node represents a node in the fql abstract syntax tree.
+type Node interface {
+	// node is unexported to ensure implementations of Node
+	// can only originate in this package.
+	node()
+	String() string
+}
+
+func (*AlterUserStatement) node()             {}
+func (*BinaryExpr) node()                     {}
+func (*BooleanLiteral) node()                 {}
+func (*CreateDatabaseStatement) node()        {}
+func (*CreateRoleStatement) node()            {}
+func (*CreateUserStatement) node()            {}
+func (*DeleteStatement) node()                {}
+func (*DropDatabaseStatement) node()          {}
+func (*DropMeasurementStatement) node()       {}
+func (*DropRoleStatement) node()              {}
+func (*DropUserStatement) node()              {}
+func (*DurationLiteral) node()                {}
+func (*GrantStatement) node()                 {}
+func (*GrantRoleStatement) node()             {}
+func (*InsertStatement) node()                {}
+func (*IntegerLiteral) node()                 {}
+func (*ListLiteral) node()                    {}
+func (*Measurement) node()                    {}
+func (*NumberLiteral) node()                  {}
+func (*ParenExpr) node()                      {}
+func (*RegexLiteral) node()                   {}
+func (*RevokeStatement) node()                {}
+func (*RevokeRoleStatement) node()            {}
+func (*SelectStatement) node()                {}
+func (*ShowContinuousQueriesStatement) node() {}
+func (*ShowDatabasesStatement) node()         {}
+func (*ShowGrantsStatement) node()            {}
+func (*ShowRolesStatement) node()             {}
+func (*ShowUsersStatement) node()             {}
+func (*StringLiteral) node()                  {}
+func (*Query) node()                          {}
+func (*VarRef) node()                         {}
+
+// Query represents a collection of ordered statements.
+type Query struct {
+	Statements []Statement
+
+	// Positions of statements, Positions[i] is where Statements[i] is in the
+	// query text.
+	Positions []StatementPos
+}
+
+// String returns statements of the query separated by semicolons, one
+// statement per line.
+func (q *Query) String() string {
+	var buf bytes.Buffer
+	for i, stmt := range q.Statements {
+		if i > 0 {
+			buf.WriteString(";\n")
+		}
+		buf.WriteString(stmt.String())
+	}
+	return buf.String()
+}
+
+// StatementPos is where a statement is in the query text. Start is position of
+// the first token, and End is position of the semicolon terminating the
+// statement, or the end of query text for the last statement.
+type StatementPos struct {
+	Start Pos
+	End   Pos
+}
+
+// ExecutionError is returned when executing a statement of a query failed.
+type ExecutionError struct {
+	// Index of the failed statement in the query.
+	Index int
+
+	// Statement failed to execute.
+	Statement Statement
+
+	// Pos is where the failed statement is in the query text.
+	Pos StatementPos
+
+	// Err returned by executing the statement.
+	Err error
+}
+
+// Error returns the string representation of the error.
+func (e *ExecutionError) Error() string {
+	return fmt.Sprintf("statement %d at line %d, char %d: %v", e.Index+1, e.Pos.Start.Line+1, e.Pos.Start.Char+1, e.Err)
+}
+
+// Unwrap returns the error returned by executing the statement.
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// Execute runs fn on statements in order and stops at the first error, which
+// is returned as an *ExecutionError with index of the failed statement.
+// Position of the statement is left zero if the query has no positions, e.g.
+// built without parsing.
+func (q *Query) Execute(fn func(stmt Statement) error) error {
+	for i, stmt := range q.Statements {
+		if err := fn(stmt); err != nil {
+			e := &ExecutionError{Index: i, Statement: stmt, Err: err}
+			if i < len(q.Positions) {
+				e.Pos = q.Positions[i]
+			}
+			return e
+		}
+	}
+	return nil
+}
+
+// Statement represents a single command in fql.
+type Statement interface {
+	Node
+	// stmt is unexported to ensure implementations of Statement
+	// can only originate in this package.
+	stmt()
+}
+
+func (*AlterUserStatement) stmt()             {}
+func (*CreateDatabaseStatement) stmt()        {}
+func (*CreateRoleStatement) stmt()            {}
+func (*CreateUserStatement) stmt()            {}
+func (*DeleteStatement) stmt()                {}
+func (*DropDatabaseStatement) stmt()          {}
+func (*DropMeasurementStatement) stmt()       {}
+func (*DropRoleStatement) stmt()              {}
+func (*DropUserStatement) stmt()              {}
+func (*GrantStatement) stmt()                 {}
+func (*GrantRoleStatement) stmt()             {}
+func (*InsertStatement) stmt()                {}
+func (*RevokeStatement) stmt()                {}
+func (*RevokeRoleStatement) stmt()            {}
+func (*SelectStatement) stmt()                {}
+func (*ShowContinuousQueriesStatement) stmt() {}
+func (*ShowDatabasesStatement) stmt()         {}
+func (*ShowGrantsStatement) stmt()            {}
+func (*ShowRolesStatement) stmt()             {}
+func (*ShowUsersStatement) stmt()             {}
+
+// GrantStatement represents a command for granting privileges to a user or role.
+type GrantStatement struct {
+	// Privileges to be granted.
+	Privileges []Privilege
+
+	// Resource to grant privileges on, an empty path means global resource.
+	On *ResourcePath
+
+	// Who to grant the privileges to.
+	Name string
+
+	// Role is true if Name refers to a role instead of a user.
+	Role bool
+
+	// Duration limits the grant to a period from when it is executed, given
+	// by FOR, zero if the grant is not limited by duration.
+	Duration time.Duration
+
+	// Expires is the absolute expiry time given by UNTIL, zero if not given.
+	Expires time.Time
+
+	// Condition limits the grant to rows satisfying it, given by WHERE, nil
+	// if the grant applies to all rows.
+	Condition Expr
+}
+
+// Privilege returns all granted privileges combined.
+func (s *GrantStatement) Privilege() Privilege {
+	return combinePrivileges(s.Privileges)
+}
+
+// String returns a string representation of the grant statement.
+func (s *GrantStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(GRANT.String())
+	writePrivilegesOn(&buf, s.Privileges, s.On)
+	if s.Condition != nil {
+		fmt.Fprintf(&buf, " %s %s", WHERE, s.Condition)
+	}
+	writePrincipal(&buf, TO, s.Name, s.Role)
+	if s.Duration > 0 {
+		fmt.Fprintf(&buf, " %s %s", FOR, FormatDuration(s.Duration))
+	} else if !s.Expires.IsZero() {
+		fmt.Fprintf(&buf, " %s %s", UNTIL, QuoteString(formatTime(s.Expires)))
+	}
+	return buf.String()
+}
+
+// ExpiresAt returns when the grant expires if it is executed at now, a zero
+// time means the grant never expires.
+func (s *GrantStatement) ExpiresAt(now time.Time) time.Time {
+	if s.Duration > 0 {
+		return now.Add(s.Duration)
+	}
+	return s.Expires
+}
+
+// RevokeStatement represents a command for revoking privileges from a user or role.
+type RevokeStatement struct {
+	// Privileges to be revoked.
+	Privileges []Privilege
+
+	// Resource to revoke privileges from, an empty path means global resource.
+	On *ResourcePath
+
+	// Who to revoke the privileges from.
+	Name string
+
+	// Role is true if Name refers to a role instead of a user.
+	Role bool
+}
+
+// Privilege returns all revoked privileges combined.
+func (s *RevokeStatement) Privilege() Privilege {
+	return combinePrivileges(s.Privileges)
+}
+
+// String returns a string representation of the revoke statement.
+func (s *RevokeStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(REVOKE.String())
+	writePrivilegesOn(&buf, s.Privileges, s.On)
+	writePrincipal(&buf, FROM, s.Name, s.Role)
+	return buf.String()
+}
+
+// writePrivilegesOn writes privileges and ON clause of grant and revoke statements.
+func writePrivilegesOn(buf *bytes.Buffer, privileges []Privilege, on *ResourcePath) {
+	for i, p := range privileges {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(" ")
+		buf.WriteString(p.String())
+	}
+	if on != nil && (len(on.Segs) > 0 || on.Regex != nil) {
+		fmt.Fprintf(buf, " %s %s", ON, on)
+	}
+}
+
+// writePrincipal writes the TO or FROM clause naming a user or role.
+func writePrincipal(buf *bytes.Buffer, tok Token, name string, role bool) {
+	fmt.Fprintf(buf, " %s ", tok)
+	if role {
+		fmt.Fprintf(buf, "%s ", ROLE)
+	}
+	buf.WriteString(QuoteIdent(name))
+}
+
+// formatTime formats a time literal in UTC, the date part only if the time
+// is midnight.
+func formatTime(t time.Time) string {
+	t = t.UTC()
+	if t.Equal(t.Truncate(24 * time.Hour)) {
+		return t.Format(DateFormat)
+	}
+	return t.Format(DateTimeFormat)
+}
+
+func combinePrivileges(privileges []Privilege) Privilege {
+	p := NoPrivilege
+	for _, v := range privileges {
+		p |= v
+	}
+	return p
+}
+
+// GrantRoleStatement represents a command for granting a role to a user or role.
+type GrantRoleStatement struct {
+	// Role to be granted.
+	Role string
+
+	// Who to grant the role to.
+	Name string
+
+	// ToRole is true if Name refers to a role instead of a user.
+	ToRole bool
+}
+
+// String returns a string representation of the grant role statement.
+func (s *GrantRoleStatement) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s", GRANT, ROLE, QuoteIdent(s.Role))
+	writePrincipal(&buf, TO, s.Name, s.ToRole)
+	return buf.String()
+}
+
+// RevokeRoleStatement represents a command for revoking a role from a user or role.
+type RevokeRoleStatement struct {
+	// Role to be revoked.
+	Role string
+
+	// Who to revoke the role from.
+	Name string
+
+	// FromRole is true if Name refers to a role instead of a user.
+	FromRole bool
+}
+
+// String returns a string representation of the revoke role statement.
+func (s *RevokeRoleStatement) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s", REVOKE, ROLE, QuoteIdent(s.Role))
+	writePrincipal(&buf, FROM, s.Name, s.FromRole)
+	return buf.String()
+}
+
+// CreateUserStatement represents a command for creating a new user.
+type CreateUserStatement struct {
+	// Name of the user to be created.
+	Name string
+
+	// User's password.
+	Password string
+}
+
+// String returns a string representation of the create user statement, it
+// should be noticed that the password is included.
+func (s *CreateUserStatement) String() string {
+	return fmt.Sprintf("%s %s %s %s %s %s", CREATE, USER, QuoteIdent(s.Name), WITH, PASSWORD, QuoteString(s.Password))
+}
+
+// DropUserStatement represents a command for dropping a user.
+type DropUserStatement struct {
+	// Name of the user to drop.
+	Name string
+}
+
+// String returns a string representation of the drop user statement.
+func (s *DropUserStatement) String() string {
+	return fmt.Sprintf("%s %s %s", DROP, USER, QuoteIdent(s.Name))
+}
+
+// AlterUserStatement represents a command for altering attributes of a user.
+// Attributes not mentioned in the command are left nil.
+type AlterUserStatement struct {
+	// Name of the user to be altered.
+	Name string
+
+	// New password of the user.
+	Password *string
+
+	// Locked is true for ACCOUNT LOCK and false for ACCOUNT UNLOCK.
+	Locked *bool
+
+	// BindHosts are addresses or CIDR networks the user may connect from.
+	BindHosts []string
+}
+
+// String returns a string representation of the alter user statement, it
+// should be noticed that the password is included if changed.
+func (s *AlterUserStatement) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s", ALTER, USER, QuoteIdent(s.Name))
+	if s.Password != nil {
+		fmt.Fprintf(&buf, " %s %s %s", WITH, PASSWORD, QuoteString(*s.Password))
+	}
+	if s.Locked != nil {
+		lock := UNLOCK
+		if *s.Locked {
+			lock = LOCK
+		}
+		fmt.Fprintf(&buf, " %s %s", ACCOUNT, lock)
+	}
+	if len(s.BindHosts) > 0 {
+		hosts := make([]string, 0, len(s.BindHosts))
+		for _, h := range s.BindHosts {
+			hosts = append(hosts, QuoteString(h))
+		}
+		fmt.Fprintf(&buf, " %s %s", BINDHOST, strings.Join(hosts, ", "))
+	}
+	return buf.String()
+}
+
+// CreateRoleStatement represents a command for creating a new role.
+type CreateRoleStatement struct {
+	// Name of the role to be created.
+	Name string
+}
+
+// String returns a string representation of the create role statement.
+func (s *CreateRoleStatement) String() string {
+	return fmt.Sprintf("%s %s %s", CREATE, ROLE, QuoteIdent(s.Name))
+}
+
+// DropRoleStatement represents a command for dropping a role.
+type DropRoleStatement struct {
+	// Name of the role to drop.
+	Name string
+}
+
+// String returns a string representation of the drop role statement.
+func (s *DropRoleStatement) String() string {
+	return fmt.Sprintf("%s %s %s", DROP, ROLE, QuoteIdent(s.Name))
+}
+
+// Measurement represents a measurement qualified by optional database and
+// retention policy, e.g. cpu, autogen.cpu, mydb.autogen.cpu or mydb..cpu
+type Measurement struct {
+	Database        string
+	RetentionPolicy string
+	Name            string
+}
+
+// String returns a string representation of the measurement, segments missing
+// from the measurement are left empty, e.g. mydb..cpu
+func (m *Measurement) String() string {
+	var buf bytes.Buffer
+	if m.Database != "" {
+		buf.WriteString(QuoteIdent(m.Database))
+		buf.WriteString(".")
+	}
+	if m.RetentionPolicy != "" {
+		buf.WriteString(QuoteIdent(m.RetentionPolicy))
+		buf.WriteString(".")
+	} else if m.Database != "" {
+		buf.WriteString(".")
+	}
+	buf.WriteString(QuoteIdent(m.Name))
+	return buf.String()
+}
+
+// Resource returns resource path of the measurement. Database and retention
+// policy missing from the measurement are taken from the given defaults, and
+// retention policy defaults to autogen if both are empty.
+func (m *Measurement) Resource(database, retentionPolicy string) *ResourcePath {
+	if m.Database != "" {
+		database = m.Database
+	}
+	if m.RetentionPolicy != "" {
+		retentionPolicy = m.RetentionPolicy
+	}
+	return NewResourcePath(database, retentionPolicy, m.Name)
+}
+
+// SelectStatement represents a command for querying measurements.
+type SelectStatement struct {
+	// Queried fields, * means all fields.
+	Fields []string
+
+	// Measurements to query from.
+	Sources []*Measurement
+}
+
+// String returns a string representation of the select statement.
+func (s *SelectStatement) String() string {
+	var buf bytes.Buffer
+	buf.WriteString(SELECT.String())
+	for i, field := range s.Fields {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(" ")
+		if field == "*" {
+			buf.WriteString(field)
+		} else {
+			buf.WriteString(QuoteIdent(field))
+		}
+	}
+	fmt.Fprintf(&buf, " %s", FROM)
+	for i, m := range s.Sources {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(" ")
+		buf.WriteString(m.String())
+	}
+	return buf.String()
+}
+
+// InsertStatement represents a command for writing points into a measurement.
+// Points are carried by the request separately, e.g. as line protocol.
+type InsertStatement struct {
+	// Measurement to write into.
+	Into *Measurement
+}
+
+// String returns a string representation of the insert statement.
+func (s *InsertStatement) String() string {
+	return fmt.Sprintf("%s %s %s", INSERT, INTO, s.Into)
+}
+
+// DeleteStatement represents a command for deleting series from a measurement.
+type DeleteStatement struct {
+	// Measurement to delete from.
+	Source *Measurement
+}
+
+// String returns a string representation of the delete statement.
+func (s *DeleteStatement) String() string {
+	return fmt.Sprintf("%s %s %s", DELETE, FROM, s.Source)
+}
+
+// DropMeasurementStatement represents a command for dropping a measurement.
+type DropMeasurementStatement struct {
+	// Measurement to drop.
+	Measurement *Measurement
+}
+
+// String returns a string representation of the drop measurement statement.
+func (s *DropMeasurementStatement) String() string {
+	return fmt.Sprintf("%s %s %s", DROP, MEASUREMENT, s.Measurement)
+}
+
+// CreateDatabaseStatement represents a command for creating a new database.
+type CreateDatabaseStatement struct {
+	// Name of the database to be created.
+	Name string
+}
+
+// String returns a string representation of the create database statement.
+func (s *CreateDatabaseStatement) String() string {
+	return fmt.Sprintf("%s %s %s", CREATE, DATABASE, QuoteIdent(s.Name))
+}
+
+// DropDatabaseStatement represents a command for dropping a database.
+type DropDatabaseStatement struct {
+	// Name of the database to drop.
+	Name string
+}
+
+// String returns a string representation of the drop database statement.
+func (s *DropDatabaseStatement) String() string {
+	return fmt.Sprintf("%s %s %s", DROP, DATABASE, QuoteIdent(s.Name))
+}
+
+// ShowUsersStatement represents a command for listing users.
+type ShowUsersStatement struct{}
+
+// String returns a string representation of the show users statement.
+func (s *ShowUsersStatement) String() string {
+	return fmt.Sprintf("%s %s", SHOW, USERS)
+}
+
+// ShowRolesStatement represents a command for listing roles.
+type ShowRolesStatement struct{}
+
+// String returns a string representation of the show roles statement.
+func (s *ShowRolesStatement) String() string {
+	return fmt.Sprintf("%s %s", SHOW, ROLES)
+}
+
+// ShowGrantsStatement represents a command for listing privileges of a user or role.
+type ShowGrantsStatement struct {
+	// Whose privileges to list.
+	Name string
+
+	// Role is true if Name refers to a role instead of a user.
+	Role bool
+}
+
+// String returns a string representation of the show grants statement.
+func (s *ShowGrantsStatement) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s", SHOW, GRANTS)
+	writePrincipal(&buf, FOR, s.Name, s.Role)
+	return buf.String()
+}
+
+// ShowDatabasesStatement represents a command for listing databases.
+type ShowDatabasesStatement struct{}
+
+// String returns a string representation of the show databases statement.
+func (s *ShowDatabasesStatement) String() string {
+	return fmt.Sprintf("%s %s", SHOW, DATABASES)
+}
+
+// ShowContinuousQueriesStatement represents a command for listing continuous queries.
+type ShowContinuousQueriesStatement struct{}
+
+// String returns a string representation of the show continuous queries statement.
+func (s *ShowContinuousQueriesStatement) String() string {
+	return fmt.Sprintf("%s %s %s", SHOW, CONTINUOUS, QUERIES)
+}
+
+// Expr represents an expression that can be evaluated to a value.
+type Expr interface {
+	Node
+	// expr is unexported to ensure implementations of Expr
+	// can only originate in this package.
+	expr()
+}
+
+func (*BinaryExpr) expr()      {}
+func (*BooleanLiteral) expr()  {}
+func (*DurationLiteral) expr() {}
+func (*IntegerLiteral) expr()  {}
+func (*ListLiteral) expr()     {}
+func (*NumberLiteral) expr()   {}
+func (*ParenExpr) expr()       {}
+func (*RegexLiteral) expr()    {}
+func (*StringLiteral) expr()   {}
+func (*VarRef) expr()          {}
+
+// BinaryExpr represents an operation between two expressions.
+type BinaryExpr struct {
+	Op  Token
+	LHS Expr
+	RHS Expr
+}
+
+// String returns a string representation of the binary expression.
+func (e *BinaryExpr) String() string {
+	return fmt.Sprintf("%s %s %s", e.LHS, e.Op, e.RHS)
+}
+
+// ParenExpr represents a parenthesized expression.
+type ParenExpr struct {
+	Expr Expr
+}
+
+// String returns a string representation of the parenthesized expression.
+func (e *ParenExpr) String() string {
+	return fmt.Sprintf("(%s)", e.Expr)
+}
+
+// VarRef represents a reference to a variable, e.g. a tag key.
+type VarRef struct {
+	Val string
+}
+
+// String returns a string representation of the variable reference.
+func (r *VarRef) String() string {
+	return QuoteIdent(r.Val)
+}
+
+// StringLiteral represents a string literal.
+type StringLiteral struct {
+	Val string
+}
+
+// String returns a string representation of the literal.
+func (l *StringLiteral) String() string {
+	return QuoteString(l.Val)
+}
+
+// RegexLiteral represents a regular expression.
+type RegexLiteral struct {
+	Val *regexp.Regexp
+}
+
+// String returns a string representation of the literal.
+func (r *RegexLiteral) String() string {
+	if r.Val == nil {
+		return ""
+	}
+	return fmt.Sprintf("/%s/", escapeRegex(r.Val.String()))
+}
+
+// escapeRegex escapes slashes not escaped yet in a regular expression.
+func escapeRegex(s string) string {
+	var buf bytes.Buffer
+	escaped := false
+	for _, ch := range s {
+		if ch == '/' && !escaped {
+			buf.WriteRune('\\')
+		}
+		escaped = ch == '\\' && !escaped
+		buf.WriteRune(ch)
+	}
+	return buf.String()
+}
+
+// NumberLiteral represents a numeric literal.
+type NumberLiteral struct {
+	Val float64
+}
+
+// String returns a string representation of the literal, which always has a
+// fractional part so that it is not read back as an integer.
+func (l *NumberLiteral) String() string {
+	s := strconv.FormatFloat(l.Val, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// IntegerLiteral represents an integer literal.
+type IntegerLiteral struct {
+	Val int64
+}
+
+// String returns a string representation of the literal.
+func (l *IntegerLiteral) String() string {
+	return strconv.FormatInt(l.Val, 10)
+}
+
+// BooleanLiteral represents a boolean literal.
+type BooleanLiteral struct {
+	Val bool
+}
+
+// String returns a string representation of the literal.
+func (l *BooleanLiteral) String() string {
+	return strconv.FormatBool(l.Val)
+}
+
+// DurationLiteral represents a duration literal.
+type DurationLiteral struct {
+	Val time.Duration
+}
+
+// String returns a string representation of the literal.
+func (l *DurationLiteral) String() string {
+	return FormatDuration(l.Val)
+}
+
+// ListLiteral represents a parenthesized list of expressions, which is the
+// right side of IN.
+type ListLiteral struct {
+	Vals []Expr
+}
+
+// String returns a string representation of the literal.
+func (l *ListLiteral) String() string {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	for i, v := range l.Vals {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(v.String())
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
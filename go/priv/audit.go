@@ -0,0 +1,411 @@
+package priv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// auditMessage is the message of all audit events.
+const auditMessage = "privilege changed"
+
+// ErrConditionUnsupported is returned by AuditedPrivilegeSet.AddWhere if the
+// wrapped set does not support conditional privileges.
+var ErrConditionUnsupported = errors.New("conditional privileges not supported")
+
+// AuditEvent is a change of privileges made to a principal.
+type AuditEvent struct {
+	// Time the change was made.
+	Time time.Time `json:"time"`
+	// Op is the name of the PrivilegeSet method made the change, e.g. Add.
+	Op string `json:"op"`
+	// Actor is who made the change.
+	Actor string `json:"actor"`
+	// Principal is whose privileges changed.
+	Principal string `json:"principal"`
+	// Resource on which privileges changed, empty for global resource.
+	Resource string `json:"resource"`
+	// Privilege passed to the method, NoPrivilege if the method takes no privilege.
+	Privilege Privilege `json:"privilege"`
+	// Before and After are privileges on the resource before and after the change.
+	Before Privilege `json:"before"`
+	After  Privilege `json:"after"`
+	// Condition of privileges granted on rows, empty for unconditional changes.
+	Condition string `json:"condition,omitempty"`
+	// Error of a change failed to make, in which case Before and After are
+	// the same.
+	Error string `json:"error,omitempty"`
+	// Changes are privileges changed on every resource by operations changing
+	// privileges on many resources, e.g. UnionWith, sorted by resource.
+	Changes []AuditChange `json:"changes,omitempty"`
+}
+
+// AuditChange is a change of privileges on one resource.
+type AuditChange struct {
+	// Resource on which privileges changed, empty for global resource.
+	Resource string `json:"resource"`
+	// Before and After are privileges on the resource before and after the change.
+	Before Privilege `json:"before"`
+	After  Privilege `json:"after"`
+}
+
+// AuditLog emits audit events as structured zap logs into a sink.
+type AuditLog struct {
+	logger *zap.Logger
+	clock  func() time.Time
+}
+
+// NewAuditLog creates an audit log writing events as JSON lines into sink,
+// events are stamped by time returned from clock, time.Now is used if clock
+// is nil.
+func NewAuditLog(sink zapcore.WriteSyncer, clock func() time.Time) *AuditLog {
+	if clock == nil {
+		clock = time.Now
+	}
+	encoder := zapcore.NewJSONEncoder(zapcore.EncoderConfig{
+		MessageKey: "msg",
+		LineEnding: zapcore.DefaultLineEnding,
+		EncodeTime: zapcore.RFC3339NanoTimeEncoder,
+	})
+	core := zapcore.NewCore(encoder, zapcore.Lock(sink), zapcore.InfoLevel)
+	return &AuditLog{logger: zap.New(core), clock: clock}
+}
+
+// Wrap returns a privilege set which audits all changes made to privileges of
+// the principal, the changes are made by the given actor.
+func (l *AuditLog) Wrap(set PrivilegeSet, actor, principal string) *AuditedPrivilegeSet {
+	return &AuditedPrivilegeSet{set: set, log: l, actor: actor, principal: principal}
+}
+
+func (l *AuditLog) emit(e *AuditEvent) {
+	fields := []zap.Field{
+		zap.Time("time", e.Time),
+		zap.String("op", e.Op),
+		zap.String("actor", e.Actor),
+		zap.String("principal", e.Principal),
+		zap.String("resource", e.Resource),
+		zap.Int("privilege", int(e.Privilege)),
+		zap.Int("before", int(e.Before)),
+		zap.Int("after", int(e.After)),
+	}
+	if e.Condition != "" {
+		fields = append(fields, zap.String("condition", e.Condition))
+	}
+	if e.Error != "" {
+		fields = append(fields, zap.String("error", e.Error))
+	}
+	if len(e.Changes) > 0 {
+		fields = append(fields, zap.Any("changes", e.Changes))
+	}
+	l.logger.Info(auditMessage, fields...)
+}
+
+// Sync flushes buffered events into the sink.
+func (l *AuditLog) Sync() error {
+	return l.logger.Sync()
+}
+
+// AuditedPrivilegeSet is an implementation of PrivilegeSet which wraps another
+// privilege set and emits an audit event for every change made through it.
+// It is safe for concurrent use if the wrapped set is, but before and after
+// privileges of an event may include changes made concurrently.
+type AuditedPrivilegeSet struct {
+	set       PrivilegeSet
+	log       *AuditLog
+	actor     string
+	principal string
+}
+
+func (s *AuditedPrivilegeSet) implPrivilegeSet() {
+	var _ PrivilegeSet = (*AuditedPrivilegeSet)(nil)
+}
+
+// WithActor returns a privilege set sharing privileges and audit log with s,
+// changes made through which are made by another actor.
+func (s *AuditedPrivilegeSet) WithActor(actor string) *AuditedPrivilegeSet {
+	c := *s
+	c.actor = actor
+	return &c
+}
+
+// Unwrap returns the wrapped privilege set.
+func (s *AuditedPrivilegeSet) Unwrap() PrivilegeSet {
+	return s.set
+}
+
+// audit applies change to privileges on resource and emits an audit event.
+func (s *AuditedPrivilegeSet) audit(op string, resource *ResourcePath, privilege Privilege, change func()) {
+	s.log.emit(s.event(op, resource, privilege, change))
+}
+
+// auditAll applies change to privileges on any resource and emits an audit
+// event with changes on every resource.
+func (s *AuditedPrivilegeSet) auditAll(op string, change func()) {
+	before := copyTreeOf(s.set)
+	e := s.event(op, GlobalResource, NoPrivilege, change)
+	e.Changes = changesOf(before, treeOf(s.set))
+	s.log.emit(e)
+}
+
+// event applies change to privileges on resource and returns an audit event
+// of the change.
+func (s *AuditedPrivilegeSet) event(op string, resource *ResourcePath, privilege Privilege, change func()) *AuditEvent {
+	before := privilegesOn(s.set, resource)
+	change()
+	after := privilegesOn(s.set, resource)
+
+	e := &AuditEvent{Time: s.log.clock(), Op: op, Actor: s.actor, Principal: s.principal,
+		Privilege: privilege, Before: before, After: after}
+	if len(resource.Segs) > 0 {
+		e.Resource = resource.String()
+	}
+	return e
+}
+
+// SetAll set full privileges to privilege set.
+func (s *AuditedPrivilegeSet) SetAll() {
+	s.auditAll("SetAll", s.set.SetAll)
+}
+
+// ClearAll clear all privileges from privilege set.
+func (s *AuditedPrivilegeSet) ClearAll() {
+	s.auditAll("ClearAll", s.set.ClearAll)
+}
+
+// AddGlobal add some privileges to global resource.
+func (s *AuditedPrivilegeSet) AddGlobal(privilege Privilege) {
+	s.audit("AddGlobal", GlobalResource, privilege, func() { s.set.AddGlobal(privilege) })
+}
+
+// DeleteGlobal delete some privileges from all resources.
+func (s *AuditedPrivilegeSet) DeleteGlobal(privilege Privilege) {
+	s.audit("DeleteGlobal", GlobalResource, privilege, func() { s.set.DeleteGlobal(privilege) })
+}
+
+// Add some privileges to given resource.
+func (s *AuditedPrivilegeSet) Add(resource *ResourcePath, privilege Privilege) {
+	s.audit("Add", resource, privilege, func() { s.set.Add(resource, privilege) })
+}
+
+// AddWhere add some privileges to given resource on rows satisfying the
+// condition. ErrConditionUnsupported is returned if the wrapped set does not
+// support conditional privileges, the failure is audited as well.
+func (s *AuditedPrivilegeSet) AddWhere(resource *ResourcePath, privilege Privilege, condition Expr) error {
+	var err error
+	e := s.event("AddWhere", resource, privilege, func() {
+		switch set := s.set.(type) {
+		case interface {
+			AddWhere(*ResourcePath, Privilege, Expr)
+		}:
+			set.AddWhere(resource, privilege, condition)
+		case interface {
+			AddWhere(*ResourcePath, Privilege, Expr) error
+		}:
+			err = set.AddWhere(resource, privilege, condition)
+		case *ExpiringPrivilegeSet:
+			err = set.AddWhereUntil(resource, privilege, condition, time.Time{}) // never expires
+		default:
+			err = ErrConditionUnsupported
+		}
+	})
+	e.Condition = condition.String()
+	if err != nil {
+		e.Error = err.Error()
+	}
+	s.log.emit(e)
+	return err
+}
+
+// Delete some privielges from all resources under the given resource name.
+func (s *AuditedPrivilegeSet) Delete(resource *ResourcePath, privilege Privilege) {
+	s.audit("Delete", resource, privilege, func() { s.set.Delete(resource, privilege) })
+}
+
+// Deny some privileges on given resource and all resources under it.
+func (s *AuditedPrivilegeSet) Deny(resource *ResourcePath, privilege Privilege) {
+	s.audit("Deny", resource, privilege, func() { s.set.Deny(resource, privilege) })
+}
+
+// Undeny removes denied privileges from all resources under the given resource name.
+func (s *AuditedPrivilegeSet) Undeny(resource *ResourcePath, privilege Privilege) {
+	s.audit("Undeny", resource, privilege, func() { s.set.Undeny(resource, privilege) })
+}
+
+// UnionWith combine all privileges of 2 privilege sets.
+func (s *AuditedPrivilegeSet) UnionWith(o PrivilegeSet) {
+	s.auditAll("UnionWith", func() { s.set.UnionWith(o) })
+}
+
+// DifferentWith delete all privileges from the given privilege set.
+func (s *AuditedPrivilegeSet) DifferentWith(o PrivilegeSet) {
+	s.auditAll("DifferentWith", func() { s.set.DifferentWith(o) })
+}
+
+// IntersectWith keeps only privileges also contained by the given privilege set.
+func (s *AuditedPrivilegeSet) IntersectWith(o PrivilegeSet) {
+	s.auditAll("IntersectWith", func() { s.set.IntersectWith(o) })
+}
+
+// SymmetricDifference keeps privileges contained by exactly one of the 2 privilege sets.
+func (s *AuditedPrivilegeSet) SymmetricDifference(o PrivilegeSet) {
+	s.auditAll("SymmetricDifference", func() { s.set.SymmetricDifference(o) })
+}
+
+// GlobalContain checks if root node have the given privileges.
+func (s *AuditedPrivilegeSet) GlobalContain(privilege Privilege) bool {
+	return s.set.GlobalContain(privilege)
+}
+
+// Contain checks if privileges set contains privileges on the given resource.
+func (s *AuditedPrivilegeSet) Contain(resource *ResourcePath, privilege Privilege) bool {
+	return s.set.Contain(resource, privilege)
+}
+
+// Contains checks if the privilege set contains all privileges from another set.
+func (s *AuditedPrivilegeSet) Contains(o PrivilegeSet) bool {
+	return s.set.Contains(o)
+}
+
+// Equal checks if 2 privilege sets contain each other.
+func (s *AuditedPrivilegeSet) Equal(o PrivilegeSet) bool {
+	return s.set.Equal(o)
+}
+
+// Powerless check set if don't has any privilege.
+func (s *AuditedPrivilegeSet) Powerless() bool {
+	return s.set.Powerless()
+}
+
+// Clone returns a deep copy of the wrapped privilege set, which is not
+// audited as changes made to it do not affect the principal.
+func (s *AuditedPrivilegeSet) Clone() PrivilegeSet {
+	return s.set.Clone()
+}
+
+func (s *AuditedPrivilegeSet) String() string {
+	return fmt.Sprint(s.set)
+}
+
+// changesOf returns privileges changed on every resource presented in either
+// tree, sorted by resource.
+func changesOf(before, after *PrivilegeTree) []AuditChange {
+	var resources []*ResourcePath
+	seen := make(map[string]bool)
+	collect := func(path *ResourcePath, _ Privilege) bool {
+		if name := path.String(); !seen[name] {
+			seen[name] = true
+			resources = append(resources, path)
+		}
+		return true
+	}
+	before.Walk(collect)
+	after.Walk(collect)
+
+	var changes []AuditChange
+	for _, resource := range resources {
+		b, a := privilegesOn(before, resource), privilegesOn(after, resource)
+		if b == a {
+			continue
+		}
+		change := AuditChange{Before: b, After: a}
+		if len(resource.Segs) > 0 {
+			change.Resource = resource.String()
+		}
+		changes = append(changes, change)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Resource < changes[j].Resource })
+	return changes
+}
+
+// privilegesOn returns all privileges the set contains on the resource.
+func privilegesOn(set PrivilegeSet, resource *ResourcePath) Privilege {
+	all := AllGlobalPrivileges
+	if len(resource.Segs) > 0 {
+		all = AllResourcePrivileges
+	}
+	return all &^ missingPrivilege(set, resource, all)
+}
+
+// AuditFile is an append-only file sink of audit log.
+type AuditFile struct {
+	path string
+	f    *os.File
+}
+
+// OpenAuditFile opens the file for appending audit events, the file is
+// created if not exists.
+func OpenAuditFile(path string) (*AuditFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditFile{path: path, f: f}, nil
+}
+
+// Write appends p to the file.
+func (f *AuditFile) Write(p []byte) (int, error) {
+	return f.f.Write(p)
+}
+
+// Sync commits written events to stable storage.
+func (f *AuditFile) Sync() error {
+	return f.f.Sync()
+}
+
+// Close closes the file.
+func (f *AuditFile) Close() error {
+	return f.f.Close()
+}
+
+// Query returns events of the principal in time range [from, to) in the
+// order they are written, a zero from or to means unbounded.
+func (f *AuditFile) Query(principal string, from, to time.Time) ([]*AuditEvent, error) {
+	r, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return QueryAuditEvents(r, principal, from, to)
+}
+
+// QueryAuditEvents reads audit events written by AuditLog from r, and returns
+// events of the principal in time range [from, to), a zero from or to means
+// unbounded.
+func QueryAuditEvents(r io.Reader, principal string, from, to time.Time) ([]*AuditEvent, error) {
+	var events []*AuditEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var e struct {
+			Msg string `json:"msg"`
+			AuditEvent
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid audit event at line %d: %w", line, err)
+		}
+		if e.Msg != auditMessage || e.Principal != principal {
+			continue
+		}
+		if (!from.IsZero() && e.Time.Before(from)) || (!to.IsZero() && !e.Time.Before(to)) {
+			continue
+		}
+		event := e.AuditEvent
+		events = append(events, &event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
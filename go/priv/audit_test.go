@@ -0,0 +1,170 @@
+package priv_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musenwill/exercise/priv"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestAuditedPrivilegeSet(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	var buf bytes.Buffer
+	log := priv.NewAuditLog(zapcore.AddSync(&buf), clock.Now)
+
+	set := log.Wrap(priv.NewPrivilegeTree(), "admin", "alice")
+	other := priv.NewPrivilegeTree()
+	other.Add(priv.CreateResourcePathUnsafe("yourdb"), priv.InsertPrivilege)
+
+	mydb := priv.CreateResourcePathUnsafe("mydb")
+	ops := []func(){
+		func() { set.AddGlobal(priv.AuditPrivilege | priv.ReadPrivilege) },
+		func() { set.Add(mydb, priv.InsertPrivilege) },
+		func() { set.WithActor("bob").Delete(mydb, priv.InsertPrivilege) },
+		func() { set.Deny(mydb, priv.SelectPrivilege) },
+		func() { set.Undeny(mydb, priv.SelectPrivilege) },
+		func() { set.DeleteGlobal(priv.AuditPrivilege) },
+		func() { set.UnionWith(other) },
+		func() { set.DifferentWith(other) },
+		func() { set.IntersectWith(other) },
+		func() { set.SymmetricDifference(other) },
+		func() { set.Clone().SetAll() },
+		func() { set.SetAll() },
+		func() { set.ClearAll() },
+		func() { log.Wrap(priv.NewPrivilegeTree(), "admin", "bob").SetAll() },
+		func() {
+			if err := set.AddWhere(mydb, priv.SelectPrivilege, mustParseExpr(t, `host = 'a'`)); err != nil {
+				t.Fatalf("add where got error '%v'", err)
+			}
+		},
+		func() {
+			// a set without conditional privileges fails and is audited
+			unsupported := log.Wrap(struct{ priv.PrivilegeSet }{priv.NewPrivilegeTree()}, "admin", "alice")
+			if err := unsupported.AddWhere(mydb, priv.SelectPrivilege, mustParseExpr(t, `host = 'a'`)); err != priv.ErrConditionUnsupported {
+				t.Fatalf("add where on unsupported set got error '%v'", err)
+			}
+		},
+	}
+	for _, op := range ops {
+		clock.Advance(time.Minute)
+		op()
+	}
+	if err := log.Sync(); err != nil {
+		t.Fatalf("sync audit log got error '%v'", err)
+	}
+
+	minute := func(n int) time.Time { return time.Date(2026, 10, 1, 0, n, 0, 0, time.UTC) }
+	read := priv.ReadPrivilege | priv.ReadGroupPrivileges&priv.AllResourcePrivileges
+	exp := []*priv.AuditEvent{
+		{Time: minute(1), Op: "AddGlobal", Actor: "admin", Principal: "alice", Privilege: priv.AuditPrivilege | priv.ReadPrivilege,
+			After: priv.AuditPrivilege | priv.ReadPrivilege | priv.ReadGroupPrivileges},
+		{Time: minute(2), Op: "Add", Actor: "admin", Principal: "alice", Resource: "mydb", Privilege: priv.InsertPrivilege,
+			Before: read, After: read | priv.InsertPrivilege},
+		{Time: minute(3), Op: "Delete", Actor: "bob", Principal: "alice", Resource: "mydb", Privilege: priv.InsertPrivilege,
+			Before: read | priv.InsertPrivilege, After: read},
+		{Time: minute(4), Op: "Deny", Actor: "admin", Principal: "alice", Resource: "mydb", Privilege: priv.SelectPrivilege,
+			Before: read, After: read &^ priv.SelectPrivilege},
+		{Time: minute(5), Op: "Undeny", Actor: "admin", Principal: "alice", Resource: "mydb", Privilege: priv.SelectPrivilege,
+			Before: read &^ priv.SelectPrivilege, After: read},
+		{Time: minute(6), Op: "DeleteGlobal", Actor: "admin", Principal: "alice", Privilege: priv.AuditPrivilege,
+			Before: priv.AuditPrivilege | priv.ReadPrivilege | priv.ReadGroupPrivileges, After: priv.ReadPrivilege | priv.ReadGroupPrivileges},
+		{Time: minute(7), Op: "UnionWith", Actor: "admin", Principal: "alice",
+			Before: priv.ReadPrivilege | priv.ReadGroupPrivileges, After: priv.ReadPrivilege | priv.ReadGroupPrivileges,
+			Changes: []priv.AuditChange{{Resource: "yourdb", Before: read, After: read | priv.InsertPrivilege}}},
+		{Time: minute(8), Op: "DifferentWith", Actor: "admin", Principal: "alice",
+			Before: priv.ReadPrivilege | priv.ReadGroupPrivileges, After: priv.ReadPrivilege | priv.ReadGroupPrivileges,
+			Changes: []priv.AuditChange{{Resource: "yourdb", Before: read | priv.InsertPrivilege, After: read}}},
+		{Time: minute(9), Op: "IntersectWith", Actor: "admin", Principal: "alice",
+			Before: priv.ReadPrivilege | priv.ReadGroupPrivileges,
+			Changes: []priv.AuditChange{{Before: priv.ReadPrivilege | priv.ReadGroupPrivileges},
+				{Resource: "mydb", Before: read}, {Resource: "yourdb", Before: read}}},
+		{Time: minute(10), Op: "SymmetricDifference", Actor: "admin", Principal: "alice",
+			Changes: []priv.AuditChange{{Resource: "yourdb", After: priv.InsertPrivilege}}},
+		{Time: minute(12), Op: "SetAll", Actor: "admin", Principal: "alice", After: priv.AllGlobalPrivileges,
+			Changes: []priv.AuditChange{{After: priv.AllGlobalPrivileges},
+				{Resource: "yourdb", Before: priv.InsertPrivilege, After: priv.AllResourcePrivileges}}},
+		{Time: minute(13), Op: "ClearAll", Actor: "admin", Principal: "alice", Before: priv.AllGlobalPrivileges,
+			Changes: []priv.AuditChange{{Before: priv.AllGlobalPrivileges}}},
+		{Time: minute(15), Op: "AddWhere", Actor: "admin", Principal: "alice", Resource: "mydb",
+			Privilege: priv.SelectPrivilege, Condition: "host = 'a'"},
+		{Time: minute(16), Op: "AddWhere", Actor: "admin", Principal: "alice", Resource: "mydb",
+			Privilege: priv.SelectPrivilege, Condition: "host = 'a'", Error: priv.ErrConditionUnsupported.Error()},
+	}
+
+	events, err := priv.QueryAuditEvents(bytes.NewReader(buf.Bytes()), "alice", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("query audit events got error '%v'", err)
+	}
+	if len(events) != len(exp) {
+		t.Fatalf("query audit events got %d events expect %d", len(events), len(exp))
+	}
+	for i := range exp {
+		if !reflect.DeepEqual(events[i], exp[i]) {
+			t.Fatalf("query audit events got %+v expect %+v", events[i], exp[i])
+		}
+	}
+
+	events, err = priv.QueryAuditEvents(bytes.NewReader(buf.Bytes()), "alice", minute(3), minute(5))
+	if err != nil {
+		t.Fatalf("query audit events got error '%v'", err)
+	}
+	if !reflect.DeepEqual(events, exp[2:4]) {
+		t.Fatalf("query audit events in range got %d events expect 2", len(events))
+	}
+
+	if _, err := priv.QueryAuditEvents(strings.NewReader("{}\nnot json\n"), "alice", time.Time{}, time.Time{}); err == nil ||
+		!strings.HasPrefix(err.Error(), "invalid audit event at line 2") {
+		t.Fatalf("query invalid audit events got error '%v'", err)
+	}
+}
+
+func TestAuditFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	// events written by earlier processes are kept
+	for i := 0; i < 2; i++ {
+		f, err := priv.OpenAuditFile(path)
+		if err != nil {
+			t.Fatalf("open audit file got error '%v'", err)
+		}
+		log := priv.NewAuditLog(f, clock.Now)
+		log.Wrap(priv.NewConcurrentPrivilegeSet(), "admin", "alice").Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+		log.Wrap(priv.NewPrivilegeTree(), "admin", "bob").AddGlobal(priv.SelectPrivilege)
+		clock.Advance(time.Hour)
+		if err := log.Sync(); err != nil {
+			t.Fatalf("sync audit log got error '%v'", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("close audit file got error '%v'", err)
+		}
+	}
+
+	f, err := priv.OpenAuditFile(path)
+	if err != nil {
+		t.Fatalf("open audit file got error '%v'", err)
+	}
+	defer f.Close()
+	events, err := f.Query("alice", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("query audit file got error '%v'", err)
+	}
+	if len(events) != 2 || !events[0].Time.Before(events[1].Time) {
+		t.Fatalf("query audit file got %d events expect 2 in order", len(events))
+	}
+	if e := events[1]; e.Resource != "mydb" || e.Op != "Add" || e.After != priv.SelectPrivilege {
+		t.Fatalf("query audit file got %+v", e)
+	}
+}
@@ -0,0 +1,181 @@
+package priv
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	// ErrDatabaseRequired is returned when a statement refers a measurement without
+	// database and no default database is given.
+	ErrDatabaseRequired = errors.New("database name required")
+	// ErrHostNotAllowed is returned when a user connects from an address
+	// outside hosts the user is bound to.
+	ErrHostNotAllowed = errors.New("host not allowed")
+)
+
+// RequiredPrivilege is a privilege required on a resource to execute a statement.
+type RequiredPrivilege struct {
+	// Resource on which the privilege is required, empty path means global resource.
+	Resource *ResourcePath
+
+	// Privilege required on the resource.
+	Privilege Privilege
+}
+
+// AuthorizationError is returned when privileges required by a statement are missing.
+type AuthorizationError struct {
+	// Statement failed to authorize.
+	Statement Statement
+
+	// Resource on which privilege is missing, empty path means global resource.
+	Resource *ResourcePath
+
+	// Privilege missing on the resource.
+	Privilege Privilege
+}
+
+// Error returns the string representation of the error.
+func (e *AuthorizationError) Error() string {
+	if len(e.Resource.Segs) == 0 {
+		return fmt.Sprintf("missing global privilege [%s]", e.Privilege)
+	}
+	return fmt.Sprintf("missing privilege [%s] on %s", e.Privilege, e.Resource)
+}
+
+// Authorizer decides if a statement is allowed to be executed with a privilege set.
+type Authorizer struct {
+	// Default database and retention policy used for measurements without them.
+	Database        string
+	RetentionPolicy string
+}
+
+// NewAuthorizer returns an authorizer which uses the given default database
+// and retention policy for measurements that omit them.
+func NewAuthorizer(database, retentionPolicy string) *Authorizer {
+	return &Authorizer{Database: database, RetentionPolicy: retentionPolicy}
+}
+
+// AuthorizeStatement returns nil if the privilege set contains all privileges
+// required by the statement, otherwise an *AuthorizationError naming the first
+// missing privilege is returned.
+func (a *Authorizer) AuthorizeStatement(set PrivilegeSet, stmt Statement) error {
+	required, err := a.RequiredPrivileges(stmt)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range required {
+		if missing := missingPrivilege(set, r.Resource, r.Privilege); missing != NoPrivilege {
+			return &AuthorizationError{Statement: stmt, Resource: r.Resource, Privilege: missing}
+		}
+	}
+	return nil
+}
+
+// AuthorizeHost returns nil if a client connecting from addr is allowed by
+// hosts, otherwise an error wrapping ErrHostNotAllowed is returned. The addr
+// is an IP address optionally followed by a port, e.g. 10.0.0.1:8086 or
+// [::1]:8086, an unparsable one is reported with ErrInvalidIP.
+func AuthorizeHost(hosts BindHosts, addr string) error {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: '%s'", ErrInvalidIP, addr)
+	}
+	if !hosts.Allow(ip) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, ip)
+	}
+	return nil
+}
+
+// missingPrivilege returns privileges not contained by set on the resource.
+func missingPrivilege(set PrivilegeSet, resource *ResourcePath, privilege Privilege) Privilege {
+	contain := func(p Privilege) bool {
+		if len(resource.Segs) == 0 {
+			return set.GlobalContain(p)
+		}
+		return set.Contain(resource, p)
+	}
+
+	if contain(privilege) {
+		return NoPrivilege
+	}
+
+	missing := NoPrivilege
+	for mask := Privilege(1); mask > 0 && mask <= privilege; mask <<= 1 {
+		if privilege&mask == mask && !contain(mask) {
+			missing |= mask
+		}
+	}
+	return missing
+}
+
+// RequiredPrivileges returns privileges required to execute the statement.
+func (a *Authorizer) RequiredPrivileges(stmt Statement) ([]RequiredPrivilege, error) {
+	global := func(privilege Privilege) ([]RequiredPrivilege, error) {
+		return []RequiredPrivilege{{Resource: NewResourcePath(), Privilege: privilege}}, nil
+	}
+
+	switch stmt := stmt.(type) {
+	case *SelectStatement:
+		required := make([]RequiredPrivilege, 0, len(stmt.Sources))
+		for _, m := range stmt.Sources {
+			resource, err := a.measurementResource(m)
+			if err != nil {
+				return nil, err
+			}
+			required = append(required, RequiredPrivilege{Resource: resource, Privilege: SelectPrivilege})
+		}
+		return required, nil
+	case *InsertStatement:
+		return a.onMeasurement(stmt.Into, InsertPrivilege)
+	case *DeleteStatement:
+		return a.onMeasurement(stmt.Source, DeletePrivilege)
+	case *DropMeasurementStatement:
+		return a.onMeasurement(stmt.Measurement, DropPrivilege)
+	case *DropDatabaseStatement:
+		return []RequiredPrivilege{{Resource: NewResourcePath(stmt.Name), Privilege: DropPrivilege}}, nil
+	case *CreateDatabaseStatement:
+		return global(CreateDatabasePrivilege)
+	case *ShowDatabasesStatement:
+		return global(ShowDatabasesPrivilege)
+	case *ShowContinuousQueriesStatement:
+		return global(ShowCQSPrivilege)
+	case *ShowUsersStatement:
+		return global(ShowUsersPrivilege)
+	case *ShowRolesStatement:
+		return global(ShowRolesPrivilege)
+	case *ShowGrantsStatement:
+		if stmt.Role {
+			return global(ShowRolesPrivilege)
+		}
+		return global(ShowUsersPrivilege)
+	case *CreateUserStatement, *DropUserStatement, *AlterUserStatement:
+		return global(CreateUserPrivilege)
+	case *CreateRoleStatement, *DropRoleStatement:
+		return global(CreateRolePrivilege)
+	case *GrantStatement, *RevokeStatement, *GrantRoleStatement, *RevokeRoleStatement:
+		return global(GrantPrivilege)
+	}
+	return nil, fmt.Errorf("unsupported statement %T", stmt)
+}
+
+func (a *Authorizer) onMeasurement(m *Measurement, privilege Privilege) ([]RequiredPrivilege, error) {
+	resource, err := a.measurementResource(m)
+	if err != nil {
+		return nil, err
+	}
+	return []RequiredPrivilege{{Resource: resource, Privilege: privilege}}, nil
+}
+
+func (a *Authorizer) measurementResource(m *Measurement) (*ResourcePath, error) {
+	if m.Database == "" && a.Database == "" {
+		return nil, fmt.Errorf("%w for measurement %s", ErrDatabaseRequired, QuoteIdent(m.Name))
+	}
+	return m.Resource(a.Database, a.RetentionPolicy), nil
+}
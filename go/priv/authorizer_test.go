@@ -0,0 +1,150 @@
+package priv_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestAuthorizeStatement(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(priv.ShowUsersPrivilege | priv.ReadPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.InsertPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.ReadPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.DeletePrivilege|priv.DropPrivilege)
+
+	var tests = []struct {
+		s         string
+		resource  string
+		privilege priv.Privilege
+	}{
+		{s: `SELECT * FROM cpu, mem`},
+		{s: `SELECT * FROM yourdb.daily.cpu`},
+		{s: `SELECT * FROM cpu, secret`, resource: "mydb.autogen.secret", privilege: priv.SelectPrivilege},
+		{s: `INSERT INTO autogen.cpu`},
+		{s: `INSERT INTO yourdb..cpu`, resource: "yourdb.autogen.cpu", privilege: priv.InsertPrivilege},
+		{s: `DELETE FROM cpu`},
+		{s: `DELETE FROM mem`, resource: "mydb.autogen.mem", privilege: priv.DeletePrivilege},
+		{s: `DROP MEASUREMENT cpu`},
+		{s: `DROP DATABASE mydb`, resource: "mydb", privilege: priv.DropPrivilege},
+		{s: `CREATE DATABASE yourdb`, privilege: priv.CreateDatabasePrivilege},
+		{s: `SHOW DATABASES`},
+		{s: `SHOW USERS`},
+		{s: `SHOW ROLES`, privilege: priv.ShowRolesPrivilege},
+		{s: `SHOW GRANTS FOR bob`},
+		{s: `SHOW GRANTS FOR ROLE ops`, privilege: priv.ShowRolesPrivilege},
+		{s: `SHOW CONTINUOUS QUERIES`, privilege: priv.ShowCQSPrivilege},
+		{s: `CREATE USER bob WITH PASSWORD 'secret'`, privilege: priv.CreateUserPrivilege},
+		{s: `DROP ROLE ops`, privilege: priv.CreateRolePrivilege},
+		{s: `GRANT SELECT ON mydb TO bob`, privilege: priv.GrantPrivilege},
+	}
+
+	a := priv.NewAuthorizer("mydb", "")
+	for _, test := range tests {
+		stmt, err := priv.ParseStatement(test.s)
+		if err != nil {
+			t.Fatalf("parse %s got error '%v'", test.s, err)
+		}
+
+		err = a.AuthorizeStatement(set, stmt)
+		if test.privilege == priv.NoPrivilege {
+			if err != nil {
+				t.Fatalf("authorize %s got error '%v'", test.s, err)
+			}
+			continue
+		}
+
+		var authErr *priv.AuthorizationError
+		if !errors.As(err, &authErr) {
+			t.Fatalf("authorize %s got error '%v' expect authorization error", test.s, err)
+		}
+		if act, exp := authErr.Resource.String(), test.resource; act != exp {
+			t.Fatalf("authorize %s got missing resource %s expect %s", test.s, act, exp)
+		}
+		if act, exp := authErr.Privilege, test.privilege; act != exp {
+			t.Fatalf("authorize %s got missing privilege %s expect %s", test.s, act, exp)
+		}
+		if authErr.Statement != stmt {
+			t.Fatalf("authorize %s got error of different statement", test.s)
+		}
+	}
+}
+
+func TestAuthorizeStatementMissingBits(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(priv.ShowUsersPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+
+	err := priv.NewAuthorizer("", "").AuthorizeStatement(set, &priv.GrantStatement{})
+	if act, exp := err.Error(), "missing global privilege [GRANT]"; act != exp {
+		t.Fatalf("authorize got error '%s' expect '%s'", act, exp)
+	}
+
+	stmt, _ := priv.ParseStatement(`SELECT * FROM mydb.autogen.cpu`)
+	if err := priv.NewAuthorizer("", "").AuthorizeStatement(set, stmt); err != nil {
+		t.Fatalf("authorize got error '%v'", err)
+	}
+
+	stmt, _ = priv.ParseStatement(`SELECT * FROM cpu`)
+	err = priv.NewAuthorizer("", "").AuthorizeStatement(set, stmt)
+	if !errors.Is(err, priv.ErrDatabaseRequired) {
+		t.Fatalf("authorize got error '%v' expect '%v'", err, priv.ErrDatabaseRequired)
+	}
+
+	stmt, _ = priv.ParseStatement(`DELETE FROM cpu`)
+	err = priv.NewAuthorizer("mydb", "daily").AuthorizeStatement(set, stmt)
+	if act, exp := err.Error(), "missing privilege [DELETE] on mydb.daily.cpu"; act != exp {
+		t.Fatalf("authorize got error '%s' expect '%s'", act, exp)
+	}
+}
+
+func TestRequiredPrivileges(t *testing.T) {
+	stmt, _ := priv.ParseStatement(`SELECT * FROM cpu, yourdb..mem`)
+	required, err := priv.NewAuthorizer("mydb", "").RequiredPrivileges(stmt)
+	if err != nil {
+		t.Fatalf("required privileges got error '%v'", err)
+	}
+
+	exp := []string{"SELECT on mydb.autogen.cpu", "SELECT on yourdb.autogen.mem"}
+	if len(required) != len(exp) {
+		t.Fatalf("required privileges got %v expect %v", required, exp)
+	}
+	for i, r := range required {
+		if act := r.Privilege.String() + " on " + r.Resource.String(); act != exp[i] {
+			t.Fatalf("required privileges got %s expect %s", act, exp[i])
+		}
+	}
+}
+
+func TestAuthorizeHost(t *testing.T) {
+	hosts, err := priv.ParseBindHosts([]string{"10.0.0.0/8", "::1"})
+	if err != nil {
+		t.Fatalf("parse bind hosts got error '%v'", err)
+	}
+
+	var tests = []struct {
+		addr string
+		err  error
+	}{
+		{addr: "10.0.0.1"},
+		{addr: "10.0.0.1:8086"},
+		{addr: "[::1]:8086"},
+		{addr: "::1"},
+		{addr: "192.168.1.1:8086", err: priv.ErrHostNotAllowed},
+		{addr: "[fe80::1]:8086", err: priv.ErrHostNotAllowed},
+		{addr: "localhost:8086", err: priv.ErrInvalidIP},
+		{addr: "", err: priv.ErrInvalidIP},
+	}
+
+	for _, test := range tests {
+		err := priv.AuthorizeHost(hosts, test.addr)
+		if test.err == nil && err != nil || !errors.Is(err, test.err) {
+			t.Fatalf("authorize host %s got error '%v' expect '%v'", test.addr, err, test.err)
+		}
+	}
+
+	if err := priv.AuthorizeHost(nil, "8.8.8.8:53"); err != nil {
+		t.Fatalf("authorize host without bind hosts got error '%v'", err)
+	}
+}
@@ -0,0 +1,69 @@
+package priv
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// BindHosts is the set of client addresses a user may connect from, an empty
+// set allows any address.
+type BindHosts []*net.IPNet
+
+// ParseBindHost parses an IPv4 or IPv6 address, or a network in CIDR notation
+// such as 10.0.0.0/8. A single address is bound as a network of itself only.
+// Errors returned wrap ErrInvalidIP.
+func ParseBindHost(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: '%s'", ErrInvalidIP, s)
+		}
+		return network, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidIP, s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(8*net.IPv4len, 8*net.IPv4len)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(8*net.IPv6len, 8*net.IPv6len)}, nil
+}
+
+// ParseBindHosts parses each of hosts with ParseBindHost.
+func ParseBindHosts(hosts []string) (BindHosts, error) {
+	b := make(BindHosts, 0, len(hosts))
+	for _, s := range hosts {
+		network, err := ParseBindHost(s)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, network)
+	}
+	return b, nil
+}
+
+// Allow checks if the address is in any network of the set, which is always
+// true for an empty set.
+func (b BindHosts) Allow(ip net.IP) bool {
+	if len(b) == 0 {
+		return true
+	}
+	for _, network := range b {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns networks of the set in CIDR notation separated by commas.
+func (b BindHosts) String() string {
+	hosts := make([]string, 0, len(b))
+	for _, network := range b {
+		hosts = append(hosts, network.String())
+	}
+	return strings.Join(hosts, ", ")
+}
@@ -0,0 +1,81 @@
+package priv_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestParseBindHost(t *testing.T) {
+	var tests = []struct {
+		s       string
+		network string
+	}{
+		{s: "10.0.0.0/8", network: "10.0.0.0/8"},
+		{s: "10.1.2.3/8", network: "10.0.0.0/8"},
+		{s: "192.168.1.5", network: "192.168.1.5/32"},
+		{s: "fd00::/8", network: "fd00::/8"},
+		{s: "::1", network: "::1/128"},
+		{s: "::ffff:10.0.0.1", network: "10.0.0.1/32"},
+		{s: ""},
+		{s: "localhost"},
+		{s: "10.0.0.256"},
+		{s: "10.0.0.0/33"},
+		{s: "fd00::/129"},
+		{s: "10.0.0.0/"},
+	}
+
+	for _, test := range tests {
+		network, err := priv.ParseBindHost(test.s)
+		if test.network == "" {
+			if !errors.Is(err, priv.ErrInvalidIP) {
+				t.Fatalf("parse bind host '%s' got error '%v' expect '%v'", test.s, err, priv.ErrInvalidIP)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parse bind host '%s' got error '%v'", test.s, err)
+		}
+		if act, exp := network.String(), test.network; act != exp {
+			t.Fatalf("parse bind host '%s' got %s expect %s", test.s, act, exp)
+		}
+	}
+}
+
+func TestBindHostsAllow(t *testing.T) {
+	hosts, err := priv.ParseBindHosts([]string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"})
+	if err != nil {
+		t.Fatalf("parse bind hosts got error '%v'", err)
+	}
+	if act, exp := hosts.String(), "10.0.0.0/8, 192.168.1.5/32, fd00::/8"; act != exp {
+		t.Fatalf("bind hosts got %s expect %s", act, exp)
+	}
+
+	var tests = []struct {
+		ip    string
+		allow bool
+	}{
+		{ip: "10.20.30.40", allow: true},
+		{ip: "::ffff:10.20.30.40", allow: true},
+		{ip: "11.0.0.1", allow: false},
+		{ip: "192.168.1.5", allow: true},
+		{ip: "192.168.1.6", allow: false},
+		{ip: "fd12::1", allow: true},
+		{ip: "fe80::1", allow: false},
+	}
+	for _, test := range tests {
+		if act := hosts.Allow(net.ParseIP(test.ip)); act != test.allow {
+			t.Fatalf("bind hosts %s allow %s got %v expect %v", hosts, test.ip, act, test.allow)
+		}
+	}
+
+	if !priv.BindHosts(nil).Allow(net.ParseIP("8.8.8.8")) {
+		t.Fatalf("expect empty bind hosts allow any address")
+	}
+
+	if _, err := priv.ParseBindHosts([]string{"10.0.0.0/8", "bad"}); !errors.Is(err, priv.ErrInvalidIP) {
+		t.Fatalf("parse bind hosts got error '%v' expect '%v'", err, priv.ErrInvalidIP)
+	}
+}
@@ -0,0 +1,193 @@
+package priv
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentPrivilegeSet is an implementation of PrivilegeSet which is safe for
+// concurrent use. Reads are served from an immutable snapshot without locking,
+// while writes copy the snapshot, modify the copy and swap it in atomically,
+// so readers never block and never see a partially applied change.
+type ConcurrentPrivilegeSet struct {
+	mu       sync.Mutex   // serializes writers
+	snapshot atomic.Value // *PrivilegeTree which is never modified once stored
+}
+
+// NewConcurrentPrivilegeSet create an empty concurrent privilege set.
+func NewConcurrentPrivilegeSet() *ConcurrentPrivilegeSet {
+	s := &ConcurrentPrivilegeSet{}
+	s.snapshot.Store(NewPrivilegeTree())
+	return s
+}
+
+func (s *ConcurrentPrivilegeSet) implPrivilegeSet() {
+	var _ PrivilegeSet = (*ConcurrentPrivilegeSet)(nil)
+}
+
+func (s *ConcurrentPrivilegeSet) load() *PrivilegeTree {
+	return s.snapshot.Load().(*PrivilegeTree)
+}
+
+// Snapshot returns the current privileges, the returned tree is shared with
+// other readers and must not be modified.
+func (s *ConcurrentPrivilegeSet) Snapshot() *PrivilegeTree {
+	return s.load()
+}
+
+// Update applies fn to a copy of the current privileges and publishes the
+// result, all modifications made by fn become visible to readers at once.
+func (s *ConcurrentPrivilegeSet) Update(fn func(set PrivilegeSet)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree := s.load().clone()
+	fn(tree)
+	s.snapshot.Store(tree)
+}
+
+// SetAll set full privileges to privilege set.
+func (s *ConcurrentPrivilegeSet) SetAll() {
+	s.Update(func(set PrivilegeSet) { set.SetAll() })
+}
+
+// ClearAll clear all privileges from privilege set.
+func (s *ConcurrentPrivilegeSet) ClearAll() {
+	s.Update(func(set PrivilegeSet) { set.ClearAll() })
+}
+
+// AddGlobal add some privileges to global resource.
+func (s *ConcurrentPrivilegeSet) AddGlobal(privilege Privilege) {
+	s.Update(func(set PrivilegeSet) { set.AddGlobal(privilege) })
+}
+
+// DeleteGlobal delete some privileges from all resources.
+func (s *ConcurrentPrivilegeSet) DeleteGlobal(privilege Privilege) {
+	s.Update(func(set PrivilegeSet) { set.DeleteGlobal(privilege) })
+}
+
+// Add some privileges to given resource.
+func (s *ConcurrentPrivilegeSet) Add(resource *ResourcePath, privilege Privilege) {
+	s.Update(func(set PrivilegeSet) { set.Add(resource, privilege) })
+}
+
+// AddWhere add some privileges to given resource on rows satisfying the condition.
+func (s *ConcurrentPrivilegeSet) AddWhere(resource *ResourcePath, privilege Privilege, condition Expr) {
+	s.Update(func(set PrivilegeSet) { set.(*PrivilegeTree).AddWhere(resource, privilege, condition) })
+}
+
+// Delete some privielges from all resources under the given resource name.
+func (s *ConcurrentPrivilegeSet) Delete(resource *ResourcePath, privilege Privilege) {
+	s.Update(func(set PrivilegeSet) { set.Delete(resource, privilege) })
+}
+
+// Deny some privileges on given resource and all resources under it.
+func (s *ConcurrentPrivilegeSet) Deny(resource *ResourcePath, privilege Privilege) {
+	s.Update(func(set PrivilegeSet) { set.Deny(resource, privilege) })
+}
+
+// Undeny removes denied privileges from all resources under the given resource name.
+func (s *ConcurrentPrivilegeSet) Undeny(resource *ResourcePath, privilege Privilege) {
+	s.Update(func(set PrivilegeSet) { set.Undeny(resource, privilege) })
+}
+
+// UnionWith combine all privileges of 2 privilege sets.
+func (s *ConcurrentPrivilegeSet) UnionWith(o PrivilegeSet) {
+	other := treeOf(o)
+	s.Update(func(set PrivilegeSet) { set.UnionWith(other) })
+}
+
+// DifferentWith delete all privileges from the given privilege set.
+func (s *ConcurrentPrivilegeSet) DifferentWith(o PrivilegeSet) {
+	other := treeOf(o)
+	s.Update(func(set PrivilegeSet) { set.DifferentWith(other) })
+}
+
+// IntersectWith keeps only privileges also contained by the given privilege set.
+func (s *ConcurrentPrivilegeSet) IntersectWith(o PrivilegeSet) {
+	other := treeOf(o)
+	s.Update(func(set PrivilegeSet) { set.IntersectWith(other) })
+}
+
+// SymmetricDifference keeps privileges contained by exactly one of the 2 privilege sets.
+func (s *ConcurrentPrivilegeSet) SymmetricDifference(o PrivilegeSet) {
+	other := treeOf(o)
+	s.Update(func(set PrivilegeSet) { set.SymmetricDifference(other) })
+}
+
+// GlobalContain checks if root node have the given privileges.
+func (s *ConcurrentPrivilegeSet) GlobalContain(privilege Privilege) bool {
+	return s.load().GlobalContain(privilege)
+}
+
+// Contain checks if privileges set contains privileges on the given resource.
+func (s *ConcurrentPrivilegeSet) Contain(resource *ResourcePath, privilege Privilege) bool {
+	return s.load().Contain(resource, privilege)
+}
+
+// ContainWith checks if privileges set contains privileges on rows of the
+// given resource with the given tags.
+func (s *ConcurrentPrivilegeSet) ContainWith(resource *ResourcePath, privilege Privilege, tags map[string]string) bool {
+	return s.load().ContainWith(resource, privilege, tags)
+}
+
+// Contains checks if the privilege set contains all privileges from another set.
+func (s *ConcurrentPrivilegeSet) Contains(o PrivilegeSet) bool {
+	return s.load().Contains(o)
+}
+
+// Equal checks if 2 privilege sets contain each other.
+func (s *ConcurrentPrivilegeSet) Equal(o PrivilegeSet) bool {
+	return s.load().Equal(o)
+}
+
+// Walk visits every node of the current snapshot with its effective privileges.
+func (s *ConcurrentPrivilegeSet) Walk(fn func(path *ResourcePath, effective Privilege) bool) {
+	s.load().Walk(fn)
+}
+
+// Normalize publishes the normalized form of the current privileges.
+func (s *ConcurrentPrivilegeSet) Normalize() {
+	s.Update(func(set PrivilegeSet) { set.(*PrivilegeTree).Normalize() })
+}
+
+// Clone returns a concurrent privilege set starting with the current
+// snapshot, which is shared as it is never modified.
+func (s *ConcurrentPrivilegeSet) Clone() PrivilegeSet {
+	c := &ConcurrentPrivilegeSet{}
+	c.snapshot.Store(s.load())
+	return c
+}
+
+// Powerless check set if don't has any privilege.
+func (s *ConcurrentPrivilegeSet) Powerless() bool {
+	return s.load().Powerless()
+}
+
+func (s *ConcurrentPrivilegeSet) String() string {
+	return s.load().String()
+}
+
+// treeOf returns the privilege tree holding privileges of the set, the
+// snapshot of a concurrent set is returned which must not be modified, and
+// privileges not expired are returned for an expiring set.
+func treeOf(s PrivilegeSet) *PrivilegeTree {
+	switch s := s.(type) {
+	case *ConcurrentPrivilegeSet:
+		return s.load()
+	case *ExpiringPrivilegeSet:
+		return s.Active()
+	case *AuditedPrivilegeSet:
+		return treeOf(s.set)
+	}
+	return s.(*PrivilegeTree)
+}
+
+// copyTreeOf returns a copy of the privilege tree holding privileges of the
+// set, which can be modified freely.
+func copyTreeOf(s PrivilegeSet) *PrivilegeTree {
+	if e, ok := s.(*ExpiringPrivilegeSet); ok {
+		return e.Active() // already a copy
+	}
+	return treeOf(s).clone()
+}
@@ -0,0 +1,183 @@
+package priv_test
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestConcurrentPrivilegeSet(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		set, tree := priv.NewConcurrentPrivilegeSet(), priv.NewPrivilegeTree()
+		for j := r.Intn(32); j > 0; j-- {
+			resource, privilege := randomResourcePath(r), randomPrivilege(r)
+			switch r.Intn(6) {
+			case 0:
+				set.AddGlobal(privilege)
+				tree.AddGlobal(privilege)
+			case 1:
+				set.DeleteGlobal(privilege)
+				tree.DeleteGlobal(privilege)
+			case 2:
+				set.Add(resource, privilege)
+				tree.Add(resource, privilege)
+			case 3:
+				set.Delete(resource, privilege)
+				tree.Delete(resource, privilege)
+			case 4:
+				other := randomPrivilegeTree(r)
+				set.UnionWith(other)
+				tree.UnionWith(other)
+			case 5:
+				other := randomPrivilegeTree(r)
+				set.DifferentWith(other)
+				tree.DifferentWith(other)
+			}
+
+			if !reflect.DeepEqual(set.Snapshot(), tree) {
+				t.Fatalf("concurrent privilege set got %s expect %s", set, tree)
+			}
+			if act, exp := set.Contain(resource, privilege), tree.Contain(resource, privilege); act != exp {
+				t.Fatalf("concurrent privilege set %s contain [%s] on %s got %v expect %v", set, privilege, resource, act, exp)
+			}
+		}
+	}
+}
+
+func TestConcurrentPrivilegeSetSnapshot(t *testing.T) {
+	set := priv.NewConcurrentPrivilegeSet()
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+
+	snapshot := set.Snapshot()
+	set.Delete(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	if !snapshot.Contain(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege) {
+		t.Fatalf("expect snapshot not affected by later modifications")
+	}
+
+	// checking a concurrent set does not modify it
+	other := priv.NewConcurrentPrivilegeSet()
+	other.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	if set.Contains(other) || priv.NewPrivilegeTree().Contains(other) {
+		t.Fatalf("expect empty set not contains %s", other)
+	}
+	if other.Powerless() {
+		t.Fatalf("expect concurrent set not modified by contains")
+	}
+
+	set.UnionWith(set)
+	set.UnionWith(other)
+	if !set.Contains(other) {
+		t.Fatalf("expect %s contains %s", set, other)
+	}
+}
+
+// TestConcurrentPrivilegeSetRace should be run with -race.
+func TestConcurrentPrivilegeSetRace(t *testing.T) {
+	set := priv.NewConcurrentPrivilegeSet()
+	cpu, mem := priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.CreateResourcePathUnsafe("mydb.autogen.mem")
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// cpu and mem are always granted together
+				snapshot := set.Snapshot()
+				if snapshot.Contain(cpu, priv.SelectPrivilege) != snapshot.Contain(mem, priv.SelectPrivilege) {
+					t.Errorf("got partially applied update %s", snapshot)
+					return
+				}
+				set.Contain(cpu, priv.SelectPrivilege)
+				set.GlobalContain(priv.GrantPrivilege)
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for j := 0; j < 500; j++ {
+				set.Update(func(set priv.PrivilegeSet) {
+					if set.Contain(cpu, priv.SelectPrivilege) {
+						set.Delete(cpu, priv.SelectPrivilege)
+						set.Delete(mem, priv.SelectPrivilege)
+					} else {
+						set.Add(cpu, priv.SelectPrivilege)
+						set.Add(mem, priv.SelectPrivilege)
+					}
+				})
+				set.AddGlobal(priv.GrantPrivilege)
+				set.DeleteGlobal(priv.GrantPrivilege)
+			}
+		}()
+	}
+	writers.Wait()
+	close(done)
+	wg.Wait()
+
+	if set.Contain(cpu, priv.SelectPrivilege) || set.GlobalContain(priv.GrantPrivilege) {
+		t.Fatalf("expect all privileges revoked after even number of updates, got %s", set)
+	}
+}
+
+// 8   	 8786606	       136.4 ns/op	       0 B/op	       0 allocs/op
+func BenchmarkConcurrentPrivilegeSetContainDeepPath(b *testing.B) {
+	set := priv.NewConcurrentPrivilegeSet()
+	set.AddGlobal(priv.GrantPrivilege | priv.InsertPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.DeletePrivilege|priv.DropPrivilege|priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.mem"), priv.DeletePrivilege|priv.DropPrivilege|priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("yourdb.daily"), priv.SelectPrivilege)
+
+	resource := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Contain(resource, priv.DeletePrivilege|priv.DropPrivilege|priv.SelectPrivilege)
+	}
+}
+
+func BenchmarkConcurrentPrivilegeSetContainParallel(b *testing.B) {
+	set := priv.NewConcurrentPrivilegeSet()
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.DeletePrivilege|priv.DropPrivilege)
+	resource := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+
+	// a writer keeps updating privileges of other resources
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		other := priv.CreateResourcePathUnsafe("yourdb.daily")
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				set.Add(other, priv.SelectPrivilege)
+				set.Delete(other, priv.SelectPrivilege)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			set.Contain(resource, priv.DropPrivilege|priv.SelectPrivilege)
+		}
+	})
+}
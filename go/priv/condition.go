@@ -0,0 +1,276 @@
+package priv
+
+import (
+	"sort"
+)
+
+// ConditionalPrivilege is privileges granted only on rows whose tags satisfy
+// the condition, e.g. SELECT on rows where host =~ /^web/.
+type ConditionalPrivilege struct {
+	Condition Expr
+	Privilege Privilege
+}
+
+// AddWhere add some privileges to given resource on rows satisfying the
+// condition only, which are checked by ContainWith. Privileges granted under
+// the same condition on the same resource are combined, so are privileges
+// under the same condition on resources under it, which override the
+// resource.
+func (t *PrivilegeTree) AddWhere(resource *ResourcePath, privilege Privilege, condition Expr) {
+	privilege &= AllResourcePrivileges
+	if privilege == NoPrivilege {
+		return
+	}
+
+	key := condition.String()
+	_, conditions, targets := t.targets(resource)
+	for _, t := range targets {
+		granted := privilege | conditionPrivilege(t.inheritConditions(conditions), key)
+		if t.Conditions == nil {
+			t.Conditions = make(map[string]*ConditionalPrivilege)
+		}
+		t.Conditions[key] = &ConditionalPrivilege{Condition: condition, Privilege: granted}
+		t.tidyConditions(conditions)
+		t.addConditionBelow(key, privilege)
+	}
+}
+
+// addConditionBelow add some privileges to conditions of the given key on all
+// nodes under t, which override the condition of t.
+func (t *PrivilegeTree) addConditionBelow(key string, privilege Privilege) {
+	for _, v := range t.Tree {
+		if v != nil {
+			if c := v.Conditions[key]; c != nil {
+				v.addCondition(c.Condition, privilege)
+			}
+			v.addConditionBelow(key, privilege)
+		}
+	}
+	for _, v := range t.Patterns {
+		if v != nil {
+			if c := v.Conditions[key]; c != nil {
+				v.addCondition(c.Condition, privilege)
+			}
+		}
+	}
+}
+
+// ContainWith checks if privileges set contains privileges on rows of the
+// given resource with the given tags. Privileges granted without condition
+// are checked first, conditions along the path are only evaluated if they
+// are not enough. A condition which fails to evaluate, e.g. refers a tag
+// missing from tags, grants nothing.
+func (t *PrivilegeTree) ContainWith(resource *ResourcePath, privilege Privilege, tags map[string]string) bool {
+	sum, denied := t.lookup(resource)
+	if t.effective(sum, denied)&privilege == privilege {
+		return true
+	}
+
+	conditions := t.conditions(resource)
+	if len(conditions) == 0 {
+		return false
+	}
+	values := make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		values[k] = v
+	}
+	for _, c := range conditions {
+		if c.Privilege&^sum == NoPrivilege {
+			continue
+		}
+		if ok, err := EvalBool(c.Condition, values); err == nil && ok {
+			sum |= c.Privilege
+		}
+	}
+	return t.effective(sum, denied)&privilege == privilege
+}
+
+// conditions returns conditional privileges in effect on the given resource,
+// nodes not presented in Tree take conditions from patterns they match.
+func (t *PrivilegeTree) conditions(resource *ResourcePath) map[string]*ConditionalPrivilege {
+	conditions := t.inheritConditions(nil)
+	for _, seg := range resource.Segs {
+		if t.Tree[seg] == nil {
+			return t.matchConditions(seg, conditions)
+		}
+		t = t.Tree[seg]
+		conditions = t.inheritConditions(conditions)
+	}
+	if resource.Regex != nil {
+		if p := t.Patterns[resource.Regex.String()]; p != nil {
+			conditions = p.inheritConditions(conditions)
+		}
+	}
+	return conditions
+}
+
+// inheritConditions returns conditional privileges in effect on the node, that
+// is conditions in effect on its parent overridden by conditions of the node.
+// inherited is never modified.
+func (t *PrivilegeTree) inheritConditions(inherited map[string]*ConditionalPrivilege) map[string]*ConditionalPrivilege {
+	if len(t.Conditions) == 0 {
+		return inherited
+	}
+	conditions := make(map[string]*ConditionalPrivilege, len(inherited)+len(t.Conditions))
+	for k, c := range inherited {
+		conditions[k] = c
+	}
+	for k, c := range t.Conditions {
+		conditions[k] = c
+	}
+	return conditions
+}
+
+// conditionPrivilege returns privileges of the condition of the given key,
+// or NoPrivilege if not exists.
+func conditionPrivilege(conditions map[string]*ConditionalPrivilege, key string) Privilege {
+	if c := conditions[key]; c != nil {
+		return c.Privilege
+	}
+	return NoPrivilege
+}
+
+// setConditions sets conditions of the node, so that conditions in effect on
+// it are the given ones. Only conditions different from inherited ones, which
+// are conditions in effect on its parent, are kept.
+func (t *PrivilegeTree) setConditions(conditions, inherited map[string]*ConditionalPrivilege) {
+	t.Conditions = nil
+	set := func(key string, c *ConditionalPrivilege) {
+		if t.Conditions == nil {
+			t.Conditions = make(map[string]*ConditionalPrivilege)
+		}
+		t.Conditions[key] = c
+	}
+	for k, c := range conditions {
+		if c.Privilege != conditionPrivilege(inherited, k) {
+			set(k, c)
+		}
+	}
+	for k, c := range inherited {
+		if _, exist := conditions[k]; !exist && c.Privilege != NoPrivilege {
+			set(k, &ConditionalPrivilege{Condition: c.Condition})
+		}
+	}
+}
+
+// tidyConditions removes conditions of the node which are the same as
+// inherited ones.
+func (t *PrivilegeTree) tidyConditions(inherited map[string]*ConditionalPrivilege) {
+	if len(t.Conditions) > 0 {
+		t.setConditions(t.inheritConditions(inherited), inherited)
+	}
+}
+
+// addCondition grants privileges under the condition on the node.
+func (t *PrivilegeTree) addCondition(condition Expr, privilege Privilege) {
+	key := condition.String()
+	if t.Conditions == nil {
+		t.Conditions = make(map[string]*ConditionalPrivilege)
+	}
+	if c := t.Conditions[key]; c != nil {
+		// conditions are shared by clones, so they are replaced rather than
+		// modified in place
+		privilege |= c.Privilege
+	}
+	t.Conditions[key] = &ConditionalPrivilege{Condition: condition, Privilege: privilege}
+}
+
+// deleteConditions delete some privileges from conditions of the node,
+// conditions left the same as inherited ones are removed.
+func (t *PrivilegeTree) deleteConditions(privilege Privilege, inherited map[string]*ConditionalPrivilege) {
+	for k, c := range t.Conditions {
+		if c.Privilege&privilege != NoPrivilege {
+			t.Conditions[k] = &ConditionalPrivilege{Condition: c.Condition, Privilege: c.Privilege &^ privilege}
+		}
+	}
+	t.tidyConditions(inherited)
+}
+
+// revokeConditions delete some privileges from conditions in effect on the
+// node, conditions inherited from its parents are overridden on the node
+// without these privileges.
+func (t *PrivilegeTree) revokeConditions(privilege Privilege, inherited map[string]*ConditionalPrivilege) {
+	conditions := make(map[string]*ConditionalPrivilege)
+	for k, c := range t.inheritConditions(inherited) {
+		if c.Privilege&privilege != NoPrivilege {
+			c = &ConditionalPrivilege{Condition: c.Condition, Privilege: c.Privilege &^ privilege}
+		}
+		conditions[k] = c
+	}
+	t.setConditions(conditions, inherited)
+}
+
+// matchConditions returns conditional privileges in effect on a child not
+// presented in Tree, that is union of conditions in effect on all patterns
+// matching the name, or inherited if no pattern matches. inherited is
+// conditions in effect on t.
+func (t *PrivilegeTree) matchConditions(name string, inherited map[string]*ConditionalPrivilege) map[string]*ConditionalPrivilege {
+	var conditions map[string]*ConditionalPrivilege
+	for _, v := range t.Patterns {
+		if v == nil || v.redundant() || !v.Regex.MatchString(name) {
+			continue
+		}
+		if conditions == nil {
+			conditions = make(map[string]*ConditionalPrivilege)
+		}
+		for k, c := range v.inheritConditions(inherited) {
+			if old := conditions[k]; old != nil {
+				c = &ConditionalPrivilege{Condition: c.Condition, Privilege: c.Privilege | old.Privilege}
+			}
+			conditions[k] = c
+		}
+	}
+	if conditions == nil {
+		return inherited
+	}
+	return conditions
+}
+
+// unionConditions returns conditions granting privileges of either t or s.
+func unionConditions(t, s map[string]*ConditionalPrivilege) map[string]*ConditionalPrivilege {
+	conditions := make(map[string]*ConditionalPrivilege, len(t)+len(s))
+	for k, c := range t {
+		conditions[k] = c
+	}
+	for k, c := range s {
+		if old := conditions[k]; old != nil {
+			c = &ConditionalPrivilege{Condition: c.Condition, Privilege: c.Privilege | old.Privilege}
+		}
+		conditions[k] = c
+	}
+	return conditions
+}
+
+// subConditions returns conditions of t without privileges which s grants
+// and does not deny, without condition or under the same condition. granted
+// is privileges s grants without condition.
+func subConditions(t, s map[string]*ConditionalPrivilege, granted, denied Privilege) map[string]*ConditionalPrivilege {
+	conditions := make(map[string]*ConditionalPrivilege, len(t))
+	for k, c := range t {
+		if deleted := (granted | conditionPrivilege(s, k)) &^ denied; c.Privilege&deleted != NoPrivilege {
+			c = &ConditionalPrivilege{Condition: c.Condition, Privilege: c.Privilege &^ deleted}
+		}
+		conditions[k] = c
+	}
+	return conditions
+}
+
+// sortedConditions returns conditions of the node sorted by condition.
+func (t *PrivilegeTree) sortedConditions() []*ConditionalPrivilege {
+	return sortedConditions(t.Conditions)
+}
+
+// sortedConditions returns conditions sorted by condition.
+func sortedConditions(conditions map[string]*ConditionalPrivilege) []*ConditionalPrivilege {
+	keys := make([]string, 0, len(conditions))
+	for k := range conditions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sorted := make([]*ConditionalPrivilege, 0, len(keys))
+	for _, k := range keys {
+		sorted = append(sorted, conditions[k])
+	}
+	return sorted
+}
@@ -0,0 +1,251 @@
+package priv_test
+
+import (
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func mustParseExpr(t *testing.T, s string) priv.Expr {
+	expr, err := priv.ParseExpr(s)
+	if err != nil {
+		t.Fatalf("parse expr %s got error '%v'", s, err)
+	}
+	return expr
+}
+
+func TestPrivilegeTreeContainWith(t *testing.T) {
+	tree := priv.NewPrivilegeTree()
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege, mustParseExpr(t, `host =~ /^web/`))
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb"), priv.DeletePrivilege, mustParseExpr(t, `region = 'us'`))
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb.autogen./^disk/"), priv.ReadPrivilege, mustParseExpr(t, `host = 'db01'`))
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen.mem"), priv.SelectPrivilege)
+	tree.Deny(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.DeletePrivilege)
+
+	web := map[string]string{"host": "web01", "region": "us"}
+	db := map[string]string{"host": "db01", "region": "eu"}
+	var tests = []struct {
+		resource  string
+		privilege priv.Privilege
+		tags      map[string]string
+		contain   bool
+	}{
+		{resource: "mydb.autogen.cpu", privilege: priv.SelectPrivilege, tags: web, contain: true},
+		{resource: "mydb.autogen.cpu", privilege: priv.SelectPrivilege, tags: db, contain: false},
+		{resource: "mydb.autogen.cpu", privilege: priv.SelectPrivilege, tags: map[string]string{"region": "us"}, contain: false},
+		{resource: "mydb.autogen.cpu", privilege: priv.SelectPrivilege, tags: nil, contain: false},
+		{resource: "mydb.autogen.mem", privilege: priv.SelectPrivilege, tags: db, contain: true},
+		{resource: "mydb.autogen.mem", privilege: priv.SelectPrivilege, tags: nil, contain: true},
+
+		// conditions above the resource apply and combine with conditions on it
+		{resource: "mydb.autogen.cpu", privilege: priv.SelectPrivilege | priv.DeletePrivilege, tags: web, contain: true},
+		{resource: "mydb.autogen.cpu", privilege: priv.SelectPrivilege | priv.DeletePrivilege, tags: map[string]string{"host": "web01", "region": "eu"}, contain: false},
+		{resource: "mydb.autogen.mem", privilege: priv.SelectPrivilege | priv.DeletePrivilege, tags: web, contain: true},
+		{resource: "mydb.autogen.mem", privilege: priv.SelectPrivilege | priv.DeletePrivilege, tags: db, contain: false},
+		{resource: "mydb.daily.net", privilege: priv.DeletePrivilege, tags: web, contain: true},
+		{resource: "yourdb.autogen.cpu", privilege: priv.DeletePrivilege, tags: web, contain: false},
+
+		// deny beats conditions
+		{resource: "mydb.autogen.secret", privilege: priv.DeletePrivilege, tags: web, contain: false},
+
+		// conditions on patterns apply to names matching them, legacy READ
+		// grants its group
+		{resource: "mydb.autogen.disk_io", privilege: priv.SelectPrivilege, tags: db, contain: true},
+		{resource: "mydb.autogen.disk_io", privilege: priv.SelectPrivilege, tags: web, contain: false},
+		{resource: "mydb.autogen.io_disk", privilege: priv.SelectPrivilege, tags: db, contain: false},
+	}
+
+	for _, test := range tests {
+		resource := priv.CreateResourcePathUnsafe(test.resource)
+		if act := tree.ContainWith(resource, test.privilege, test.tags); act != test.contain {
+			t.Fatalf("%s contain %s with %v got %v expect %v", test.resource, test.privilege, test.tags, act, test.contain)
+		}
+	}
+
+	// conditions are only checked by ContainWith
+	if tree.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege) {
+		t.Fatalf("expect conditional privilege not contained without tags")
+	}
+
+	// literal children created after the pattern inherit its conditions
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen.disk_free"), priv.InsertPrivilege)
+	if !tree.ContainWith(priv.CreateResourcePathUnsafe("mydb.autogen.disk_free"), priv.SelectPrivilege|priv.InsertPrivilege, db) {
+		t.Fatalf("expect condition of pattern applies to new literal child")
+	}
+}
+
+func TestPrivilegeTreeConditionEvalError(t *testing.T) {
+	tree := priv.NewPrivilegeTree()
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege, mustParseExpr(t, `host + 1`))
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb"), priv.InsertPrivilege, mustParseExpr(t, `host = 1`))
+
+	tags := map[string]string{"host": "web01"}
+	for _, p := range []priv.Privilege{priv.SelectPrivilege, priv.InsertPrivilege} {
+		if tree.ContainWith(priv.CreateResourcePathUnsafe("mydb"), p, tags) {
+			t.Fatalf("expect condition failing to evaluate grants no %s", p)
+		}
+	}
+}
+
+func TestPrivilegeTreeDeleteCondition(t *testing.T) {
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+	tags := map[string]string{"host": "web01"}
+
+	tree := priv.NewPrivilegeTree()
+	tree.AddWhere(cpu, priv.SelectPrivilege|priv.InsertPrivilege, mustParseExpr(t, `host =~ /^web/`))
+	tree.Delete(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	if tree.ContainWith(cpu, priv.SelectPrivilege, tags) || !tree.ContainWith(cpu, priv.InsertPrivilege, tags) {
+		t.Fatalf("expect only conditional SELECT deleted, got %s", tree)
+	}
+
+	tree.DeleteGlobal(priv.InsertPrivilege)
+	if tree.ContainWith(cpu, priv.InsertPrivilege, tags) || !tree.Powerless() {
+		t.Fatalf("expect all conditional privileges deleted, got %s", tree)
+	}
+
+	tree.AddWhere(cpu, priv.SelectPrivilege, mustParseExpr(t, `host =~ /^web/`))
+	if tree.Powerless() {
+		t.Fatalf("expect conditional privilege not powerless")
+	}
+	tree.ClearAll()
+	if tree.ContainWith(cpu, priv.SelectPrivilege, tags) {
+		t.Fatalf("expect conditional privilege cleared")
+	}
+}
+
+func TestPrivilegeSetDeleteInheritedCondition(t *testing.T) {
+	mydb := priv.CreateResourcePathUnsafe("mydb")
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+	mem := priv.CreateResourcePathUnsafe("mydb.autogen.mem")
+	tags := map[string]string{"host": "a"}
+	isA := mustParseExpr(t, `host = 'a'`)
+
+	tree := priv.NewPrivilegeTree()
+	tree.AddWhere(mydb, priv.SelectPrivilege, isA)
+	concurrent := priv.NewConcurrentPrivilegeSet()
+	concurrent.AddWhere(mydb, priv.SelectPrivilege, isA)
+	expiring := priv.NewExpiringPrivilegeSet(nil)
+	mustNil(t, expiring.AddWhereUntil(mydb, priv.SelectPrivilege, isA, time.Now().Add(time.Hour)))
+
+	for _, set := range []interface {
+		priv.PrivilegeSet
+		ContainWith(resource *priv.ResourcePath, privilege priv.Privilege, tags map[string]string) bool
+		Normalize()
+	}{tree, concurrent, expiring} {
+		// conditional privileges granted on the database are revoked on cpu only
+		set.Delete(cpu, priv.SelectPrivilege)
+		set.Normalize()
+		if set.Contain(cpu, priv.SelectPrivilege) || set.ContainWith(cpu, priv.SelectPrivilege, tags) {
+			t.Fatalf("expect conditional SELECT on %s deleted, got %s", cpu, set)
+		}
+		if !set.ContainWith(mem, priv.SelectPrivilege, tags) || !set.ContainWith(mydb, priv.SelectPrivilege, tags) {
+			t.Fatalf("expect conditional SELECT on %s kept, got %s", mem, set)
+		}
+	}
+
+	// the revoke is kept by conditions without privilege
+	if exp := `{[("",0)]}{[(mydb,0,WHERE host = 'a':16)]}{[(autogen,0)]}{[(cpu,0,WHERE host = 'a':0)]}{[]}`; tree.String() != exp {
+		t.Fatalf("string got %s expect %s", tree, exp)
+	}
+	loaded, err := priv.LoadPrivilegeTree(tree.String())
+	if err != nil || !loaded.ContainWith(mem, priv.SelectPrivilege, tags) || loaded.ContainWith(cpu, priv.SelectPrivilege, tags) {
+		t.Fatalf("load %s got %s, error '%v'", tree, loaded, err)
+	}
+
+	// granting on the database again grants on cpu as well
+	tree.AddWhere(mydb, priv.SelectPrivilege, isA)
+	if !tree.ContainWith(cpu, priv.SelectPrivilege, tags) {
+		t.Fatalf("expect conditional SELECT on %s granted again, got %s", cpu, tree)
+	}
+}
+
+func TestPrivilegeTreeConditionSetOperations(t *testing.T) {
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+	web, db := map[string]string{"host": "web01"}, map[string]string{"host": "db01"}
+	isWeb, isDB := mustParseExpr(t, `host =~ /^web/`), mustParseExpr(t, `host = 'db01'`)
+
+	a := priv.NewPrivilegeTree()
+	a.AddWhere(cpu, priv.SelectPrivilege, isWeb)
+	b := priv.NewPrivilegeTree()
+	b.AddWhere(cpu, priv.SelectPrivilege, isDB)
+
+	a.UnionWith(b)
+	if !a.ContainWith(cpu, priv.SelectPrivilege, web) || !a.ContainWith(cpu, priv.SelectPrivilege, db) {
+		t.Fatalf("expect union contains both conditions, got %s", a)
+	}
+
+	a.DifferentWith(b)
+	if !a.ContainWith(cpu, priv.SelectPrivilege, web) || a.ContainWith(cpu, priv.SelectPrivilege, db) {
+		t.Fatalf("expect difference removes condition of b, got %s", a)
+	}
+	if b.Contains(a) {
+		t.Fatalf("expect %s not contains %s", b, a)
+	}
+
+	// privileges granted without condition cover conditional ones
+	c := priv.NewPrivilegeTree()
+	c.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	a.DifferentWith(c)
+	if !a.Powerless() {
+		t.Fatalf("expect conditional privilege removed by unconditional one, got %s", a)
+	}
+	if !c.Contains(b) {
+		t.Fatalf("expect %s contains %s", c, b)
+	}
+
+	// conditional privileges denied cover nothing
+	d := b.Clone().(*priv.PrivilegeTree)
+	d.Deny(cpu, priv.SelectPrivilege)
+	if d.Contains(b) {
+		t.Fatalf("expect %s not contains %s", d, b)
+	}
+}
+
+func TestConcurrentPrivilegeSetContainWith(t *testing.T) {
+	set := priv.NewConcurrentPrivilegeSet()
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+	tags := map[string]string{"host": "web01"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			set.AddWhere(cpu, priv.SelectPrivilege, &priv.BinaryExpr{Op: priv.EQREGEX,
+				LHS: &priv.VarRef{Val: "host"}, RHS: &priv.RegexLiteral{Val: regexp.MustCompile(`^web`)}})
+			set.ContainWith(cpu, priv.SelectPrivilege, tags)
+		}()
+	}
+	wg.Wait()
+
+	if !set.ContainWith(cpu, priv.SelectPrivilege, tags) {
+		t.Fatalf("expect conditional privilege contained")
+	}
+}
+
+func TestExpiringPrivilegeSetGrantWhere(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	set := priv.NewExpiringPrivilegeSet(clock.Now)
+
+	stmt, err := priv.ParseStatement(`GRANT SELECT ON mydb.autogen.cpu WHERE host =~ /^web/ TO ops FOR 1h`)
+	if err != nil {
+		t.Fatalf("parse got error '%v'", err)
+	}
+	mustNil(t, set.Grant(stmt.(*priv.GrantStatement)))
+
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+	if set.Contain(cpu, priv.SelectPrivilege) {
+		t.Fatalf("expect conditional privilege not contained without tags")
+	}
+	if !set.ContainWith(cpu, priv.SelectPrivilege, map[string]string{"host": "web01"}) {
+		t.Fatalf("expect conditional privilege contained before expired")
+	}
+
+	clock.Advance(time.Hour)
+	if set.ContainWith(cpu, priv.SelectPrivilege, map[string]string{"host": "web01"}) {
+		t.Fatalf("expect conditional privilege expired")
+	}
+}
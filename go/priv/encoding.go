@@ -0,0 +1,527 @@
+package priv
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	_ json.Marshaler             = (*PrivilegeTree)(nil)
+	_ json.Unmarshaler           = (*PrivilegeTree)(nil)
+	_ encoding.BinaryMarshaler   = (*PrivilegeTree)(nil)
+	_ encoding.BinaryUnmarshaler = (*PrivilegeTree)(nil)
+)
+
+// encodingVersion is the version of both JSON and binary encoding of PrivilegeTree.
+// Version 2 adds pattern nodes, version 3 adds denied privileges, version 4
+// adds conditional privileges, data of earlier versions can still be decoded.
+const encodingVersion = 4
+
+// maxTreeDepth limits the depth of decoded trees, as resource path has 3 segments at most.
+const maxTreeDepth = 3
+
+// ErrUnsupportedVersion is returned when decoding data of an unknown encoding version.
+var ErrUnsupportedVersion = errors.New("unsupported encoding version")
+
+type jsonPrivilegeTree struct {
+	Version int `json:"version"`
+	jsonPrivilegeNode
+}
+
+type jsonPrivilegeNode struct {
+	Privileges []string                      `json:"privileges,omitempty"`
+	Denied     []string                      `json:"denied,omitempty"`
+	Conditions map[string][]string           `json:"conditions,omitempty"`
+	Children   map[string]*jsonPrivilegeNode `json:"children,omitempty"`
+	Patterns   map[string]*jsonPrivilegeNode `json:"patterns,omitempty"`
+}
+
+// MarshalJSON encodes privilege tree to JSON with privileges presented by names, e.g.
+// {"version":3,"privileges":["GRANT"],"children":{"mydb":{"privileges":["SELECT"]}}}
+// Pattern nodes are keyed by regex in "patterns" of their parent, denied
+// privileges of a node are listed in "denied", and conditional privileges
+// are keyed by condition in "conditions".
+// Note that privileges of each node are the raw bits stored in tree rather
+// than effective privileges on that node.
+func (t *PrivilegeTree) MarshalJSON() ([]byte, error) {
+	node, err := t.toJSONNode(nil)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&jsonPrivilegeTree{Version: encodingVersion, jsonPrivilegeNode: *node})
+}
+
+func (t *PrivilegeTree) toJSONNode(path []string) (*jsonPrivilegeNode, error) {
+	node, err := t.toJSONPrivileges(len(path) == 0)
+	if err != nil {
+		return nil, fmt.Errorf("%v on %s", err, resourceName(path))
+	}
+
+	for k, v := range t.Tree {
+		if v == nil {
+			continue
+		}
+		child, err := v.toJSONNode(append(path[:len(path):len(path)], k))
+		if err != nil {
+			return nil, err
+		}
+		if node.Children == nil {
+			node.Children = make(map[string]*jsonPrivilegeNode)
+		}
+		node.Children[k] = child
+	}
+	for k, v := range t.Patterns {
+		if v == nil {
+			continue
+		}
+		pattern, err := v.toJSONPrivileges(false)
+		if err != nil {
+			return nil, fmt.Errorf("%v on %s", err, patternName(path, v.Regex))
+		}
+		if node.Patterns == nil {
+			node.Patterns = make(map[string]*jsonPrivilegeNode)
+		}
+		node.Patterns[k] = pattern
+	}
+	return node, nil
+}
+
+// toJSONPrivileges converts privileges of the node without children.
+func (t *PrivilegeTree) toJSONPrivileges(root bool) (*jsonPrivilegeNode, error) {
+	privileges, err := privilegeNames(t.Privilege, root)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := privilegeNames(t.Denied, root)
+	if err != nil {
+		return nil, err
+	}
+	node := &jsonPrivilegeNode{Privileges: privileges, Denied: denied}
+	for k, c := range t.Conditions {
+		names, err := privilegeNames(c.Privilege, false)
+		if err != nil {
+			return nil, fmt.Errorf("%v where %s", err, k)
+		}
+		if node.Conditions == nil {
+			node.Conditions = make(map[string][]string)
+		}
+		node.Conditions[k] = names
+	}
+	return node, nil
+}
+
+// fromJSONPrivileges is the reverse of toJSONPrivileges.
+func fromJSONPrivileges(node *jsonPrivilegeNode, root bool) (*PrivilegeTree, error) {
+	privilege, err := privilegeOfNames(node.Privileges, root)
+	if err != nil {
+		return nil, err
+	}
+	denied, err := privilegeOfNames(node.Denied, root)
+	if err != nil {
+		return nil, err
+	}
+
+	t := NewPrivilegeTree()
+	t.Privilege, t.Denied = privilege, denied
+	for k, names := range node.Conditions {
+		condition, err := ParseExpr(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %s: %v", k, err)
+		}
+		privilege, err := privilegeOfNames(names, false)
+		if err != nil {
+			return nil, fmt.Errorf("%v where %s", err, k)
+		}
+		t.addCondition(condition, privilege&AllResourcePrivileges)
+	}
+	return t, nil
+}
+
+// UnmarshalJSON decodes privilege tree from JSON produced by MarshalJSON.
+func (t *PrivilegeTree) UnmarshalJSON(data []byte) error {
+	var v jsonPrivilegeTree
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Version < 1 || v.Version > encodingVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedVersion, v.Version)
+	}
+
+	tree, err := fromJSONNode(&v.jsonPrivilegeNode, nil)
+	if err != nil {
+		return err
+	}
+	*t = *tree
+	return nil
+}
+
+func fromJSONNode(node *jsonPrivilegeNode, path []string) (*PrivilegeTree, error) {
+	if len(path) > maxTreeDepth {
+		return nil, fmt.Errorf("resource %s is too deep", resourceName(path))
+	}
+
+	t, err := fromJSONPrivileges(node, len(path) == 0)
+	if err != nil {
+		return nil, fmt.Errorf("%v on %s", err, resourceName(path))
+	}
+	for k, v := range node.Children {
+		if v == nil {
+			continue
+		}
+		child, err := fromJSONNode(v, append(path[:len(path):len(path)], k))
+		if err != nil {
+			return nil, err
+		}
+		t.Tree[k] = child
+	}
+	for k, v := range node.Patterns {
+		if v == nil {
+			continue
+		}
+		regex, err := regexp.Compile(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern on %s: %v", resourceName(path), err)
+		}
+		if len(v.Children) != 0 || len(v.Patterns) != 0 {
+			return nil, fmt.Errorf("pattern %s can not have children", patternName(path, regex))
+		}
+		pattern, err := fromJSONPrivileges(v, false)
+		if err != nil {
+			return nil, fmt.Errorf("%v on %s", err, patternName(path, regex))
+		}
+		pattern.Regex = regex
+		if t.Patterns == nil {
+			t.Patterns = make(map[string]*PrivilegeTree)
+		}
+		t.Patterns[k] = pattern
+	}
+	return t, nil
+}
+
+// privilegeNames converts privilege bits to names, ALL PRIVILEGES stands for
+// AllGlobalPrivileges on root node and AllResourcePrivileges on other nodes.
+// Reserved bits without names, which are left by deleting some privileges
+// from all privileges, are written together as a hex number, e.g. 0xff80.
+func privilegeNames(privilege Privilege, root bool) ([]string, error) {
+	all := AllResourcePrivileges
+	if root {
+		all = AllGlobalPrivileges
+	}
+
+	var names []string
+	if privilege&all == all {
+		names = append(names, privilege2name[all])
+		privilege &^= all
+	}
+	for mask := Privilege(1); mask > 0 && privilege != NoPrivilege; mask <<= 1 {
+		if name, ok := privilege2name[mask]; ok && privilege&mask == mask {
+			names = append(names, name)
+			privilege &^= mask
+		}
+	}
+
+	if privilege&^AllGlobalPrivileges != NoPrivilege {
+		return nil, fmt.Errorf("unknown privilege bits %#x", uint(privilege&^AllGlobalPrivileges))
+	}
+	if privilege != NoPrivilege {
+		names = append(names, fmt.Sprintf("%#x", uint(privilege)))
+	}
+	return names, nil
+}
+
+// privilegeOfNames is the reverse of privilegeNames.
+func privilegeOfNames(names []string, root bool) (Privilege, error) {
+	all := AllResourcePrivileges
+	if root {
+		all = AllGlobalPrivileges
+	}
+
+	privilege := NoPrivilege
+	for _, name := range names {
+		if strings.HasPrefix(name, "0x") {
+			bits, err := strconv.ParseUint(name[2:], 16, 64)
+			if err != nil {
+				return NoPrivilege, fmt.Errorf("unknown privilege '%s'", name)
+			}
+			if Privilege(bits)&^AllGlobalPrivileges != NoPrivilege {
+				return NoPrivilege, fmt.Errorf("unknown privilege bits %#x", bits&^uint64(AllGlobalPrivileges))
+			}
+			privilege |= Privilege(bits)
+			continue
+		}
+		p, err := PrivilegeOf(name)
+		if err != nil {
+			return NoPrivilege, err
+		}
+		if p == AllGlobalPrivileges {
+			p = all
+		}
+		privilege |= p
+	}
+	return privilege, nil
+}
+
+// MarshalBinary encodes privilege tree to a compact binary form:
+// a version byte followed by nodes encoded recursively, each node consists of
+// varint privilege, varint denied privilege, uvarint children count, and for
+// each child an uvarint name length, name bytes and the child node, then
+// uvarint patterns count and for each pattern an uvarint regex length, regex
+// bytes, varint privilege and varint denied privilege. Privileges of both
+// nodes and patterns are followed by uvarint conditions count, and for each
+// condition an uvarint length, condition bytes and varint privilege.
+// Children and patterns are sorted so that equal trees are encoded to
+// identical bytes.
+func (t *PrivilegeTree) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{encodingVersion})
+	if err := t.encodeBinary(buf, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *PrivilegeTree) encodeBinary(buf *bytes.Buffer, path []string) error {
+	if err := t.encodePrivileges(buf, resourceName(path)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(t.Tree))
+	for k, v := range t.Tree {
+		if v != nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(names)))])
+	for _, name := range names {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(name)))])
+		buf.WriteString(name)
+		if err := t.Tree[name].encodeBinary(buf, append(path[:len(path):len(path)], name)); err != nil {
+			return err
+		}
+	}
+
+	patterns := make([]string, 0, len(t.Patterns))
+	for k, v := range t.Patterns {
+		if v != nil {
+			patterns = append(patterns, k)
+		}
+	}
+	sort.Strings(patterns)
+
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(patterns)))])
+	for _, k := range patterns {
+		v := t.Patterns[k]
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(k)))])
+		buf.WriteString(k)
+		if err := v.encodePrivileges(buf, patternName(path, v.Regex)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodePrivileges writes privilege and denied privilege of the node.
+func (t *PrivilegeTree) encodePrivileges(buf *bytes.Buffer, name string) error {
+	var scratch [binary.MaxVarintLen64]byte
+	for _, privilege := range []Privilege{t.Privilege, t.Denied} {
+		if privilege&^AllGlobalPrivileges != NoPrivilege {
+			return fmt.Errorf("unknown privilege bits %#x on %s", uint(privilege&^AllGlobalPrivileges), name)
+		}
+		buf.Write(scratch[:binary.PutVarint(scratch[:], int64(privilege))])
+	}
+
+	conditions := t.sortedConditions()
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(conditions)))])
+	for _, c := range conditions {
+		if c.Privilege&^AllResourcePrivileges != NoPrivilege {
+			return fmt.Errorf("unknown privilege bits %#x on %s where %s", uint(c.Privilege&^AllResourcePrivileges), name, c.Condition)
+		}
+		condition := c.Condition.String()
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(condition)))])
+		buf.WriteString(condition)
+		buf.Write(scratch[:binary.PutVarint(scratch[:], int64(c.Privilege))])
+	}
+	return nil
+}
+
+// decodePrivileges is the reverse of encodePrivileges, denied privilege is
+// absent before version 3.
+func decodePrivileges(r *bytes.Reader, name string, version byte) (Privilege, Privilege, error) {
+	var privileges [2]Privilege
+	count := len(privileges)
+	if version < 3 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		privilege, err := binary.ReadVarint(r)
+		if err != nil {
+			return NoPrivilege, NoPrivilege, fmt.Errorf("read privilege of %s: %w", name, noEOF(err))
+		}
+		if Privilege(privilege)&^AllGlobalPrivileges != NoPrivilege {
+			return NoPrivilege, NoPrivilege, fmt.Errorf("unknown privilege bits %#x on %s",
+				uint64(privilege)&^uint64(AllGlobalPrivileges), name)
+		}
+		privileges[i] = Privilege(privilege)
+	}
+	return privileges[0], privileges[1], nil
+}
+
+// decodeConditions reads conditional privileges written by encodePrivileges
+// into the node, conditions are absent before version 4.
+func decodeConditions(r *bytes.Reader, t *PrivilegeTree, name string, version byte) error {
+	if version < 4 {
+		return nil
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("read conditions count of %s: %w", name, noEOF(err))
+	}
+	for i := uint64(0); i < n; i++ {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("read condition of %s: %w", name, noEOF(err))
+		}
+		if size > uint64(r.Len()) {
+			return fmt.Errorf("read condition of %s: %w", name, io.ErrUnexpectedEOF)
+		}
+		expr := make([]byte, size)
+		_, _ = r.Read(expr)
+		condition, err := ParseExpr(string(expr))
+		if err != nil {
+			return fmt.Errorf("invalid condition on %s: %v", name, err)
+		}
+
+		privilege, err := binary.ReadVarint(r)
+		if err != nil {
+			return fmt.Errorf("read privilege of %s where %s: %w", name, condition, noEOF(err))
+		}
+		if Privilege(privilege)&^AllResourcePrivileges != NoPrivilege {
+			return fmt.Errorf("unknown privilege bits %#x on %s where %s",
+				uint64(privilege)&^uint64(AllResourcePrivileges), name, condition)
+		}
+		t.addCondition(condition, Privilege(privilege))
+	}
+	return nil
+}
+
+// UnmarshalBinary decodes privilege tree from data produced by MarshalBinary.
+func (t *PrivilegeTree) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty privilege tree data")
+	}
+	version := data[0]
+	if version < 1 || version > encodingVersion {
+		return fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+
+	r := bytes.NewReader(data[1:])
+	tree, err := decodeBinary(r, nil, version)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%d bytes of unexpected trailing data", r.Len())
+	}
+	*t = *tree
+	return nil
+}
+
+func decodeBinary(r *bytes.Reader, path []string, version byte) (*PrivilegeTree, error) {
+	if len(path) > maxTreeDepth {
+		return nil, fmt.Errorf("resource %s is too deep", resourceName(path))
+	}
+
+	t := NewPrivilegeTree()
+	var err error
+	if t.Privilege, t.Denied, err = decodePrivileges(r, resourceName(path), version); err != nil {
+		return nil, err
+	}
+	if err := decodeConditions(r, t, resourceName(path), version); err != nil {
+		return nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("read children count of %s: %w", resourceName(path), noEOF(err))
+	}
+	for i := uint64(0); i < n; i++ {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("read child name of %s: %w", resourceName(path), noEOF(err))
+		}
+		if size > uint64(r.Len()) {
+			return nil, fmt.Errorf("read child name of %s: %w", resourceName(path), io.ErrUnexpectedEOF)
+		}
+		name := make([]byte, size)
+		_, _ = r.Read(name)
+
+		child, err := decodeBinary(r, append(path[:len(path):len(path)], string(name)), version)
+		if err != nil {
+			return nil, err
+		}
+		t.Tree[string(name)] = child
+	}
+	if version < 2 {
+		return t, nil
+	}
+
+	n, err = binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("read patterns count of %s: %w", resourceName(path), noEOF(err))
+	}
+	for i := uint64(0); i < n; i++ {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("read pattern of %s: %w", resourceName(path), noEOF(err))
+		}
+		if size > uint64(r.Len()) {
+			return nil, fmt.Errorf("read pattern of %s: %w", resourceName(path), io.ErrUnexpectedEOF)
+		}
+		expr := make([]byte, size)
+		_, _ = r.Read(expr)
+		regex, err := regexp.Compile(string(expr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern on %s: %v", resourceName(path), err)
+		}
+
+		pattern := t.pattern(regex)
+		if pattern.Privilege, pattern.Denied, err = decodePrivileges(r, patternName(path, regex), version); err != nil {
+			return nil, err
+		}
+		if err := decodeConditions(r, pattern, patternName(path, regex), version); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF as data ended in the middle of a tree.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// resourceName describes resource of the given path in error messages.
+func resourceName(path []string) string {
+	if len(path) == 0 {
+		return "global resource"
+	}
+	return (&ResourcePath{Segs: path}).String()
+}
+
+// patternName describes pattern node of the given regex in error messages.
+func patternName(path []string, regex *regexp.Regexp) string {
+	return (&ResourcePath{Segs: path, Regex: regex}).String()
+}
@@ -0,0 +1,392 @@
+package priv_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestPrivilegeTreeJSON(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(priv.GrantPrivilege | priv.InsertPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.InsertPrivilege)
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("marshal privilege tree %s got error '%v'", set, err)
+	}
+	exp := `{"version":4,"privileges":["INSERT","GRANT"],"children":{"mydb":{"privileges":["SELECT"],` +
+		`"children":{"autogen":{"children":{"cpu":{"privileges":["INSERT"]}}}}}}}`
+	if act := string(data); act != exp {
+		t.Fatalf("marshal privilege tree got %s expect %s", act, exp)
+	}
+
+	loaded := priv.NewPrivilegeTree()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("unmarshal privilege tree %s got error '%v'", data, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("unmarshal privilege tree %s got %s expect %s", data, loaded, set)
+	}
+}
+
+func TestPrivilegeTreeJSONAllPrivileges(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.SetAll()
+	set.Delete(priv.CreateResourcePathUnsafe("mydb"), priv.AllResourcePrivileges)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.AllResourcePrivileges)
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("marshal privilege tree %s got error '%v'", set, err)
+	}
+	exp := `{"version":4,"privileges":["ALL PRIVILEGES"],"children":{"mydb":{"privileges":["ALL PRIVILEGES"],` +
+		`"children":{"autogen":{"privileges":["ALL PRIVILEGES"]}}}}}`
+	if act := string(data); act != exp {
+		t.Fatalf("marshal privilege tree got %s expect %s", act, exp)
+	}
+
+	loaded := priv.NewPrivilegeTree()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("unmarshal privilege tree %s got error '%v'", data, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("unmarshal privilege tree %s got %s expect %s", data, loaded, set)
+	}
+}
+
+func TestPrivilegeTreeJSONReservedBits(t *testing.T) {
+	mydb := priv.CreateResourcePathUnsafe("mydb")
+
+	global := priv.NewPrivilegeTree()
+	global.SetAll()
+	global.DeleteGlobal(priv.SelectPrivilege)
+	resource := priv.NewPrivilegeTree()
+	resource.Add(mydb, priv.AllResourcePrivileges)
+	resource.Delete(mydb, priv.SelectPrivilege)
+
+	var tests = []struct {
+		set *priv.PrivilegeTree
+		exp string
+	}{
+		{
+			set: global,
+			exp: `{"version":4,"privileges":["READ","WRITE","CREATE CQ","INSERT","DELETE","DROP","SHOW USERS",` +
+				`"CREATE USER","SHOW ROLES","CREATE ROLE","GRANT","SHOW DATABASES","CREATE DATABASE",` +
+				`"SHOW SYSINFO","SET SYSINFO","AUDIT","SHOW CQS","0x7800ff80"]}`,
+		},
+		{
+			set: resource,
+			exp: `{"version":4,"children":{"mydb":{"privileges":["READ","WRITE","CREATE CQ","INSERT","DELETE","DROP","0xff80"]}}}`,
+		},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(test.set)
+		if err != nil {
+			t.Fatalf("marshal privilege tree %s got error '%v'", test.set, err)
+		}
+		if act := string(data); act != test.exp {
+			t.Fatalf("marshal privilege tree got %s expect %s", act, test.exp)
+		}
+
+		loaded := priv.NewPrivilegeTree()
+		if err := json.Unmarshal(data, loaded); err != nil {
+			t.Fatalf("unmarshal privilege tree %s got error '%v'", data, err)
+		}
+		if !reflect.DeepEqual(loaded, test.set) {
+			t.Fatalf("unmarshal privilege tree %s got %s expect %s", data, loaded, test.set)
+		}
+	}
+}
+
+func TestPrivilegeTreeJSONErr(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(1 << 31)
+	if _, err := json.Marshal(set); err == nil {
+		t.Fatalf("expect error marshal unknown privilege bits")
+	}
+
+	var tests = []struct {
+		s string
+		e string
+	}{
+		{
+			s: `{"version":5}`,
+			e: `unsupported encoding version 5`,
+		},
+		{
+			s: `{"version":1,"children":{"mydb":{"privileges":["SELCT"]}}}`,
+			e: `unknown privilege 'SELCT' (did you mean SELECT?) on mydb`,
+		},
+		{
+			s: `{"version":4,"privileges":["0x80000000"]}`,
+			e: `unknown privilege bits 0x80000000 on global resource`,
+		},
+		{
+			s: `{"version":4,"privileges":["0xfg"]}`,
+			e: `unknown privilege '0xfg' on global resource`,
+		},
+		{
+			s: `{"version":1,"children":{"a":{"children":{"b":{"children":{"c":{"children":{"d":{}}}}}}}}}`,
+			e: `resource a.b.c.d is too deep`,
+		},
+	}
+	for _, test := range tests {
+		err := json.Unmarshal([]byte(test.s), priv.NewPrivilegeTree())
+		if err == nil || err.Error() != test.e {
+			t.Fatalf("unmarshal privilege tree %s got error '%v' expect error '%v'", test.s, err, test.e)
+		}
+	}
+}
+
+func TestPrivilegeTreeBinary(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		set := randomPrivilegeTree(r)
+		data, err := set.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal privilege tree %s got error '%v'", set, err)
+		}
+
+		loaded := priv.NewPrivilegeTree()
+		if err := loaded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("unmarshal privilege tree %s got error '%v'", set, err)
+		}
+		if !reflect.DeepEqual(loaded, set) {
+			t.Fatalf("unmarshal privilege tree got %s expect %s", loaded, set)
+		}
+
+		again, _ := loaded.MarshalBinary()
+		if !reflect.DeepEqual(again, data) {
+			t.Fatalf("marshal privilege tree %s got different bytes", set)
+		}
+	}
+}
+
+func TestPrivilegeTreeJSONFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		set := randomPrivilegeTree(r)
+		data, err := json.Marshal(set)
+		if err != nil {
+			t.Fatalf("marshal privilege tree %s got error '%v'", set, err)
+		}
+
+		loaded := priv.NewPrivilegeTree()
+		if err := json.Unmarshal(data, loaded); err != nil {
+			t.Fatalf("unmarshal privilege tree %s got error '%v'", data, err)
+		}
+		if !reflect.DeepEqual(loaded, set) {
+			t.Fatalf("unmarshal privilege tree %s got %s expect %s", data, loaded, set)
+		}
+	}
+}
+
+func TestPrivilegeTreeDecodeOldVersions(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+
+	for _, data := range [][]byte{
+		{1, 0, 1, 4, 'm', 'y', 'd', 'b', 32, 0},
+		{2, 0, 1, 4, 'm', 'y', 'd', 'b', 32, 0, 0, 0},
+		{3, 0, 0, 1, 4, 'm', 'y', 'd', 'b', 32, 0, 0, 0, 0},
+	} {
+		loaded := priv.NewPrivilegeTree()
+		if err := loaded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("unmarshal privilege tree %v got error '%v'", data, err)
+		}
+		if !reflect.DeepEqual(loaded, set) {
+			t.Fatalf("unmarshal privilege tree %v got %s expect %s", data, loaded, set)
+		}
+	}
+
+	data := `{"version":1,"children":{"mydb":{"privileges":["SELECT"]}}}`
+	loaded := priv.NewPrivilegeTree()
+	if err := json.Unmarshal([]byte(data), loaded); err != nil {
+		t.Fatalf("unmarshal privilege tree %s got error '%v'", data, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("unmarshal privilege tree %s got %s expect %s", data, loaded, set)
+	}
+}
+
+func TestPrivilegeTreeJSONDenied(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(priv.SelectPrivilege)
+	set.Deny(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("marshal privilege tree %s got error '%v'", set, err)
+	}
+	exp := `{"version":4,"privileges":["SELECT"],"children":{"mydb":{"denied":["SELECT"]}}}`
+	if act := string(data); act != exp {
+		t.Fatalf("marshal privilege tree got %s expect %s", act, exp)
+	}
+
+	loaded := priv.NewPrivilegeTree()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("unmarshal privilege tree %s got error '%v'", data, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("unmarshal privilege tree %s got %s expect %s", data, loaded, set)
+	}
+}
+
+func TestPrivilegeTreeBinaryErr(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	data, _ := set.MarshalBinary()
+
+	var tests = []struct {
+		data []byte
+		e    string
+	}{
+		{
+			data: nil,
+			e:    `empty privilege tree data`,
+		},
+		{
+			data: []byte{5, 0, 0},
+			e:    `unsupported encoding version 5`,
+		},
+		{
+			data: data[:len(data)-1],
+			e:    `read patterns count of global resource: unexpected EOF`,
+		},
+		{
+			data: data[:len(data)-3],
+			e:    `read children count of mydb: unexpected EOF`,
+		},
+		{
+			data: data[:6],
+			e:    `read child name of global resource: unexpected EOF`,
+		},
+		{
+			data: []byte{2, 0, 0, 1, 1, '(', 0},
+			e:    "invalid pattern on global resource: error parsing regexp: missing closing ): `(`",
+		},
+		{
+			data: append(data[:len(data):len(data)], 0),
+			e:    `1 bytes of unexpected trailing data`,
+		},
+		{
+			data: []byte{1, 0x80, 0x80, 0x80, 0x80, 0x10, 0},
+			e:    `unknown privilege bits 0x80000000 on global resource`,
+		},
+	}
+	for _, test := range tests {
+		err := priv.NewPrivilegeTree().UnmarshalBinary(test.data)
+		if err == nil || err.Error() != test.e {
+			t.Fatalf("unmarshal privilege tree %v got error '%v' expect error '%v'", test.data, err, test.e)
+		}
+	}
+
+	err := priv.NewPrivilegeTree().UnmarshalBinary([]byte{9})
+	if !errors.Is(err, priv.ErrUnsupportedVersion) {
+		t.Fatalf("unmarshal privilege tree got error '%v' expect error '%v'", err, priv.ErrUnsupportedVersion)
+	}
+}
+
+func TestPrivilegeTreeEncodeConditions(t *testing.T) {
+	web, _ := priv.ParseExpr(`host =~ /^web/`)
+	dc, _ := priv.ParseExpr(`dc IN ('a', 'b')`)
+	set := priv.NewPrivilegeTree()
+	set.AddWhere(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege|priv.DeletePrivilege, web)
+	set.AddWhere(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege, dc)
+	set.AddWhere(&priv.ResourcePath{Segs: []string{"mydb", "autogen"}, Regex: regexp.MustCompile(`^disk`)}, priv.SelectPrivilege, web)
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("marshal privilege tree %s got error '%v'", set, err)
+	}
+	exp := `{"version":4,"children":{"mydb":{"conditions":{"dc IN ('a', 'b')":["SELECT"]},"children":{"autogen":` +
+		`{"children":{"cpu":{"conditions":{"host =~ /^web/":["SELECT","DELETE"]}}},` +
+		`"patterns":{"^disk":{"conditions":{"host =~ /^web/":["SELECT"]}}}}}}}}`
+	if act := string(data); act != exp {
+		t.Fatalf("marshal privilege tree got %s expect %s", act, exp)
+	}
+	loaded := priv.NewPrivilegeTree()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatalf("unmarshal privilege tree %s got error '%v'", data, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("unmarshal privilege tree %s got %s expect %s", data, loaded, set)
+	}
+
+	data, err = set.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal privilege tree %s got error '%v'", set, err)
+	}
+	loaded = priv.NewPrivilegeTree()
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal privilege tree %v got error '%v'", data, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("unmarshal privilege tree %v got %s expect %s", data, loaded, set)
+	}
+
+	loaded, err = priv.LoadPrivilegeTree(set.String())
+	if err != nil {
+		t.Fatalf("load privilege tree %s got error '%v'", set, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("load privilege tree %s got %s", set, loaded)
+	}
+
+	for _, test := range []struct {
+		s string
+		e string
+	}{
+		{
+			s: `{"version":4,"children":{"mydb":{"conditions":{"host =":["SELECT"]}}}}`,
+			e: `invalid condition host =: found EOF, expected identifier, string, number, bool at line 1, char 7 on mydb`,
+		},
+		{
+			s: `{"version":4,"children":{"mydb":{"conditions":{"host = 'a'":["SELCT"]}}}}`,
+			e: `unknown privilege 'SELCT' (did you mean SELECT?) where host = 'a' on mydb`,
+		},
+	} {
+		err := json.Unmarshal([]byte(test.s), priv.NewPrivilegeTree())
+		if err == nil || err.Error() != test.e {
+			t.Fatalf("unmarshal privilege tree %s got error '%v' expect error '%s'", test.s, err, test.e)
+		}
+	}
+
+	for _, test := range []struct {
+		data []byte
+		e    string
+	}{
+		{
+			data: []byte{4, 0, 0},
+			e:    `read conditions count of global resource: unexpected EOF`,
+		},
+		{
+			data: []byte{4, 0, 0, 1, 8, 'h'},
+			e:    `read condition of global resource: unexpected EOF`,
+		},
+		{
+			data: []byte{4, 0, 0, 1, 6, 'h', 'o', 's', 't', ' ', '='},
+			e:    `invalid condition on global resource: found EOF, expected identifier, string, number, bool at line 1, char 7`,
+		},
+		{
+			data: []byte{4, 0, 0, 1, 1, 'h'},
+			e:    `read privilege of global resource where h: unexpected EOF`,
+		},
+		{
+			data: []byte{4, 0, 0, 1, 1, 'h', 0x80, 0x80, 0x08},
+			e:    `unknown privilege bits 0x10000 on global resource where h`,
+		},
+	} {
+		err := priv.NewPrivilegeTree().UnmarshalBinary(test.data)
+		if err == nil || err.Error() != test.e {
+			t.Fatalf("unmarshal privilege tree %v got error '%v' expect error '%v'", test.data, err, test.e)
+		}
+	}
+}
@@ -0,0 +1,475 @@
+package priv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrDivisionByZero is returned when dividing or taking modulus by zero.
+var ErrDivisionByZero = errors.New("division by zero")
+
+// TypeError is returned when an operator is applied to values of types it
+// does not support.
+type TypeError struct {
+	// Expr failed to evaluate.
+	Expr *BinaryExpr
+
+	// LHS and RHS are values of operands, RHS is nil if LHS is already of a
+	// wrong type.
+	LHS interface{}
+	RHS interface{}
+}
+
+// Error returns the string representation of the error.
+func (e *TypeError) Error() string {
+	if e.RHS == nil {
+		return fmt.Sprintf("invalid operation %s: operator %s not defined on %s", e.Expr, e.Expr.Op, typeName(e.LHS))
+	}
+	return fmt.Sprintf("invalid operation %s: operator %s not defined on %s and %s", e.Expr, e.Expr.Op, typeName(e.LHS), typeName(e.RHS))
+}
+
+// UndefinedError is returned when a variable referred by an expression is
+// missing from the values.
+type UndefinedError struct {
+	// Name of the variable.
+	Name string
+}
+
+// Error returns the string representation of the error.
+func (e *UndefinedError) Error() string {
+	return fmt.Sprintf("undefined variable %s", e.Name)
+}
+
+// typeName returns name of the type of an evaluated value.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case string:
+		return "string"
+	case time.Duration:
+		return "duration"
+	case *regexp.Regexp:
+		return "regex"
+	case []interface{}:
+		return "list"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Eval evaluates the expression with variables taken from m.
+//
+// Values of the result and of m are bool, int64, float64, string or
+// time.Duration, besides other integer and float types and json.Number are
+// accepted in m, unsigned integers must not overflow int64.
+// Arithmetic of two integers yields an integer, and an integer mixed with a
+// float is converted to float. Bitwise operators only apply to integers.
+// Durations may be added to or subtracted from each other, and multiplied
+// or divided by integers. The right side of =~ and !~ is a regex, or a
+// string which is compiled as a regex.
+//
+// AND and OR skip their right side if the left side decides the result.
+func Eval(expr Expr, m map[string]interface{}) (interface{}, error) {
+	switch expr := expr.(type) {
+	case *BinaryExpr:
+		return evalBinaryExpr(expr, m)
+	case *ParenExpr:
+		return Eval(expr.Expr, m)
+	case *VarRef:
+		v, ok := m[expr.Val]
+		if !ok {
+			return nil, &UndefinedError{Name: expr.Val}
+		}
+		v, err := normalizeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %w", expr.Val, err)
+		}
+		return v, nil
+	case *StringLiteral:
+		return expr.Val, nil
+	case *RegexLiteral:
+		return expr.Val, nil
+	case *NumberLiteral:
+		return expr.Val, nil
+	case *IntegerLiteral:
+		return expr.Val, nil
+	case *BooleanLiteral:
+		return expr.Val, nil
+	case *DurationLiteral:
+		return expr.Val, nil
+	case *ListLiteral:
+		vals := make([]interface{}, 0, len(expr.Vals))
+		for _, e := range expr.Vals {
+			v, err := Eval(e, m)
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, v)
+		}
+		return vals, nil
+	}
+	return nil, fmt.Errorf("unsupported expression %v", expr)
+}
+
+// EvalBool evaluates the expression which must yield a boolean.
+func EvalBool(expr Expr, m map[string]interface{}) (bool, error) {
+	v, err := Eval(expr, m)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %s yields %s instead of boolean", expr, typeName(v))
+	}
+	return b, nil
+}
+
+// normalizeValue converts integers and floats of any size and json.Number to
+// int64 and float64.
+func normalizeValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint:
+		return normalizeUint(uint64(v))
+	case uint64:
+		return normalizeUint(v)
+	case float32:
+		return float64(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", v)
+		}
+		return f, nil
+	}
+	return v, nil
+}
+
+func normalizeUint(v uint64) (interface{}, error) {
+	if v > math.MaxInt64 {
+		return nil, fmt.Errorf("integer %d overflows int64", v)
+	}
+	return int64(v), nil
+}
+
+func evalBinaryExpr(e *BinaryExpr, m map[string]interface{}) (interface{}, error) {
+	lhs, err := Eval(e.LHS, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Op == AND || e.Op == OR {
+		l, ok := lhs.(bool)
+		if !ok {
+			return nil, &TypeError{Expr: e, LHS: lhs}
+		}
+		if l == (e.Op == OR) {
+			return l, nil
+		}
+	}
+
+	rhs, err := Eval(e.RHS, m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.Op {
+	case AND, OR:
+		if r, ok := rhs.(bool); ok {
+			return r, nil
+		}
+	case EQREGEX, NEQREGEX:
+		l, ok := lhs.(string)
+		if !ok {
+			break
+		}
+		var re *regexp.Regexp
+		switch r := rhs.(type) {
+		case *regexp.Regexp:
+			re = r
+		case string:
+			if re, err = compileRegex(r); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, &TypeError{Expr: e, LHS: lhs, RHS: rhs}
+		}
+		return re.MatchString(l) == (e.Op == EQREGEX), nil
+	case IN:
+		list, ok := rhs.([]interface{})
+		if !ok {
+			break
+		}
+		for _, v := range list {
+			c, ok := compareValues(lhs, v)
+			if !ok {
+				return nil, &TypeError{Expr: e, LHS: lhs, RHS: v}
+			}
+			if c == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case EQ, NEQ, LT, LTE, GT, GTE:
+		c, ok := compareValues(lhs, rhs)
+		if !ok {
+			break
+		}
+		if c == unordered {
+			return e.Op == NEQ, nil
+		}
+		switch e.Op {
+		case EQ:
+			return c == 0, nil
+		case NEQ:
+			return c != 0, nil
+		}
+		// booleans are not ordered
+		if _, ok := lhs.(bool); ok {
+			break
+		}
+		switch e.Op {
+		case LT:
+			return c < 0, nil
+		case LTE:
+			return c <= 0, nil
+		case GT:
+			return c > 0, nil
+		default:
+			return c >= 0, nil
+		}
+	default:
+		if v, ok, err := evalArithmetic(e.Op, lhs, rhs); ok {
+			return v, err
+		}
+	}
+	return nil, &TypeError{Expr: e, LHS: lhs, RHS: rhs}
+}
+
+// compareValues returns -1, 0 or 1 if lhs is less than, equal to or greater
+// than rhs, booleans are only compared for equality with a non zero result
+// if they differ. It returns false if the values are not comparable.
+func compareValues(lhs, rhs interface{}) (int, bool) {
+	switch l := lhs.(type) {
+	case int64:
+		switch r := rhs.(type) {
+		case int64:
+			return compareInts(l, r), true
+		case float64:
+			return compareFloats(float64(l), r), true
+		}
+	case float64:
+		switch r := rhs.(type) {
+		case int64:
+			return compareFloats(l, float64(r)), true
+		case float64:
+			return compareFloats(l, r), true
+		}
+	case string:
+		if r, ok := rhs.(string); ok {
+			switch {
+			case l < r:
+				return -1, true
+			case l > r:
+				return 1, true
+			}
+			return 0, true
+		}
+	case time.Duration:
+		if r, ok := rhs.(time.Duration); ok {
+			return compareInts(int64(l), int64(r)), true
+		}
+	case bool:
+		if r, ok := rhs.(bool); ok {
+			if l == r {
+				return 0, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+func compareInts(l, r int64) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// unordered is the result of comparing NaN with any number, which is neither
+// equal to, less than nor greater than it.
+const unordered = 2
+
+func compareFloats(l, r float64) int {
+	if math.IsNaN(l) || math.IsNaN(r) {
+		return unordered
+	}
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	}
+	return 0
+}
+
+// evalArithmetic applies an arithmetic or bitwise operator, it returns false
+// if the operator is not defined on the values.
+func evalArithmetic(op Token, lhs, rhs interface{}) (interface{}, bool, error) {
+	switch l := lhs.(type) {
+	case int64:
+		switch r := rhs.(type) {
+		case int64:
+			return evalIntegers(op, l, r)
+		case float64:
+			return evalFloats(op, float64(l), r)
+		case time.Duration:
+			if op == MUL {
+				return time.Duration(l) * r, true, nil
+			}
+		}
+	case float64:
+		switch r := rhs.(type) {
+		case int64:
+			return evalFloats(op, l, float64(r))
+		case float64:
+			return evalFloats(op, l, r)
+		}
+	case time.Duration:
+		switch r := rhs.(type) {
+		case time.Duration:
+			switch op {
+			case ADD:
+				return l + r, true, nil
+			case SUB:
+				return l - r, true, nil
+			}
+		case int64:
+			switch op {
+			case MUL:
+				return l * time.Duration(r), true, nil
+			case DIV:
+				if r == 0 {
+					return nil, true, ErrDivisionByZero
+				}
+				return l / time.Duration(r), true, nil
+			}
+		}
+	case string:
+		if r, ok := rhs.(string); ok && op == ADD {
+			return l + r, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func evalIntegers(op Token, l, r int64) (interface{}, bool, error) {
+	switch op {
+	case ADD:
+		return l + r, true, nil
+	case SUB:
+		return l - r, true, nil
+	case MUL:
+		return l * r, true, nil
+	case DIV, MOD:
+		if r == 0 {
+			return nil, true, ErrDivisionByZero
+		}
+		if op == DIV {
+			return l / r, true, nil
+		}
+		return l % r, true, nil
+	case BITWISE_AND:
+		return l & r, true, nil
+	case BITWISE_OR:
+		return l | r, true, nil
+	case BITWISE_XOR:
+		return l ^ r, true, nil
+	}
+	return nil, false, nil
+}
+
+func evalFloats(op Token, l, r float64) (interface{}, bool, error) {
+	switch op {
+	case ADD:
+		return l + r, true, nil
+	case SUB:
+		return l - r, true, nil
+	case MUL:
+		return l * r, true, nil
+	case DIV, MOD:
+		if r == 0 {
+			return nil, true, ErrDivisionByZero
+		}
+		if op == DIV {
+			return l / r, true, nil
+		}
+		return math.Mod(l, r), true, nil
+	}
+	return nil, false, nil
+}
+
+// maxCachedRegexes limits the number of regexes cached by compileRegex.
+const maxCachedRegexes = 1024
+
+// regexCache keeps regexes compiled from strings, as the same regexes are
+// compiled again and again when rules are parsed and evaluated.
+var regexCache = struct {
+	sync.RWMutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+// compileRegex compiles the regex or returns the one cached, the cache is
+// emptied when it is full.
+func compileRegex(s string) (*regexp.Regexp, error) {
+	regexCache.RLock()
+	re, ok := regexCache.m[s]
+	regexCache.RUnlock()
+	if ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return nil, err
+	}
+
+	regexCache.Lock()
+	defer regexCache.Unlock()
+	if len(regexCache.m) >= maxCachedRegexes {
+		regexCache.m = make(map[string]*regexp.Regexp)
+	}
+	regexCache.m[s] = re
+	return re, nil
+}
@@ -0,0 +1,225 @@
+package priv_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestEval(t *testing.T) {
+	m := map[string]interface{}{
+		"host":   "server01",
+		"region": "us/east",
+		"n":      3,
+		"i64":    int64(-7),
+		"f":      float32(1.5),
+		"ok":     true,
+		"age":    90 * time.Minute,
+		"u":      uint(4),
+		"u64":    uint64(1),
+		"jn":     json.Number("12"),
+		"jf":     json.Number("0.5"),
+		"nan":    math.NaN(),
+	}
+
+	var tests = []struct {
+		s string
+		v interface{}
+	}{
+		{s: `host`, v: "server01"},
+		{s: `n`, v: int64(3)},
+		{s: `f`, v: 1.5},
+		{s: `(ok)`, v: true},
+		{s: `1h`, v: time.Hour},
+
+		// arithmetic
+		{s: `n + 1 * 2`, v: int64(5)},
+		{s: `(n + 1) * 2`, v: int64(8)},
+		{s: `i64 / 2`, v: int64(-3)},
+		{s: `i64 % n`, v: int64(-1)},
+		{s: `n / 2.0`, v: 1.5},
+		{s: `f * 2`, v: 3.0},
+		{s: `7.5 % 2`, v: 1.5},
+		{s: `n & 6 | 8 ^ 1`, v: int64(11)},
+		{s: `age + 30m`, v: 2 * time.Hour},
+		{s: `age - 2h`, v: -30 * time.Minute},
+		{s: `age * 2`, v: 3 * time.Hour},
+		{s: `2 * age`, v: 3 * time.Hour},
+		{s: `age / 3`, v: 30 * time.Minute},
+		{s: `host + '-a'`, v: "server01-a"},
+
+		// comparison
+		{s: `n = 3`, v: true},
+		{s: `n = 3.0`, v: true},
+		{s: `n != 3`, v: false},
+		{s: `f > n`, v: false},
+		{s: `i64 <= -7`, v: true},
+		{s: `host < 'server02'`, v: true},
+		{s: `host >= 't'`, v: false},
+		{s: `age > 1h`, v: true},
+		{s: `age < 1h`, v: false},
+		{s: `ok = true`, v: true},
+		{s: `ok != false`, v: true},
+		{s: `u64 = 1`, v: true},
+		{s: `u * 2`, v: int64(8)},
+		{s: `jn > 10`, v: true},
+		{s: `jf + jn`, v: 12.5},
+
+		// NaN is neither equal to nor ordered with any number
+		{s: `nan = 1`, v: false},
+		{s: `nan = nan`, v: false},
+		{s: `nan != 1`, v: true},
+		{s: `nan >= 1`, v: false},
+		{s: `nan <= 1`, v: false},
+		{s: `nan < 1`, v: false},
+		{s: `1 > nan`, v: false},
+		{s: `nan IN (1, 2)`, v: false},
+
+		// regex
+		{s: `host =~ /^server\d+$/`, v: true},
+		{s: `host !~ /^server\d+$/`, v: false},
+		{s: `region =~ /^us\//`, v: true},
+
+		// in
+		{s: `host IN ('server02', 'server01')`, v: true},
+		{s: `host IN ('server02')`, v: false},
+		{s: `n IN (1.5, 3.0)`, v: true},
+		{s: `age IN (1h, 90m)`, v: true},
+		{s: `n + 1 IN (n, i64)`, v: false},
+
+		// logical
+		{s: `n > 1 AND host = 'server01'`, v: true},
+		{s: `n > 1 AND host = 'server02' OR ok`, v: true},
+		{s: `n > 1 AND (host = 'server02' OR NOT_EXISTS = 1)`, v: nil},
+		{s: `n < 1 AND missing`, v: false},
+		{s: `ok OR missing`, v: true},
+	}
+
+	for _, test := range tests {
+		expr, err := priv.ParseExpr(test.s)
+		if err != nil {
+			t.Fatalf("parse expr %s got unexpected error '%v'", test.s, err)
+		}
+		v, err := priv.Eval(expr, m)
+		if test.v == nil {
+			if err == nil {
+				t.Fatalf("eval %s got %v expect error", test.s, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("eval %s got unexpected error '%v'", test.s, err)
+		}
+		if !reflect.DeepEqual(v, test.v) {
+			t.Fatalf("eval %s got %#v expect %#v", test.s, v, test.v)
+		}
+	}
+}
+
+func TestEvalErr(t *testing.T) {
+	m := map[string]interface{}{"host": "server01", "n": 3, "age": time.Hour, "ok": true,
+		"big": uint64(math.MaxInt64 + 1), "jn": json.Number("1x")}
+
+	var tests = []struct {
+		s string
+		e string
+	}{
+		{s: `missing = 1`, e: `undefined variable missing`},
+		{s: `host = 1`, e: `invalid operation host = 1: operator = not defined on string and integer`},
+		{s: `host - 'a'`, e: `invalid operation host - 'a': operator - not defined on string and string`},
+		{s: `n & 1.0`, e: `invalid operation n & 1.0: operator & not defined on integer and float`},
+		{s: `age > 60`, e: `invalid operation age > 60: operator > not defined on duration and integer`},
+		{s: `age + 1`, e: `invalid operation age + 1: operator + not defined on duration and integer`},
+		{s: `ok < false`, e: `invalid operation ok < false: operator < not defined on boolean and boolean`},
+		{s: `n =~ /3/`, e: `invalid operation n =~ /3/: operator =~ not defined on integer and regex`},
+		{s: `n AND ok`, e: `invalid operation n AND ok: operator AND not defined on integer`},
+		{s: `ok AND n`, e: `invalid operation ok AND n: operator AND not defined on boolean and integer`},
+		{s: `host IN (1, 'server01')`, e: `invalid operation host IN (1, 'server01'): operator IN not defined on string and integer`},
+		{s: `n / 0`, e: `division by zero`},
+		{s: `n % 0`, e: `division by zero`},
+		{s: `1.0 / 0`, e: `division by zero`},
+		{s: `age / 0`, e: `division by zero`},
+		{s: `big > 1`, e: `variable big: integer 9223372036854775808 overflows int64`},
+		{s: `jn > 1`, e: `variable jn: invalid number 1x`},
+	}
+
+	for _, test := range tests {
+		expr, err := priv.ParseExpr(test.s)
+		if err != nil {
+			t.Fatalf("parse expr %s got unexpected error '%v'", test.s, err)
+		}
+		if _, err := priv.Eval(expr, m); err == nil || err.Error() != test.e {
+			t.Fatalf("eval %s got error '%v' expect error '%s'", test.s, err, test.e)
+		}
+	}
+
+	expr, _ := priv.ParseExpr(`host = 1`)
+	_, err := priv.Eval(expr, m)
+	var typeErr *priv.TypeError
+	if !errors.As(err, &typeErr) || typeErr.LHS != "server01" || typeErr.RHS != int64(1) || typeErr.Expr != expr {
+		t.Fatalf("eval %s got error %#v expect type error", expr, err)
+	}
+
+	expr, _ = priv.ParseExpr(`n > missing`)
+	_, err = priv.Eval(expr, m)
+	var undefinedErr *priv.UndefinedError
+	if !errors.As(err, &undefinedErr) || undefinedErr.Name != "missing" {
+		t.Fatalf("eval %s got error %#v expect undefined error", expr, err)
+	}
+
+	expr, _ = priv.ParseExpr(`n / 0`)
+	if _, err := priv.Eval(expr, m); !errors.Is(err, priv.ErrDivisionByZero) {
+		t.Fatalf("eval %s got error %#v expect division by zero", expr, err)
+	}
+}
+
+func TestEvalRegexString(t *testing.T) {
+	m := map[string]interface{}{"host": "server01", "pattern": `^server\d+$`, "bad": `(`}
+
+	match := &priv.BinaryExpr{Op: priv.EQREGEX, LHS: &priv.VarRef{Val: "host"}, RHS: &priv.VarRef{Val: "pattern"}}
+	for i := 0; i < 2; i++ {
+		if v, err := priv.Eval(match, m); err != nil || v != true {
+			t.Fatalf("eval %s got %v, error '%v' expect true", match, v, err)
+		}
+	}
+
+	notMatch := &priv.BinaryExpr{Op: priv.NEQREGEX, LHS: &priv.VarRef{Val: "host"}, RHS: &priv.StringLiteral{Val: `^db`}}
+	if v, err := priv.Eval(notMatch, m); err != nil || v != true {
+		t.Fatalf("eval %s got %v, error '%v' expect true", notMatch, v, err)
+	}
+
+	bad := &priv.BinaryExpr{Op: priv.EQREGEX, LHS: &priv.VarRef{Val: "host"}, RHS: &priv.VarRef{Val: "bad"}}
+	if _, err := priv.Eval(bad, m); err == nil {
+		t.Fatalf("eval %s expect error compiling regex", bad)
+	}
+
+	// regexes parsed from the same string are shared
+	a, _ := priv.ParseExpr(`host =~ /^cache$/`)
+	b, _ := priv.ParseExpr(`host !~ /^cache$/`)
+	if a.(*priv.BinaryExpr).RHS.(*priv.RegexLiteral).Val != b.(*priv.BinaryExpr).RHS.(*priv.RegexLiteral).Val {
+		t.Fatalf("expect regex compiled once")
+	}
+	if !reflect.DeepEqual(a.(*priv.BinaryExpr).RHS.(*priv.RegexLiteral).Val, regexp.MustCompile(`^cache$`)) {
+		t.Fatalf("expect cached regex equal to compiled one")
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	m := map[string]interface{}{"host": "server01", "n": 3}
+
+	expr, _ := priv.ParseExpr(`host = 'server01' AND n > 1`)
+	if ok, err := priv.EvalBool(expr, m); err != nil || !ok {
+		t.Fatalf("eval bool %s got %v, error '%v' expect true", expr, ok, err)
+	}
+
+	expr, _ = priv.ParseExpr(`n + 1`)
+	if _, err := priv.EvalBool(expr, m); err == nil || err.Error() != `expression n + 1 yields integer instead of boolean` {
+		t.Fatalf("eval bool %s got error '%v'", expr, err)
+	}
+}
@@ -0,0 +1,447 @@
+package priv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrGrantExpired is returned when granting privileges which expire before now.
+var ErrGrantExpired = errors.New("grant already expired")
+
+// ExpiringPrivilegeSet is an implementation of PrivilegeSet which supports
+// time-limited grants besides permanent ones.
+//
+// Temporary grants are kept in separate privilege trees grouped by expiry
+// time, effective privileges are union of permanent privileges and temporary
+// privileges not expired yet, so an expired grant is absent without touching
+// anything else. Deleting privileges applies to both permanent and temporary
+// grants, while denies are always permanent. Expired trees are removed by
+// Sweep, which may be run periodically by StartSweeper.
+//
+// It is safe for concurrent use.
+type ExpiringPrivilegeSet struct {
+	mu        sync.RWMutex
+	clock     func() time.Time
+	permanent *PrivilegeTree
+	temporary []*temporaryGrants // sorted by expiry time
+}
+
+// temporaryGrants are privileges granted until the same time.
+type temporaryGrants struct {
+	expires time.Time
+	tree    *PrivilegeTree
+}
+
+// NewExpiringPrivilegeSet create an empty privilege set, which decides if a
+// grant has expired by time returned from clock, time.Now is used if clock
+// is nil.
+func NewExpiringPrivilegeSet(clock func() time.Time) *ExpiringPrivilegeSet {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &ExpiringPrivilegeSet{clock: clock, permanent: NewPrivilegeTree()}
+}
+
+func (s *ExpiringPrivilegeSet) implPrivilegeSet() {
+	var _ PrivilegeSet = (*ExpiringPrivilegeSet)(nil)
+}
+
+// SetAll set full privileges to privilege set, all temporary grants are removed.
+func (s *ExpiringPrivilegeSet) SetAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.SetAll()
+	s.temporary = nil
+}
+
+// ClearAll clear all privileges from privilege set, including temporary grants.
+func (s *ExpiringPrivilegeSet) ClearAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.ClearAll()
+	s.temporary = nil
+}
+
+// AddGlobal add some privileges to global resource permanently.
+func (s *ExpiringPrivilegeSet) AddGlobal(privilege Privilege) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.AddGlobal(privilege)
+}
+
+// AddGlobalUntil add some privileges to global resource until expires, a zero
+// expires means permanently. Privileges already expired are not granted
+// and ErrGrantExpired is returned.
+func (s *ExpiringPrivilegeSet) AddGlobalUntil(privilege Privilege, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree := s.grants(expires)
+	if tree == nil {
+		return expiredError(expires)
+	}
+	tree.AddGlobal(privilege)
+	return nil
+}
+
+// DeleteGlobal delete some privileges from all resources, including
+// temporary grants.
+func (s *ExpiringPrivilegeSet) DeleteGlobal(privilege Privilege) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.DeleteGlobal(privilege)
+	for _, g := range s.temporary {
+		g.tree.DeleteGlobal(privilege)
+	}
+	s.compact()
+}
+
+// Add some privileges to given resource permanently.
+func (s *ExpiringPrivilegeSet) Add(resource *ResourcePath, privilege Privilege) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.Add(resource, privilege)
+}
+
+// AddUntil add some privileges to given resource until expires, a zero
+// expires means permanently. Privileges already expired are not granted
+// and ErrGrantExpired is returned.
+func (s *ExpiringPrivilegeSet) AddUntil(resource *ResourcePath, privilege Privilege, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree := s.grants(expires)
+	if tree == nil {
+		return expiredError(expires)
+	}
+	tree.Add(resource, privilege)
+	return nil
+}
+
+// AddWhereUntil add some privileges to given resource on rows satisfying the
+// condition until expires, a zero expires means permanently. Privileges
+// already expired are not granted and ErrGrantExpired is returned.
+func (s *ExpiringPrivilegeSet) AddWhereUntil(resource *ResourcePath, privilege Privilege, condition Expr, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tree := s.grants(expires)
+	if tree == nil {
+		return expiredError(expires)
+	}
+	tree.AddWhere(resource, privilege, condition)
+	return nil
+}
+
+// Grant applies privileges granted by the statement, which expire according
+// to the FOR or UNTIL clause of the statement, and are limited to rows
+// satisfying the WHERE clause if any. Nothing is granted if the UNTIL time
+// has passed, ErrGrantExpired is returned instead.
+func (s *ExpiringPrivilegeSet) Grant(stmt *GrantStatement) error {
+	expires := stmt.ExpiresAt(s.clock())
+	if stmt.Condition != nil {
+		return s.AddWhereUntil(stmt.On, stmt.Privilege(), stmt.Condition, expires)
+	} else if len(stmt.On.Segs) == 0 {
+		return s.AddGlobalUntil(stmt.Privilege(), expires)
+	}
+	return s.AddUntil(stmt.On, stmt.Privilege(), expires)
+}
+
+// Delete some privielges from all resources under the given resource name,
+// including temporary grants.
+func (s *ExpiringPrivilegeSet) Delete(resource *ResourcePath, privilege Privilege) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.Delete(resource, privilege)
+	for _, g := range s.temporary {
+		g.tree.Delete(resource, privilege)
+	}
+	s.compact()
+}
+
+// Deny some privileges on given resource and all resources under it.
+func (s *ExpiringPrivilegeSet) Deny(resource *ResourcePath, privilege Privilege) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.Deny(resource, privilege)
+}
+
+// Undeny removes denied privileges from all resources under the given resource name.
+func (s *ExpiringPrivilegeSet) Undeny(resource *ResourcePath, privilege Privilege) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.Undeny(resource, privilege)
+}
+
+// UnionWith combine all privileges of 2 privilege sets, temporary grants of
+// another ExpiringPrivilegeSet keep their expiry time.
+func (s *ExpiringPrivilegeSet) UnionWith(o PrivilegeSet) {
+	other, ok := o.(*ExpiringPrivilegeSet)
+	if !ok {
+		tree := treeOf(o)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.permanent.UnionWith(tree)
+		return
+	}
+
+	// copy other first, as it may be s itself
+	other.mu.RLock()
+	permanent := other.permanent.clone()
+	temporary := make([]*temporaryGrants, 0, len(other.temporary))
+	for _, g := range other.temporary {
+		temporary = append(temporary, &temporaryGrants{expires: g.expires, tree: g.tree.clone()})
+	}
+	other.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.UnionWith(permanent)
+	for _, g := range temporary {
+		if tree := s.grants(g.expires); tree != nil {
+			tree.UnionWith(g.tree)
+		}
+	}
+}
+
+// DifferentWith delete all privileges from the given privilege set, including
+// temporary grants.
+func (s *ExpiringPrivilegeSet) DifferentWith(o PrivilegeSet) {
+	other := treeOf(o)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.DifferentWith(other)
+	for _, g := range s.temporary {
+		g.tree.DifferentWith(other)
+	}
+	s.compact()
+}
+
+// IntersectWith keeps only privileges also contained by the given privilege
+// set, privileges kept are still permanent or expire as before.
+func (s *ExpiringPrivilegeSet) IntersectWith(o PrivilegeSet) {
+	other := treeOf(o)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.IntersectWith(other)
+	for _, g := range s.temporary {
+		g.tree.IntersectWith(other)
+	}
+	s.compact()
+}
+
+// SymmetricDifference keeps privileges contained by exactly one of the 2
+// privilege sets. Privileges of s kept still expire as before, while
+// privileges taken from the given set are permanent.
+func (s *ExpiringPrivilegeSet) SymmetricDifference(o PrivilegeSet) {
+	other := copyTreeOf(o)
+	otherOnly := other.clone()
+	otherOnly.DifferentWith(s.Active())
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.DifferentWith(other)
+	for _, g := range s.temporary {
+		g.tree.DifferentWith(other)
+	}
+	s.permanent.UnionWith(otherOnly)
+	s.compact()
+}
+
+// GlobalContain checks if root node have the given privileges.
+// It should be noticed that this does not mean have privileges on every resources.
+func (s *ExpiringPrivilegeSet) GlobalContain(privilege Privilege) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sum := s.permanent.Privilege
+	for _, g := range s.active() {
+		sum |= g.tree.Privilege
+	}
+	return privilege&^s.permanent.effective(sum, s.permanent.Denied) == NoPrivilege
+}
+
+// Contain checks if privileges set contains privileges on the given resource,
+// expired grants are treated as absent.
+func (s *ExpiringPrivilegeSet) Contain(resource *ResourcePath, privilege Privilege) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sum, denied := s.permanent.lookup(resource)
+	for _, g := range s.active() {
+		granted, _ := g.tree.lookup(resource)
+		sum |= granted
+	}
+	return s.permanent.effective(sum, denied)&privilege == privilege
+}
+
+// ContainWith checks if privileges set contains privileges on rows of the
+// given resource with the given tags, expired grants are treated as absent.
+func (s *ExpiringPrivilegeSet) ContainWith(resource *ResourcePath, privilege Privilege, tags map[string]string) bool {
+	if s.Contain(resource, privilege) {
+		return true
+	}
+	return s.Active().ContainWith(resource, privilege, tags)
+}
+
+// Contains checks if the privilege set contains all privileges from another set.
+func (s *ExpiringPrivilegeSet) Contains(o PrivilegeSet) bool {
+	return s.Active().Contains(o)
+}
+
+// Equal checks if 2 privilege sets contain each other, expired grants are
+// treated as absent.
+func (s *ExpiringPrivilegeSet) Equal(o PrivilegeSet) bool {
+	return s.Active().Equal(o)
+}
+
+// Powerless check set if don't has any privilege not expired.
+func (s *ExpiringPrivilegeSet) Powerless() bool {
+	return s.Active().Powerless()
+}
+
+// Walk visits every node of privileges not expired with its effective privileges.
+func (s *ExpiringPrivilegeSet) Walk(fn func(path *ResourcePath, effective Privilege) bool) {
+	s.Active().Walk(fn)
+}
+
+// Normalize normalizes permanent and temporary privileges separately, and
+// drops temporary grants left with nothing.
+func (s *ExpiringPrivilegeSet) Normalize() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.permanent.Normalize()
+	for _, g := range s.temporary {
+		g.tree.Normalize()
+	}
+	s.compact()
+}
+
+// Clone returns a deep copy of the privilege set including temporary grants,
+// which uses the same clock.
+func (s *ExpiringPrivilegeSet) Clone() PrivilegeSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c := &ExpiringPrivilegeSet{clock: s.clock, permanent: s.permanent.clone()}
+	for _, g := range s.temporary {
+		c.temporary = append(c.temporary, &temporaryGrants{expires: g.expires, tree: g.tree.clone()})
+	}
+	return c
+}
+
+// Active returns a copy of all privileges not expired yet.
+func (s *ExpiringPrivilegeSet) Active() *PrivilegeTree {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tree := s.permanent.clone()
+	for _, g := range s.active() {
+		tree.UnionWith(g.tree)
+	}
+	return tree
+}
+
+// Sweep removes expired grants and returns how many groups of grants sharing
+// the same expiry time are removed.
+func (s *ExpiringPrivilegeSet) Sweep() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.temporary) - len(s.active())
+	s.temporary = s.temporary[n:]
+	return n
+}
+
+// StartSweeper runs Sweep every interval in background until stop is called.
+func (s *ExpiringPrivilegeSet) StartSweeper(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.Sweep()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (s *ExpiringPrivilegeSet) String() string {
+	return s.Active().String()
+}
+
+// active returns temporary grants not expired yet.
+func (s *ExpiringPrivilegeSet) active() []*temporaryGrants {
+	now := s.clock()
+	i := sort.Search(len(s.temporary), func(i int) bool {
+		return now.Before(s.temporary[i].expires)
+	})
+	return s.temporary[i:]
+}
+
+// grants returns tree of privileges granted until expires, creates it if not
+// exists. Permanent tree is returned if expires is zero, and nil is returned
+// if expires has passed.
+func (s *ExpiringPrivilegeSet) grants(expires time.Time) *PrivilegeTree {
+	if expires.IsZero() {
+		return s.permanent
+	}
+	if !s.clock().Before(expires) {
+		return nil
+	}
+
+	i := sort.Search(len(s.temporary), func(i int) bool {
+		return !s.temporary[i].expires.Before(expires)
+	})
+	if i < len(s.temporary) && s.temporary[i].expires.Equal(expires) {
+		return s.temporary[i].tree
+	}
+
+	g := &temporaryGrants{expires: expires, tree: NewPrivilegeTree()}
+	s.temporary = append(s.temporary, nil)
+	copy(s.temporary[i+1:], s.temporary[i:])
+	s.temporary[i] = g
+	return g.tree
+}
+
+func expiredError(expires time.Time) error {
+	return fmt.Errorf("%w at %s", ErrGrantExpired, expires.Format("2006-01-02 15:04:05 MST"))
+}
+
+// compact removes temporary trees without any privilege.
+func (s *ExpiringPrivilegeSet) compact() {
+	temporary := s.temporary[:0]
+	for _, g := range s.temporary {
+		if !g.tree.redundant() {
+			temporary = append(temporary, g)
+		}
+	}
+	for i := len(temporary); i < len(s.temporary); i++ {
+		s.temporary[i] = nil
+	}
+	s.temporary = temporary
+}
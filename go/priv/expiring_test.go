@@ -0,0 +1,268 @@
+package priv_test
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+// fakeClock is a clock which only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestExpiringPrivilegeSet(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	set := priv.NewExpiringPrivilegeSet(clock.Now)
+
+	for _, s := range []string{
+		`GRANT SELECT ON mydb TO alice`,
+		`GRANT INSERT ON mydb TO alice FOR 2h`,
+		`GRANT DELETE ON mydb.autogen.cpu TO alice FOR 30m`,
+		`GRANT AUDIT TO alice UNTIL '2026-10-01 01:00:00'`,
+		`GRANT DROP ON yourdb TO alice UNTIL '2026-09-30'`,
+	} {
+		stmt, err := priv.ParseStatement(s)
+		if err != nil {
+			t.Fatalf("parse %s got error '%v'", s, err)
+		}
+		err = set.Grant(stmt.(*priv.GrantStatement))
+		if expired := strings.Contains(s, "2026-09-30"); errors.Is(err, priv.ErrGrantExpired) != expired || !expired && err != nil {
+			t.Fatalf("grant %s got error '%v'", s, err)
+		}
+	}
+	set.Deny(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.InsertPrivilege)
+
+	cpu, secret := priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.CreateResourcePathUnsafe("mydb.autogen.secret")
+	var tests = []struct {
+		advance time.Duration
+		global  priv.Privilege
+		cpu     priv.Privilege
+		secret  priv.Privilege
+		swept   int
+	}{
+		{
+			global: priv.AuditPrivilege,
+			cpu:    priv.SelectPrivilege | priv.InsertPrivilege | priv.DeletePrivilege,
+			secret: priv.SelectPrivilege,
+		},
+		{
+			advance: 30 * time.Minute,
+			global:  priv.AuditPrivilege,
+			cpu:     priv.SelectPrivilege | priv.InsertPrivilege,
+			secret:  priv.SelectPrivilege,
+			swept:   1,
+		},
+		{
+			advance: 30 * time.Minute,
+			cpu:     priv.SelectPrivilege | priv.InsertPrivilege,
+			secret:  priv.SelectPrivilege,
+			swept:   1,
+		},
+		{
+			advance: time.Hour,
+			cpu:     priv.SelectPrivilege,
+			secret:  priv.SelectPrivilege,
+			swept:   1,
+		},
+	}
+	for i, test := range tests {
+		clock.Advance(test.advance)
+		for _, p := range []priv.Privilege{priv.AuditPrivilege, priv.GrantPrivilege} {
+			if act, exp := set.GlobalContain(p), test.global&p == p; act != exp {
+				t.Fatalf("case %d global contain [%s] got %v expect %v", i, p, act, exp)
+			}
+		}
+		for _, p := range []priv.Privilege{priv.SelectPrivilege, priv.InsertPrivilege, priv.DeletePrivilege, priv.DropPrivilege} {
+			if act, exp := set.Contain(cpu, p), test.cpu&p == p; act != exp {
+				t.Fatalf("case %d contain [%s] on %s got %v expect %v", i, p, cpu, act, exp)
+			}
+			if act, exp := set.Contain(secret, p), test.secret&p == p; act != exp {
+				t.Fatalf("case %d contain [%s] on %s got %v expect %v", i, p, secret, act, exp)
+			}
+		}
+
+		// sweeping does not change privileges
+		before := set.Active()
+		if act, exp := set.Sweep(), test.swept; act != exp {
+			t.Fatalf("case %d sweep got %d expect %d", i, act, exp)
+		}
+		if act := set.Active(); !reflect.DeepEqual(act, before) {
+			t.Fatalf("case %d sweep changed privileges from %s to %s", i, before, act)
+		}
+	}
+}
+
+func TestExpiringPrivilegeSetDelete(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	set := priv.NewExpiringPrivilegeSet(clock.Now)
+	set.AddUntil(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege|priv.InsertPrivilege, clock.now.Add(time.Hour))
+	set.AddGlobalUntil(priv.ShowUsersPrivilege, clock.now.Add(time.Hour))
+
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.SelectPrivilege)
+	if set.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege) {
+		t.Fatalf("expect temporary grant deleted, got %s", set)
+	}
+	if !set.Contain(priv.CreateResourcePathUnsafe("mydb.daily"), priv.SelectPrivilege) {
+		t.Fatalf("expect temporary grant on other resources kept, got %s", set)
+	}
+
+	// privileges granted permanently later do not expire
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.InsertPrivilege)
+	set.DeleteGlobal(priv.ShowUsersPrivilege | priv.SelectPrivilege)
+	clock.Advance(time.Hour)
+	if !set.Contain(priv.CreateResourcePathUnsafe("mydb"), priv.InsertPrivilege) {
+		t.Fatalf("expect permanent grant kept, got %s", set)
+	}
+	if act := set.Sweep(); act != 1 {
+		t.Fatalf("sweep got %d expect 1", act)
+	}
+
+	// all temporary grants are deleted, nothing to sweep
+	set.AddGlobalUntil(priv.ShowUsersPrivilege, clock.now.Add(time.Hour))
+	set.DeleteGlobal(priv.ShowUsersPrivilege)
+	if act := set.Sweep(); act != 0 {
+		t.Fatalf("sweep got %d expect 0", act)
+	}
+
+	// grants already expired are rejected
+	err := set.AddGlobalUntil(priv.ShowUsersPrivilege, clock.now)
+	if !errors.Is(err, priv.ErrGrantExpired) || err.Error() != "grant already expired at 2026-10-01 01:00:00 UTC" {
+		t.Fatalf("add expired grant got error '%v'", err)
+	}
+	if set.GlobalContain(priv.ShowUsersPrivilege) {
+		t.Fatalf("expect expired grant ignored, got %s", set)
+	}
+}
+
+func TestExpiringPrivilegeSetUnion(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	set, other := priv.NewExpiringPrivilegeSet(clock.Now), priv.NewExpiringPrivilegeSet(clock.Now)
+	other.AddUntil(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege, clock.now.Add(time.Hour))
+	other.Add(priv.CreateResourcePathUnsafe("yourdb"), priv.SelectPrivilege)
+
+	set.UnionWith(other)
+	set.UnionWith(set)
+	if !set.Contains(other) || !other.Contains(set) {
+		t.Fatalf("expect %s equals %s", set, other)
+	}
+
+	clock.Advance(time.Hour)
+	if set.Contain(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege) {
+		t.Fatalf("expect grant from union expired, got %s", set)
+	}
+	if !set.Contain(priv.CreateResourcePathUnsafe("yourdb"), priv.SelectPrivilege) {
+		t.Fatalf("expect permanent grant from union kept, got %s", set)
+	}
+}
+
+func TestExpiringPrivilegeSetIntersect(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	set := priv.NewExpiringPrivilegeSet(clock.Now)
+	set.AddUntil(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege|priv.InsertPrivilege, clock.now.Add(time.Hour))
+	set.Add(priv.CreateResourcePathUnsafe("yourdb"), priv.SelectPrivilege|priv.InsertPrivilege)
+	clone := set.Clone()
+
+	other := priv.NewPrivilegeTree()
+	other.AddGlobal(priv.SelectPrivilege)
+	set.IntersectWith(other)
+
+	mydb, yourdb := priv.CreateResourcePathUnsafe("mydb"), priv.CreateResourcePathUnsafe("yourdb")
+	if !set.Contain(mydb, priv.SelectPrivilege) || set.Contain(mydb, priv.InsertPrivilege) ||
+		!set.Contain(yourdb, priv.SelectPrivilege) || set.Contain(yourdb, priv.InsertPrivilege) {
+		t.Fatalf("intersection got %s", set)
+	}
+	if !clone.Contain(mydb, priv.InsertPrivilege) {
+		t.Fatalf("clone changed by intersection, got %s", clone)
+	}
+
+	clock.Advance(time.Hour)
+	if set.Contain(mydb, priv.SelectPrivilege) || clone.Contain(mydb, priv.SelectPrivilege) {
+		t.Fatalf("expect temporary grants still expire, got %s and %s", set, clone)
+	}
+	if !set.Contain(yourdb, priv.SelectPrivilege) {
+		t.Fatalf("expect permanent grant kept, got %s", set)
+	}
+}
+
+// TestExpiringPrivilegeSetFuzz checks an expiring set against a privilege tree
+// built by the same operations with expired grants skipped.
+func TestExpiringPrivilegeSetFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+		set, tree := priv.NewExpiringPrivilegeSet(clock.Now), priv.NewPrivilegeTree()
+		for j := r.Intn(16); j > 0; j-- {
+			resource, privilege := randomResourcePath(r), randomPrivilege(r)
+			expires := clock.now.Add(time.Duration(r.Intn(4)+1) * time.Hour)
+			alive := expires.After(clock.now.Add(2 * time.Hour))
+			switch r.Intn(8) {
+			case 0:
+				set.AddGlobal(privilege)
+				tree.AddGlobal(privilege)
+			case 1:
+				set.DeleteGlobal(privilege)
+				tree.DeleteGlobal(privilege)
+			case 2:
+				set.Add(resource, privilege)
+				tree.Add(resource, privilege)
+			case 3:
+				set.Delete(resource, privilege)
+				tree.Delete(resource, privilege)
+			case 4:
+				set.Deny(resource, privilege)
+				tree.Deny(resource, privilege)
+			case 5:
+				set.Undeny(resource, privilege)
+				tree.Undeny(resource, privilege)
+			case 6:
+				set.AddUntil(resource, privilege, expires)
+				if alive {
+					tree.Add(resource, privilege)
+				}
+			case 7:
+				set.AddGlobalUntil(privilege, expires)
+				if alive {
+					tree.AddGlobal(privilege)
+				}
+			}
+		}
+
+		clock.Advance(2 * time.Hour)
+		set.Sweep()
+		for j := 0; j < 16; j++ {
+			resource, privilege := randomResourcePath(r), randomPrivilege(r)
+			if act, exp := set.Contain(resource, privilege), tree.Contain(resource, privilege); act != exp {
+				t.Fatalf("expiring set %s contain [%s] on %s got %v expect %v", set, privilege, resource, act, exp)
+			}
+		}
+		if act, exp := set.Powerless(), tree.Powerless(); act != exp {
+			t.Fatalf("expiring set %s powerless got %v expect %v", set, act, exp)
+		}
+	}
+}
+
+func TestExpiringPrivilegeSetSweeper(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)}
+	set := priv.NewExpiringPrivilegeSet(clock.Now)
+	set.AddGlobalUntil(priv.AuditPrivilege, clock.now.Add(time.Hour))
+	clock.Advance(time.Hour)
+
+	stop := set.StartSweeper(time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	stop()
+	stop()
+
+	if act := set.Sweep(); act != 0 {
+		t.Fatalf("expect expired grants swept in background, got %d left", act)
+	}
+}
@@ -0,0 +1,212 @@
+package priv
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Level is the level of a node in privilege tree.
+type Level int
+
+const (
+	GlobalLevel Level = iota
+	DatabaseLevel
+	RetentionPolicyLevel
+	MeasurementLevel
+)
+
+var level2name = map[Level]string{
+	GlobalLevel:          "global",
+	DatabaseLevel:        "database",
+	RetentionPolicyLevel: "retention policy",
+	MeasurementLevel:     "measurement",
+}
+
+// String returns a string representation of a Level.
+func (l Level) String() string {
+	if name, ok := level2name[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("level %d", int(l))
+}
+
+// Toggle is a node along the resource path which changed a privilege bit.
+type Toggle struct {
+	// Level of the node.
+	Level Level
+	// Resource of the node, a regex resource for pattern nodes.
+	Resource *ResourcePath
+	// Granted is true if the node granted the bit, false if revoked.
+	Granted bool
+	// Denied is true if the node denies the bit explicitly, which beats all
+	// grants, Granted is meaningless in this case.
+	Denied bool
+}
+
+// BitExplanation explains why a single privilege bit is granted or not.
+type BitExplanation struct {
+	// Privilege is the single bit explained.
+	Privilege Privilege
+	// Granted is true if the bit is granted on the resource.
+	Granted bool
+	// Toggles are nodes which changed the bit in order from root, empty if
+	// the bit has never been granted on the path.
+	Toggles []Toggle
+	// Legacy is ReadPrivilege or WritePrivilege if the bit is granted only
+	// because of the legacy READ/WRITE mapping, otherwise NoPrivilege.
+	Legacy Privilege
+}
+
+// Explanation is a per-bit trace of a privilege check.
+type Explanation struct {
+	Resource  *ResourcePath
+	Privilege Privilege
+	Bits      []BitExplanation
+}
+
+// Granted returns true if all privileges are granted, which is the same as
+// result of Contain.
+func (e *Explanation) Granted() bool {
+	for _, bit := range e.Bits {
+		if !bit.Granted {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats explanation as lines of text, e.g.
+//
+//	SELECT on mydb.autogen.cpu: denied
+//	  granted on database mydb
+//	  revoked on retention policy mydb.autogen
+func (e *Explanation) String() string {
+	var buf bytes.Buffer
+	resource := resourceName(e.Resource.Segs)
+	if e.Resource.Regex != nil {
+		resource = e.Resource.String()
+	}
+
+	for _, bit := range e.Bits {
+		result := "denied"
+		if bit.Granted {
+			result = "granted"
+		}
+		buf.WriteString(fmt.Sprintf("%s on %s: %s", bit.Privilege, resource, result))
+		if bit.Legacy != NoPrivilege {
+			buf.WriteString(fmt.Sprintf(" by legacy privilege %s", bit.Legacy))
+		} else if len(bit.Toggles) == 0 {
+			buf.WriteString(", never granted")
+		}
+		buf.WriteString("\n")
+
+		for _, toggle := range bit.Toggles {
+			action := "revoked"
+			if toggle.Denied {
+				action = "denied"
+			} else if toggle.Granted {
+				action = "granted"
+			}
+			if toggle.Level == GlobalLevel {
+				buf.WriteString(fmt.Sprintf("  %s globally\n", action))
+			} else {
+				buf.WriteString(fmt.Sprintf("  %s on %s %s\n", action, toggle.Level, toggle.Resource))
+			}
+		}
+	}
+	return buf.String()
+}
+
+// explainStep is a node which changed effective privileges from before to
+// after, or denied some privileges.
+type explainStep struct {
+	level         Level
+	resource      *ResourcePath
+	before, after Privilege
+	denied        Privilege
+}
+
+// Explain traces the check of privileges on the given resource, in the same
+// way as Contain does, and reports for each bit of privilege the nodes which
+// granted or revoked it.
+func (t *PrivilegeTree) Explain(resource *ResourcePath, privilege Privilege) *Explanation {
+	steps := []explainStep{{level: GlobalLevel, resource: GlobalResource, after: t.Privilege, denied: t.Denied}}
+	sum, denied := t.Privilege, t.Denied
+	for i, seg := range resource.Segs {
+		if t.Tree[seg] == nil {
+			// patterns are combined, each of them is a step from the parent
+			for _, p := range t.matchedPatterns(seg) {
+				steps = append(steps, explainStep{level: Level(i + 1),
+					resource: &ResourcePath{Segs: resource.Segs[:i:i], Regex: p.Regex},
+					before:   sum, after: sum ^ p.Privilege, denied: p.Denied})
+			}
+			sum = t.match(seg, sum)
+			denied |= t.matchDenied(seg)
+			break
+		}
+		t = t.Tree[seg]
+		steps = append(steps, explainStep{level: Level(i + 1),
+			resource: &ResourcePath{Segs: resource.Segs[: i+1 : i+1]},
+			before:   sum, after: sum ^ t.Privilege, denied: t.Denied})
+		sum ^= t.Privilege
+		denied |= t.Denied
+		if i == len(resource.Segs)-1 && resource.Regex != nil {
+			if p := t.Patterns[resource.Regex.String()]; p != nil {
+				steps = append(steps, explainStep{level: Level(i + 2), resource: resource,
+					before: sum, after: sum ^ p.Privilege, denied: p.Denied})
+				sum ^= p.Privilege
+				denied |= p.Denied
+			}
+		}
+	}
+
+	e := &Explanation{Resource: resource, Privilege: privilege}
+	effective := t.effective(sum, denied)
+	sum &^= denied
+	for mask := Privilege(1); mask > 0 && mask <= privilege; mask <<= 1 {
+		if privilege&mask != mask {
+			continue
+		}
+
+		bit := BitExplanation{Privilege: mask, Granted: effective&mask == mask}
+		for _, step := range steps {
+			if (step.before^step.after)&mask == mask {
+				bit.Toggles = append(bit.Toggles, Toggle{Level: step.level, Resource: step.resource,
+					Granted: step.after&mask == mask})
+			}
+			if step.denied&mask == mask {
+				bit.Toggles = append(bit.Toggles, Toggle{Level: step.level, Resource: step.resource, Denied: true})
+			}
+		}
+		if bit.Granted && sum&mask != mask {
+			if sum&ReadPrivilege == ReadPrivilege && ReadGroupPrivileges&mask == mask {
+				bit.Legacy = ReadPrivilege
+			} else {
+				bit.Legacy = WritePrivilege
+			}
+		}
+		e.Bits = append(e.Bits, bit)
+	}
+	return e
+}
+
+// matchedPatterns returns patterns matching the name sorted by regex, patterns
+// changing nothing are left out as they match nothing.
+func (t *PrivilegeTree) matchedPatterns(name string) []*PrivilegeTree {
+	var matched []*PrivilegeTree
+	for _, v := range t.Patterns {
+		if v != nil && !v.redundant() && v.Regex.MatchString(name) {
+			matched = append(matched, v)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Regex.String() < matched[j].Regex.String()
+	})
+	return matched
+}
+
+// Explain traces the check of privileges on the given resource.
+func (s *ConcurrentPrivilegeSet) Explain(resource *ResourcePath, privilege Privilege) *Explanation {
+	return s.load().Explain(resource, privilege)
+}
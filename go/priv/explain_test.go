@@ -0,0 +1,104 @@
+package priv_test
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestExplain(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(priv.ReadPrivilege | priv.GrantPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.InsertPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.InsertPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.InsertPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^mem_/"), priv.DeletePrivilege)
+	set.Deny(priv.CreateResourcePathUnsafe("mydb.autogen.disk"), priv.SelectPrivilege)
+
+	var tests = []struct {
+		r string
+		p priv.Privilege
+		s string
+	}{
+		{
+			r: "mydb.autogen.cpu",
+			p: priv.InsertPrivilege | priv.SelectPrivilege | priv.DropPrivilege,
+			s: "INSERT on mydb.autogen.cpu: granted\n" +
+				"  granted on database mydb\n" +
+				"  revoked on retention policy mydb.autogen\n" +
+				"  granted on measurement mydb.autogen.cpu\n" +
+				"SELECT on mydb.autogen.cpu: granted by legacy privilege READ\n" +
+				"DROP on mydb.autogen.cpu: denied, never granted\n",
+		},
+		{
+			r: "mydb.autogen.disk",
+			p: priv.SelectPrivilege,
+			s: "SELECT on mydb.autogen.disk: denied\n" +
+				"  denied on measurement mydb.autogen.disk\n",
+		},
+		{
+			r: "mydb.autogen.mem",
+			p: priv.InsertPrivilege,
+			s: "INSERT on mydb.autogen.mem: denied\n" +
+				"  granted on database mydb\n" +
+				"  revoked on retention policy mydb.autogen\n",
+		},
+		{
+			r: "mydb.autogen.mem_used",
+			p: priv.DeletePrivilege,
+			s: "DELETE on mydb.autogen.mem_used: granted\n" +
+				"  granted on measurement mydb.autogen./^mem_/\n",
+		},
+		{
+			r: "",
+			p: priv.ReadPrivilege | priv.GrantPrivilege | priv.AuditPrivilege,
+			s: "READ on global resource: granted\n" +
+				"  granted globally\n" +
+				"GRANT on global resource: granted\n" +
+				"  granted globally\n" +
+				"AUDIT on global resource: denied, never granted\n",
+		},
+	}
+	for _, test := range tests {
+		resource := priv.CreateResourcePathUnsafe(test.r)
+		e := set.Explain(resource, test.p)
+		if act, exp := e.String(), test.s; act != exp {
+			t.Fatalf("explain [%s] on %s got\n%s\nexpect\n%s", test.p, test.r, act, exp)
+		}
+		if act, exp := e.Granted(), set.Contain(resource, test.p); act != exp {
+			t.Fatalf("explain [%s] on %s got granted %v expect %v", test.p, test.r, act, exp)
+		}
+	}
+}
+
+func TestExplainFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		set := randomPrivilegeTree(r)
+		resource, privilege := randomResourcePath(r), randomPrivilege(r)
+
+		e := set.Explain(resource, privilege)
+		if act, exp := e.Granted(), set.Contain(resource, privilege); act != exp {
+			t.Fatalf("explain [%s] on %s of %s got granted %v expect %v", privilege, resource, set, act, exp)
+		}
+		for _, bit := range e.Bits {
+			if act, exp := bit.Granted, set.Contain(resource, bit.Privilege); act != exp {
+				t.Fatalf("explain [%s] on %s of %s got granted %v expect %v", bit.Privilege, resource, set, act, exp)
+			}
+			// a denied bit is never granted
+			denied := false
+			for _, toggle := range bit.Toggles {
+				denied = denied || toggle.Denied
+			}
+			if denied && bit.Granted {
+				t.Fatalf("explain [%s] on %s of %s got denied bit granted", bit.Privilege, resource, set)
+			}
+			// otherwise the last toggle decides the bit unless it comes from combined patterns
+			if n := len(bit.Toggles); n > 0 && !denied && bit.Legacy == priv.NoPrivilege &&
+				bit.Toggles[n-1].Level != priv.MeasurementLevel && bit.Toggles[n-1].Granted != bit.Granted {
+				t.Fatalf("explain [%s] on %s of %s got last toggle %v", bit.Privilege, resource, set, bit.Toggles[n-1])
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package priv
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// Output formats of grants.
+const (
+	TextFormat = "text"
+	CSVFormat  = "csv"
+	JSONFormat = "json"
+)
+
+// GrantRow is a row of SHOW GRANTS result.
+type GrantRow struct {
+	// Resource of the row, empty for global resource.
+	Resource string `json:"resource"`
+	// Privileges effective on the resource.
+	Privileges string `json:"privileges"`
+}
+
+// Grants is the result of SHOW GRANTS.
+type Grants []GrantRow
+
+// GrantsOf lists effective privileges of the tree as rows in the order of
+// Walk. A resource whose privileges are the same as it would have without its
+// node, that is inherited from its parent and patterns it matches, is left
+// out, so is the global resource without any privilege. Privileges granted
+// under a condition are listed in a separate row of the resource, suffixed
+// with WHERE and the condition.
+func GrantsOf(t *PrivilegeTree) Grants {
+	grants := Grants{}
+	t.grants(nil, NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, &grants)
+	return grants
+}
+
+// grants appends rows of the node at segs and nodes under it, sum, denied and
+// conditions are privileges granted, denied and granted under conditions on
+// its parent, inherited and virtual are effective privileges and conditional
+// privileges it would have without the node.
+func (t *PrivilegeTree) grants(segs []string, sum, denied, inherited Privilege,
+	conditions, virtual map[string]*ConditionalPrivilege, grants *Grants) {
+	sum ^= t.Privilege
+	denied |= t.Denied
+	conditions = t.inheritConditions(conditions)
+
+	resource := (&ResourcePath{Segs: segs, Regex: t.Regex}).String()
+	effective := t.effective(sum, denied)
+	if len(segs) > 0 || t.Regex != nil {
+		effective &= AllResourcePrivileges
+	}
+	if effective != inherited {
+		*grants = append(*grants, GrantRow{Resource: resource, Privileges: effective.String()})
+	}
+	for _, c := range sortedConditions(conditions) {
+		privilege := c.Privilege &^ denied
+		if privilege == conditionPrivilege(virtual, c.Condition.String())&^denied {
+			continue
+		}
+		// a condition revoked on the resource is listed without privilege
+		row := GrantRow{Resource: resource, Privileges: fmt.Sprintf("WHERE %s", c.Condition)}
+		if privilege != NoPrivilege {
+			row.Privileges = fmt.Sprintf("%s %s", privilege, row.Privileges)
+		}
+		*grants = append(*grants, row)
+	}
+
+	for _, k := range sortedKeys(t.Tree) {
+		if v := t.Tree[k]; v != nil {
+			c := t.virtualChild(k, sum, conditions)
+			inherited := t.effective(sum^c.Privilege, denied|c.Denied) & AllResourcePrivileges
+			v.grants(append(segs[:len(segs):len(segs)], k), sum, denied, inherited, conditions, c.inheritConditions(conditions), grants)
+		}
+	}
+	for _, k := range sortedKeys(t.Patterns) {
+		if v := t.Patterns[k]; v != nil {
+			v.grants(segs, sum, denied, effective&AllResourcePrivileges, conditions, conditions, grants)
+		}
+	}
+}
+
+// Write writes rows in the given format, which is one of TextFormat,
+// CSVFormat and JSONFormat.
+func (g Grants) Write(w io.Writer, format string) error {
+	switch format {
+	case TextFormat:
+		return g.WriteText(w)
+	case CSVFormat:
+		return g.WriteCSV(w)
+	case JSONFormat:
+		return g.WriteJSON(w)
+	}
+	return fmt.Errorf("unsupported format '%s'", format)
+}
+
+// WriteText writes rows as a table with aligned columns and a header, e.g.
+//
+//	resource          privileges
+//	mydb              SELECT
+//	mydb.autogen.cpu  INSERT, SELECT
+func (g Grants) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "resource\tprivileges")
+	for _, row := range g {
+		fmt.Fprintf(tw, "%s\t%s\n", row.Resource, row.Privileges)
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes rows as CSV records after a header record.
+func (g Grants) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"resource", "privileges"}); err != nil {
+		return err
+	}
+	for _, row := range g {
+		if err := cw.Write([]string{row.Resource, row.Privileges}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes rows as a JSON array of objects.
+func (g Grants) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(g)
+}
@@ -0,0 +1,174 @@
+package priv_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestGrantsOf(t *testing.T) {
+	tree := priv.NewPrivilegeTree()
+	tree.AddGlobal(priv.ShowUsersPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.InsertPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen.mem"), priv.SelectPrivilege)
+	tree.Deny(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.SelectPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.daily./^disk/"), priv.DeletePrivilege)
+
+	exp := priv.Grants{
+		{Resource: "", Privileges: "SHOW USERS"},
+		{Resource: "mydb", Privileges: "SELECT"},
+		{Resource: "mydb.autogen.cpu", Privileges: "INSERT, SELECT"},
+		{Resource: "mydb.autogen.secret", Privileges: ""},
+		{Resource: "mydb.daily./^disk/", Privileges: "SELECT, DELETE"},
+	}
+	if act := priv.GrantsOf(tree); !reflect.DeepEqual(act, exp) {
+		t.Fatalf("grants got %v expect %v", act, exp)
+	}
+
+	if act := priv.GrantsOf(priv.NewPrivilegeTree()); len(act) != 0 {
+		t.Fatalf("grants of empty tree got %v", act)
+	}
+}
+
+func TestGrantsOfPatternOverride(t *testing.T) {
+	tree := priv.NewPrivilegeTree()
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^cpu/"), priv.SelectPrivilege)
+	tree.Delete(priv.CreateResourcePathUnsafe("mydb.autogen.cpu_x"), priv.SelectPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu_y"), priv.SelectPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu_z"), priv.InsertPrivilege)
+
+	// cpu_y is the same as given by the pattern, while cpu_x and cpu_z are not
+	exp := priv.Grants{
+		{Resource: "mydb.autogen.cpu_x", Privileges: ""},
+		{Resource: "mydb.autogen.cpu_z", Privileges: "INSERT, SELECT"},
+		{Resource: "mydb.autogen./^cpu/", Privileges: "SELECT"},
+	}
+	if act := priv.GrantsOf(tree); !reflect.DeepEqual(act, exp) {
+		t.Fatalf("grants got %v expect %v", act, exp)
+	}
+}
+
+func TestGrantsOfConditions(t *testing.T) {
+	tree := priv.NewPrivilegeTree()
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege|priv.InsertPrivilege, mustParseExpr(t, `host = 'a'`))
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb.autogen./^disk/"), priv.SelectPrivilege, mustParseExpr(t, `host =~ /^web/`))
+	tree.AddWhere(priv.CreateResourcePathUnsafe("mydb.autogen.disk1"), priv.DeletePrivilege, mustParseExpr(t, `region = 'us'`))
+	tree.Deny(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.InsertPrivilege)
+
+	// the denied INSERT is left out of the condition of cpu, and disk1 takes
+	// the condition of the pattern it matches, which is listed on the pattern only
+	exp := priv.Grants{
+		{Resource: "mydb.autogen.cpu", Privileges: "SELECT WHERE host = 'a'"},
+		{Resource: "mydb.autogen.disk1", Privileges: "DELETE WHERE region = 'us'"},
+		{Resource: "mydb.autogen./^disk/", Privileges: "SELECT WHERE host =~ /^web/"},
+	}
+	if act := priv.GrantsOf(tree); !reflect.DeepEqual(act, exp) {
+		t.Fatalf("grants got %v expect %v", act, exp)
+	}
+}
+
+func TestGrantsWrite(t *testing.T) {
+	grants := priv.Grants{
+		{Resource: "", Privileges: "SHOW USERS"},
+		{Resource: "mydb", Privileges: "SELECT"},
+		{Resource: `"my.db".autogen.cpu`, Privileges: "INSERT, SELECT"},
+	}
+
+	var tests = []struct {
+		format string
+		exp    string
+	}{
+		{
+			format: priv.TextFormat,
+			exp: "resource             privileges\n" +
+				"                     SHOW USERS\n" +
+				"mydb                 SELECT\n" +
+				"\"my.db\".autogen.cpu  INSERT, SELECT\n",
+		},
+		{
+			format: priv.CSVFormat,
+			exp: "resource,privileges\n" +
+				",SHOW USERS\n" +
+				"mydb,SELECT\n" +
+				"\"\"\"my.db\"\".autogen.cpu\",\"INSERT, SELECT\"\n",
+		},
+		{
+			format: priv.JSONFormat,
+			exp: `[{"resource":"","privileges":"SHOW USERS"},{"resource":"mydb","privileges":"SELECT"},` +
+				`{"resource":"\"my.db\".autogen.cpu","privileges":"INSERT, SELECT"}]` + "\n",
+		},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := grants.Write(&buf, test.format); err != nil {
+			t.Fatalf("write %s got error '%v'", test.format, err)
+		}
+		if act := buf.String(); act != test.exp {
+			t.Fatalf("write %s got\n%s\nexpect\n%s", test.format, act, test.exp)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := grants.Write(&buf, "xml"); err == nil || err.Error() != "unsupported format 'xml'" {
+		t.Fatalf("write xml got error '%v'", err)
+	}
+	if err := (priv.Grants{}).WriteJSON(&buf); err != nil || buf.String() != "[]\n" {
+		t.Fatalf("write empty json got %s, error '%v'", buf.String(), err)
+	}
+}
+
+func TestRoleGraphShowGrants(t *testing.T) {
+	g := priv.NewRoleGraph()
+	mustNil(t, g.CreateUser("alice"))
+	mustNil(t, g.CreateRole("ops"))
+	mustNil(t, g.UpdateRole("ops", func(set priv.PrivilegeSet) {
+		set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	}))
+	mustNil(t, g.UpdateUser("alice", func(set priv.PrivilegeSet) {
+		set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.InsertPrivilege)
+	}))
+	mustNil(t, g.GrantRole("ops", "alice", false))
+	mustNil(t, g.CreateUser("bob"))
+	mustNil(t, g.UpdateUser("bob", func(set priv.PrivilegeSet) {
+		set.(*priv.PrivilegeTree).AddWhere(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege, mustParseExpr(t, `host = 'a'`))
+	}))
+
+	var tests = []struct {
+		s   string
+		exp priv.Grants
+	}{
+		{
+			s: `SHOW GRANTS FOR alice`,
+			exp: priv.Grants{
+				{Resource: "mydb", Privileges: "SELECT"},
+				{Resource: "mydb.autogen.cpu", Privileges: "INSERT, SELECT"},
+			},
+		},
+		{
+			s:   `SHOW GRANTS FOR ROLE ops`,
+			exp: priv.Grants{{Resource: "mydb", Privileges: "SELECT"}},
+		},
+		{
+			s:   `SHOW GRANTS FOR bob`,
+			exp: priv.Grants{{Resource: "mydb.autogen.cpu", Privileges: "SELECT WHERE host = 'a'"}},
+		},
+	}
+	for _, test := range tests {
+		stmt, err := priv.ParseStatement(test.s)
+		mustNil(t, err)
+		grants, err := g.ShowGrants(stmt.(*priv.ShowGrantsStatement))
+		mustNil(t, err)
+		if !reflect.DeepEqual(grants, test.exp) {
+			t.Fatalf("%s got %v expect %v", test.s, grants, test.exp)
+		}
+	}
+
+	if _, err := g.ShowGrants(&priv.ShowGrantsStatement{Name: "carol"}); !errors.Is(err, priv.ErrUserNotFound) {
+		t.Fatalf("show grants for unknown user got error '%v'", err)
+	}
+}
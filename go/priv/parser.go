@@ -2,6 +2,7 @@ package priv
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -58,10 +59,160 @@ type (
 	ErrorValue string
 )
 
+// TokenType returns the token type of the value.
+func (v Identifier) TokenType() Token { return IDENT }
+
+// Value returns the literal of the value.
+func (v Identifier) Value() string { return string(v) }
+
+// TokenType returns the token type of the value.
+func (v StringValue) TokenType() Token { return STRING }
+
+// Value returns the literal of the value.
+func (v StringValue) Value() string { return string(v) }
+
+// TokenType returns the token type of the value.
+func (v RegexValue) TokenType() Token { return REGEX }
+
+// Value returns the literal of the value.
+func (v RegexValue) Value() string { return string(v) }
+
+// TokenType returns the token type of the value.
+func (v NumberValue) TokenType() Token { return NUMBER }
+
+// Value returns the literal of the value.
+func (v NumberValue) Value() string { return strconv.FormatFloat(float64(v), 'f', -1, 64) }
+
+// TokenType returns the token type of the value.
+func (v IntegerValue) TokenType() Token { return INTEGER }
+
+// Value returns the literal of the value.
+func (v IntegerValue) Value() string { return strconv.FormatInt(int64(v), 10) }
+
+// TokenType returns the token type of the value.
+func (v BooleanValue) TokenType() Token {
+	if v {
+		return TRUE
+	}
+	return FALSE
+}
+
+// Value returns the literal of the value.
+func (v BooleanValue) Value() string { return strconv.FormatBool(bool(v)) }
+
+// TokenType returns the token type of the value.
+func (v DurationValue) TokenType() Token { return DURATIONVAL }
+
+// Value returns the literal of the value.
+func (v DurationValue) Value() string { return string(v) }
+
+// TokenType returns BOUNDPARAM, the parser reports the value as an error.
+func (v ErrorValue) TokenType() Token { return BOUNDPARAM }
+
+// Value returns the error message.
+func (v ErrorValue) Value() string { return string(v) }
+
+// BindValue converts a value decoded from JSON to a Value that can be bound
+// to a parameter. Strings, numbers and booleans are bound as literals of the
+// same type, other types can be given by an object with a single key naming
+// the type, e.g. {"identifier": "alice"} or {"duration": "2h"}, and the
+// supported types are identifier, string, regex, number, integer, boolean
+// and duration. An ErrorValue is returned if the value can not be bound.
+func BindValue(v interface{}) Value {
+	switch v := v.(type) {
+	case string:
+		return StringValue(v)
+	case bool:
+		return BooleanValue(v)
+	case float64:
+		return NumberValue(v)
+	case int:
+		return IntegerValue(v)
+	case int64:
+		return IntegerValue(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return IntegerValue(i)
+		}
+		if f, err := v.Float64(); err == nil {
+			return NumberValue(f)
+		}
+		return ErrorValue(fmt.Sprintf("invalid number %s", v))
+	case time.Duration:
+		return DurationValue(FormatDuration(v))
+	case map[string]interface{}:
+		return bindObjectValue(v)
+	}
+	return ErrorValue(fmt.Sprintf("unable to bind parameter with type %T", v))
+}
+
+// bindObjectValue converts an object with a single key naming the type.
+func bindObjectValue(m map[string]interface{}) Value {
+	if len(m) != 1 {
+		return ErrorValue("bound parameter object must have exactly one key")
+	}
+
+	for k, v := range m {
+		value := BindValue(v)
+		switch k {
+		case "identifier", "ident":
+			if s, ok := value.(StringValue); ok {
+				return Identifier(s)
+			}
+		case "string":
+			if s, ok := value.(StringValue); ok {
+				return s
+			}
+		case "regex":
+			if s, ok := value.(StringValue); ok {
+				if _, err := regexp.Compile(string(s)); err != nil {
+					return ErrorValue(err.Error())
+				}
+				return RegexValue(s)
+			}
+		case "duration":
+			if s, ok := value.(StringValue); ok {
+				if _, err := ParseDuration(string(s)); err != nil {
+					return ErrorValue(fmt.Sprintf("invalid duration %s", s))
+				}
+				return DurationValue(s)
+			}
+		case "number":
+			switch value := value.(type) {
+			case NumberValue:
+				return value
+			case IntegerValue:
+				return NumberValue(value)
+			}
+		case "integer":
+			switch value := value.(type) {
+			case IntegerValue:
+				return value
+			case NumberValue:
+				if float64(value) == float64(int64(value)) {
+					return IntegerValue(value)
+				}
+			}
+		case "boolean":
+			if b, ok := value.(BooleanValue); ok {
+				return b
+			}
+		default:
+			return ErrorValue(fmt.Sprintf("unknown bound parameter type %s", k))
+		}
+		return ErrorValue(fmt.Sprintf("unable to bind %v as %s", v, k))
+	}
+	return nil
+}
+
 // Parser represents an fql parser.
 type Parser struct {
 	s      *bufScanner
 	params map[string]Value
+
+	// error of a bound parameter, which is reported instead of errors
+	// caused by it
+	paramErr *ParseError
 }
 
 // NewParser returns a new instance of Parser.
@@ -69,6 +220,22 @@ func NewParser(r io.Reader) *Parser {
 	return &Parser{s: newBufScanner(r)}
 }
 
+// NewParserWithParams returns a new instance of Parser with parameters bound.
+func NewParserWithParams(r io.Reader, params map[string]interface{}) *Parser {
+	p := NewParser(r)
+	p.SetParams(params)
+	return p
+}
+
+// SetParams sets parameters used to replace bound parameters like $name,
+// values are converted by BindValue.
+func (p *Parser) SetParams(params map[string]interface{}) {
+	p.params = make(map[string]Value, len(params))
+	for name, param := range params {
+		p.params[name] = BindValue(param)
+	}
+}
+
 // peekRune returns the next rune that would be read by the scanner.
 func (p *Parser) peekRune() rune {
 	r, _, _ := p.s.s.r.ReadRune()
@@ -79,6 +246,630 @@ func (p *Parser) peekRune() rune {
 	return r
 }
 
+// ParseQuery parses a query string of semicolon separated statements and
+// returns its AST representation.
+func ParseQuery(s string) (*Query, error) {
+	return NewParser(strings.NewReader(s)).ParseQuery()
+}
+
+// ParseQuery parses an fql string of semicolon separated statements and
+// returns a Query AST object. Empty statements are ignored.
+func (p *Parser) ParseQuery() (*Query, error) {
+	query := &Query{}
+	for {
+		tok, start, _ := p.ScanIgnoreWhitespace()
+		if tok == EOF {
+			return query, nil
+		} else if tok == SEMICOLON {
+			continue
+		}
+		p.Unscan()
+
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, p.error(err)
+		}
+
+		tok, end, lit := p.ScanIgnoreWhitespace()
+		if tok != SEMICOLON && tok != EOF {
+			return nil, p.error(newParseError(tokstr(tok, lit), []string{";"}, end))
+		}
+		query.Statements = append(query.Statements, stmt)
+		query.Positions = append(query.Positions, StatementPos{Start: start, End: end})
+		if tok == EOF {
+			return query, nil
+		}
+	}
+}
+
+// ParseStatement parses a statement string and returns its AST representation.
+func ParseStatement(s string) (Statement, error) {
+	return NewParser(strings.NewReader(s)).ParseStatement()
+}
+
+// ParseStatement parses an fql string and returns a Statement AST object.
+// Only a single statement optionally followed by a semicolon is accepted.
+func (p *Parser) ParseStatement() (Statement, error) {
+	stmt, err := p.parseStatement()
+	if err != nil {
+		return nil, p.error(err)
+	}
+
+	if tok, _, _ := p.ScanIgnoreWhitespace(); tok != SEMICOLON {
+		p.Unscan()
+	}
+	if tok, pos, lit := p.ScanIgnoreWhitespace(); tok != EOF {
+		return nil, p.error(newParseError(tokstr(tok, lit), []string{"EOF"}, pos))
+	}
+	return stmt, nil
+}
+
+// error returns error of a bound parameter if any, which is the cause of err.
+func (p *Parser) error(err error) error {
+	if p.paramErr != nil {
+		return p.paramErr
+	}
+	return err
+}
+
+// parseStatement parses the next statement without consuming what follows it.
+func (p *Parser) parseStatement() (Statement, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	switch tok {
+	case GRANT:
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok == ROLE {
+			return p.parseGrantRoleStatement()
+		}
+		p.Unscan()
+		return p.parseGrantStatement()
+	case REVOKE:
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok == ROLE {
+			return p.parseRevokeRoleStatement()
+		}
+		p.Unscan()
+		return p.parseRevokeStatement()
+	case CREATE:
+		return p.parseCreateStatement()
+	case DROP:
+		return p.parseDropStatement()
+	case ALTER:
+		return p.parseAlterStatement()
+	case SELECT:
+		return p.parseSelectStatement()
+	case INSERT:
+		return p.parseInsertStatement()
+	case DELETE:
+		return p.parseDeleteStatement()
+	case SHOW:
+		return p.parseShowStatement()
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"SELECT", "INSERT", "DELETE", "SHOW",
+		"CREATE", "DROP", "ALTER", "GRANT", "REVOKE"}, pos)
+}
+
+// parseSelectStatement parses a select string and returns a Statement AST object.
+// Only field names or * are supported in field list.
+// This function assumes the SELECT token has already been consumed.
+func (p *Parser) parseSelectStatement() (*SelectStatement, error) {
+	stmt := &SelectStatement{}
+
+	if tok, _, _ := p.ScanIgnoreWhitespace(); tok == MUL {
+		stmt.Fields = []string{"*"}
+	} else {
+		p.Unscan()
+		for {
+			field, err := p.ParseIdent()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Fields = append(stmt.Fields, field)
+
+			if tok, _, _ := p.ScanIgnoreWhitespace(); tok != COMMA {
+				p.Unscan()
+				break
+			}
+		}
+	}
+
+	if err := p.parseTokens([]Token{FROM}); err != nil {
+		return nil, err
+	}
+
+	for {
+		m, err := p.parseMeasurement()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Sources = append(stmt.Sources, m)
+
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok != COMMA {
+			p.Unscan()
+			break
+		}
+	}
+	return stmt, nil
+}
+
+// parseInsertStatement parses a string and returns an insert statement.
+// This function assumes the INSERT token has already been consumed.
+func (p *Parser) parseInsertStatement() (*InsertStatement, error) {
+	if err := p.parseTokens([]Token{INTO}); err != nil {
+		return nil, err
+	}
+
+	m, err := p.parseMeasurement()
+	if err != nil {
+		return nil, err
+	}
+	return &InsertStatement{Into: m}, nil
+}
+
+// parseDeleteStatement parses a string and returns a delete statement.
+// This function assumes the DELETE token has already been consumed.
+func (p *Parser) parseDeleteStatement() (*DeleteStatement, error) {
+	if err := p.parseTokens([]Token{FROM}); err != nil {
+		return nil, err
+	}
+
+	m, err := p.parseMeasurement()
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteStatement{Source: m}, nil
+}
+
+// parseShowStatement parses a string and returns a show statement.
+// This function assumes the SHOW token has already been consumed.
+func (p *Parser) parseShowStatement() (Statement, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	switch tok {
+	case USERS:
+		return &ShowUsersStatement{}, nil
+	case ROLES:
+		return &ShowRolesStatement{}, nil
+	case DATABASES:
+		return &ShowDatabasesStatement{}, nil
+	case CONTINUOUS:
+		if err := p.parseTokens([]Token{QUERIES}); err != nil {
+			return nil, err
+		}
+		return &ShowContinuousQueriesStatement{}, nil
+	case GRANTS:
+		return p.parseShowGrantsStatement()
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"USERS", "ROLES", "DATABASES", "CONTINUOUS", "GRANTS"}, pos)
+}
+
+// parseShowGrantsStatement parses a string and returns a show grants statement.
+// This function assumes the SHOW GRANTS tokens have already been consumed.
+func (p *Parser) parseShowGrantsStatement() (*ShowGrantsStatement, error) {
+	if err := p.parseTokens([]Token{FOR}); err != nil {
+		return nil, err
+	}
+
+	stmt := &ShowGrantsStatement{}
+	var err error
+	if stmt.Name, stmt.Role, err = p.parsePrincipal(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseCreateStatement parses a string and returns a create statement.
+// This function assumes the CREATE token has already been consumed.
+func (p *Parser) parseCreateStatement() (Statement, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	switch tok {
+	case DATABASE:
+		name, err := p.ParseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &CreateDatabaseStatement{Name: name}, nil
+	case USER:
+		return p.parseCreateUserStatement()
+	case ROLE:
+		name, err := p.ParseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &CreateRoleStatement{Name: name}, nil
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"DATABASE", "USER", "ROLE"}, pos)
+}
+
+// parseDropStatement parses a string and returns a drop statement.
+// This function assumes the DROP token has already been consumed.
+func (p *Parser) parseDropStatement() (Statement, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	switch tok {
+	case DATABASE:
+		name, err := p.ParseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &DropDatabaseStatement{Name: name}, nil
+	case MEASUREMENT:
+		m, err := p.parseMeasurement()
+		if err != nil {
+			return nil, err
+		}
+		return &DropMeasurementStatement{Measurement: m}, nil
+	case USER:
+		name, err := p.ParseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &DropUserStatement{Name: name}, nil
+	case ROLE:
+		name, err := p.ParseIdent()
+		if err != nil {
+			return nil, err
+		}
+		return &DropRoleStatement{Name: name}, nil
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"DATABASE", "MEASUREMENT", "USER", "ROLE"}, pos)
+}
+
+// parseAlterStatement parses a string and returns an alter statement.
+// This function assumes the ALTER token has already been consumed.
+func (p *Parser) parseAlterStatement() (Statement, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	if tok == USER {
+		return p.parseAlterUserStatement()
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"USER"}, pos)
+}
+
+// parseCreateUserStatement parses a string and returns a create user statement.
+// This function assumes the CREATE USER tokens have already been consumed.
+func (p *Parser) parseCreateUserStatement() (*CreateUserStatement, error) {
+	stmt := &CreateUserStatement{}
+
+	var err error
+	if stmt.Name, err = p.ParseIdent(); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseTokens([]Token{WITH, PASSWORD}); err != nil {
+		return nil, err
+	}
+
+	if stmt.Password, err = p.parseString(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseAlterUserStatement parses a string and returns an alter user statement.
+// At least one of WITH PASSWORD 'password', ACCOUNT LOCK|UNLOCK and
+// BINDHOST 'host'[, 'host'...] clauses is expected, each may appear only once.
+// Hosts are addresses or CIDR networks, an invalid one is reported as a
+// *ParseError wrapping ErrInvalidIP.
+// This function assumes the ALTER USER tokens have already been consumed.
+func (p *Parser) parseAlterUserStatement() (*AlterUserStatement, error) {
+	stmt := &AlterUserStatement{}
+
+	var err error
+	if stmt.Name, err = p.ParseIdent(); err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, pos, lit := p.ScanIgnoreWhitespace()
+		if tok == WITH && stmt.Password == nil {
+			if err := p.parseTokens([]Token{PASSWORD}); err != nil {
+				return nil, err
+			}
+			password, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Password = &password
+		} else if tok == ACCOUNT && stmt.Locked == nil {
+			tok, pos, lit := p.ScanIgnoreWhitespace()
+			if tok != LOCK && tok != UNLOCK {
+				return nil, newParseError(tokstr(tok, lit), []string{"LOCK", "UNLOCK"}, pos)
+			}
+			locked := tok == LOCK
+			stmt.Locked = &locked
+		} else if tok == BINDHOST && stmt.BindHosts == nil {
+			if stmt.BindHosts, err = p.parseBindHosts(); err != nil {
+				return nil, err
+			}
+		} else if stmt.Password == nil && stmt.Locked == nil && stmt.BindHosts == nil {
+			return nil, newParseError(tokstr(tok, lit), []string{"WITH", "ACCOUNT", "BINDHOST"}, pos)
+		} else {
+			p.Unscan()
+			break
+		}
+	}
+	return stmt, nil
+}
+
+// parseBindHosts parses a comma separated list of hosts.
+func (p *Parser) parseBindHosts() ([]string, error) {
+	var hosts []string
+	for {
+		tok, pos, lit := p.ScanIgnoreWhitespace()
+		if tok != STRING {
+			return nil, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+		}
+		if _, err := ParseBindHost(lit); err != nil {
+			return nil, &ParseError{Message: err.Error(), Err: err, Pos: pos}
+		}
+		hosts = append(hosts, lit)
+
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok != COMMA {
+			p.Unscan()
+			return hosts, nil
+		}
+	}
+}
+
+// parseGrantRoleStatement parses a string and returns a grant role statement.
+// This function assumes the GRANT ROLE tokens have already been consumed.
+func (p *Parser) parseGrantRoleStatement() (*GrantRoleStatement, error) {
+	stmt := &GrantRoleStatement{}
+
+	var err error
+	if stmt.Role, err = p.ParseIdent(); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseTokens([]Token{TO}); err != nil {
+		return nil, err
+	}
+
+	if stmt.Name, stmt.ToRole, err = p.parsePrincipal(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseRevokeRoleStatement parses a string and returns a revoke role statement.
+// This function assumes the REVOKE ROLE tokens have already been consumed.
+func (p *Parser) parseRevokeRoleStatement() (*RevokeRoleStatement, error) {
+	stmt := &RevokeRoleStatement{}
+
+	var err error
+	if stmt.Role, err = p.ParseIdent(); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseTokens([]Token{FROM}); err != nil {
+		return nil, err
+	}
+
+	if stmt.Name, stmt.FromRole, err = p.parsePrincipal(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseGrantStatement parses a string and returns a grant statement.
+// This function assumes the GRANT token has already been consumed.
+func (p *Parser) parseGrantStatement() (*GrantStatement, error) {
+	stmt := &GrantStatement{}
+
+	var err error
+	if stmt.Privileges, stmt.On, err = p.parsePrivilegesOn(); err != nil {
+		return nil, err
+	}
+
+	// privileges on a resource may be limited to rows satisfying a condition
+	if len(stmt.On.Segs) > 0 {
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok == WHERE {
+			if stmt.Condition, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		} else {
+			p.Unscan()
+		}
+	}
+
+	if err := p.parseTokens([]Token{TO}); err != nil {
+		return nil, err
+	}
+
+	if stmt.Name, stmt.Role, err = p.parsePrincipal(); err != nil {
+		return nil, err
+	}
+
+	if stmt.Duration, stmt.Expires, err = p.parseExpiry(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parseExpiry parses an optional FOR <duration> or UNTIL <time> clause which
+// limits how long a grant lasts. The time is a string literal in DateFormat or
+// DateTimeFormat, in UTC. UNTIL is not reserved, it is only a keyword here so
+// that names like until keep working unquoted.
+func (p *Parser) parseExpiry() (time.Duration, time.Time, error) {
+	tok, _, lit := p.ScanIgnoreWhitespace()
+	if tok == IDENT && strings.EqualFold(lit, "until") {
+		tok = UNTIL
+	}
+	switch tok {
+	case FOR:
+		tok, pos, lit := p.ScanIgnoreWhitespace()
+		if tok != DURATIONVAL {
+			return 0, time.Time{}, newParseError(tokstr(tok, lit), []string{"duration"}, pos)
+		}
+
+		d, err := ParseDuration(lit)
+		if err != nil {
+			return 0, time.Time{}, &ParseError{Message: err.Error(), Pos: pos}
+		} else if d <= 0 {
+			return 0, time.Time{}, &ParseError{Message: "duration must be positive", Pos: pos}
+		}
+		return d, time.Time{}, nil
+	case UNTIL:
+		tok, pos, lit := p.ScanIgnoreWhitespace()
+		if tok != STRING {
+			return 0, time.Time{}, newParseError(tokstr(tok, lit), []string{"string"}, pos)
+		}
+
+		layout := DateTimeFormat
+		if isDateString(lit) {
+			layout = DateFormat
+		} else if !isDateTimeString(lit) {
+			return 0, time.Time{}, &ParseError{Message: fmt.Sprintf("invalid time '%s'", lit), Pos: pos}
+		}
+		t, err := time.Parse(layout, lit)
+		if err != nil {
+			return 0, time.Time{}, &ParseError{Message: fmt.Sprintf("invalid time '%s'", lit), Pos: pos}
+		}
+		return 0, t, nil
+	}
+	p.Unscan()
+	return 0, time.Time{}, nil
+}
+
+// parseRevokeStatement parses a string and returns a revoke statement.
+// This function assumes the REVOKE token has already been consumed.
+func (p *Parser) parseRevokeStatement() (*RevokeStatement, error) {
+	stmt := &RevokeStatement{}
+
+	var err error
+	if stmt.Privileges, stmt.On, err = p.parsePrivilegesOn(); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseTokens([]Token{FROM}); err != nil {
+		return nil, err
+	}
+
+	if stmt.Name, stmt.Role, err = p.parsePrincipal(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// parsePrivilegesOn parses a comma separated privilege list followed by an
+// optional ON clause, e.g. SELECT, DELETE ON "my.db".autogen.cpu
+// Without ON clause the privileges refer to global resource.
+func (p *Parser) parsePrivilegesOn() ([]Privilege, *ResourcePath, error) {
+	var privileges []Privilege
+	var positions []Pos
+	for {
+		privilege, pos, err := p.parsePrivilege()
+		if err != nil {
+			return nil, nil, err
+		}
+		privileges = append(privileges, privilege)
+		positions = append(positions, pos)
+
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok != COMMA {
+			p.Unscan()
+			break
+		}
+	}
+
+	if tok, _, _ := p.ScanIgnoreWhitespace(); tok != ON {
+		p.Unscan()
+		return privileges, NewResourcePath(), nil
+	}
+
+	resource, err := p.parseResourcePath()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// only resource privileges make sense on a resource
+	for i, privilege := range privileges {
+		if privilege == AllGlobalPrivileges {
+			privileges[i] = AllResourcePrivileges
+		} else if privilege&^AllResourcePrivileges != NoPrivilege {
+			msg := fmt.Sprintf("global privilege %s can not be applied on resource", privilege)
+			return nil, nil, &ParseError{Message: msg, Pos: positions[i]}
+		}
+	}
+
+	return privileges, resource, nil
+}
+
+// parseResourcePath parses a dot separated resource path, the measurement
+// segment may be a regex or a wildcard '*' which matches any measurement.
+func (p *Parser) parseResourcePath() (*ResourcePath, error) {
+	segs, err := p.parseSegmentedIdents()
+	if err != nil {
+		return nil, err
+	}
+	// parseSegmentedIdents stops right after a dot if a regex or wildcard follows
+	if tok, _, _ := p.s.curr(); tok != DOT {
+		return NewResourcePath(segs...), nil
+	}
+
+	var regex *regexp.Regexp
+	var pos Pos
+	if p.peekRune() == '*' {
+		_, pos, _ = p.Scan()
+		regex = regexp.MustCompile(".*")
+	} else {
+		var tok Token
+		var lit string
+		if tok, pos, lit = p.ScanRegex(); tok != REGEX {
+			return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
+		}
+		if regex, err = compileRegex(lit); err != nil {
+			return nil, &ParseError{Message: err.Error(), Pos: pos}
+		}
+	}
+
+	if len(segs) != 2 {
+		return nil, &ParseError{Message: "regex is only supported on measurement", Pos: pos}
+	}
+	return NewRegexResourcePath(regex, segs[0], segs[1]), nil
+}
+
+// parsePrivilege parses a privilege name which may consist of several words,
+// e.g. SELECT, CREATE CQ, ALL PRIVILEGES.
+func (p *Parser) parsePrivilege() (Privilege, Pos, error) {
+	var words []string
+	var start Pos
+	for {
+		tok, pos, lit := p.ScanIgnoreWhitespace()
+		if tok == ON || tok == TO || tok == FROM || tok == WHERE || (tok != IDENT && !tok.isKeyword()) {
+			p.Unscan()
+			if len(words) == 0 {
+				return NoPrivilege, pos, newParseError(tokstr(tok, lit), []string{"privilege"}, pos)
+			}
+			break
+		}
+
+		if len(words) == 0 {
+			start = pos
+		}
+		words = append(words, tokstr(tok, lit))
+	}
+
+	name := strings.Join(words, " ")
+	privilege, err := PrivilegeOf(name)
+	if err != nil {
+		msg := fmt.Sprintf("unknown privilege '%s'", name)
+		return NoPrivilege, start, &ParseError{Message: msg, Suggestion: suggestPrivilege(name), Pos: start}
+	}
+	return privilege, start, nil
+}
+
+// parsePrincipal parses a user name, or a role name if preceded by ROLE.
+func (p *Parser) parsePrincipal() (string, bool, error) {
+	role := false
+	if tok, _, _ := p.ScanIgnoreWhitespace(); tok == ROLE {
+		role = true
+	} else {
+		p.Unscan()
+	}
+
+	name, err := p.ParseIdent()
+	if err != nil {
+		return "", false, err
+	}
+	return name, role, nil
+}
+
 // ParseIdent parses an identifier.
 func (p *Parser) ParseIdent() (string, error) {
 	tok, pos, lit := p.ScanIgnoreWhitespace()
@@ -88,6 +879,15 @@ func (p *Parser) ParseIdent() (string, error) {
 	return lit, nil
 }
 
+// parseString parses a string.
+func (p *Parser) parseString() (string, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	if tok != STRING {
+		return "", newParseError(tokstr(tok, lit), []string{"string"}, pos)
+	}
+	return lit, nil
+}
+
 // parseSegmentedIdents parses a segmented identifiers.
 // e.g.,  "db"."rp".measurement  or  "db"..measurement
 func (p *Parser) parseSegmentedIdents() ([]string, error) {
@@ -105,8 +905,8 @@ func (p *Parser) parseSegmentedIdents() ([]string, error) {
 			break
 		}
 
-		if ch := p.peekRune(); ch == '/' {
-			// Next segment is a regex so we're done.
+		if ch := p.peekRune(); ch == '/' || ch == '*' {
+			// Next segment is a regex or wildcard so we're done.
 			break
 		} else if ch == ':' {
 			// Next segment is context-specific so let caller handle it.
@@ -133,6 +933,24 @@ func (p *Parser) parseSegmentedIdents() ([]string, error) {
 	return idents, nil
 }
 
+// parseMeasurement parses a measurement optionally qualified by database and
+// retention policy, e.g. cpu, autogen.cpu, mydb.autogen.cpu or mydb..cpu
+func (p *Parser) parseMeasurement() (*Measurement, error) {
+	idents, err := p.parseSegmentedIdents()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Measurement{Name: idents[len(idents)-1]}
+	switch len(idents) {
+	case 3:
+		m.Database, m.RetentionPolicy = idents[0], idents[1]
+	case 2:
+		m.RetentionPolicy = idents[0]
+	}
+	return m, nil
+}
+
 // ParseOptionalTokenAndInt parses the specified token followed
 // by an int, if it exists.
 func (p *Parser) ParseOptionalTokenAndInt(t Token) (int, error) {
@@ -203,6 +1021,176 @@ func (p *Parser) parseResample() (time.Duration, time.Duration, error) {
 	return interval, maxDuration, nil
 }
 
+// ParseExpr parses an expression string and returns its AST representation.
+func ParseExpr(s string) (Expr, error) {
+	p := NewParser(strings.NewReader(s))
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, pos, lit := p.ScanIgnoreWhitespace(); tok != EOF {
+		return nil, p.error(newParseError(tokstr(tok, lit), []string{"EOF"}, pos))
+	}
+	return expr, nil
+}
+
+// ParseExpr parses an expression. Binary operators are grouped by their
+// precedence, and operators of the same precedence are left associative,
+// e.g. a - b - c is parsed as (a - b) - c.
+func (p *Parser) ParseExpr() (Expr, error) {
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, p.error(err)
+	}
+	return expr, nil
+}
+
+func (p *Parser) parseExpr() (Expr, error) {
+	var err error
+	// Dummy root node, the expression parsed so far is its RHS.
+	root := &BinaryExpr{}
+
+	// Parse a non-binary expression type to start.
+	// This variable will always be the root of the expression tree.
+	root.RHS, err = p.parseUnaryExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	// Loop over operations and unary exprs and build a tree based on precedence.
+	for {
+		// If the next token is NOT an operator then return the expression.
+		op, _, _ := p.ScanIgnoreWhitespace()
+		if !op.isOperator() {
+			p.Unscan()
+			return root.RHS, nil
+		}
+
+		// Otherwise parse the next expression, the right side of regex
+		// operators must be a regex, and the right side of IN must be a list.
+		var rhs Expr
+		if IsRegexOp(op) {
+			rhs, err = p.parseRegex()
+		} else if op == IN {
+			rhs, err = p.parseList()
+		} else {
+			rhs, err = p.parseUnaryExpr()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Find the right spot in the tree to add the new expression by
+		// descending the RHS of the expression tree until we reach the last
+		// BinaryExpr or a BinaryExpr whose RHS has an operator with
+		// precedence >= the operator being added.
+		for node := root; ; {
+			r, ok := node.RHS.(*BinaryExpr)
+			if !ok || r.Op.Precedence() >= op.Precedence() {
+				// Add the new expression here and break.
+				node.RHS = &BinaryExpr{LHS: node.RHS, RHS: rhs, Op: op}
+				break
+			}
+			node = r
+		}
+	}
+}
+
+// parseUnaryExpr parses a non-binary expression, which is a parenthesized
+// expression, a variable reference or a literal.
+func (p *Parser) parseUnaryExpr() (Expr, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	switch tok {
+	case LPAREN:
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.parseTokens([]Token{RPAREN}); err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: expr}, nil
+	case IDENT:
+		return &VarRef{Val: lit}, nil
+	case STRING:
+		return &StringLiteral{Val: lit}, nil
+	case TRUE, FALSE:
+		return &BooleanLiteral{Val: tok == TRUE}, nil
+	case NUMBER, INTEGER, DURATIONVAL:
+		return p.parseNumericLiteral(tok, pos, lit)
+	case SUB:
+		// A minus sign directly followed by a numeric literal negates it.
+		if tok, _, lit := p.Scan(); tok == NUMBER || tok == INTEGER || tok == DURATIONVAL {
+			return p.parseNumericLiteral(tok, pos, "-"+lit)
+		}
+		p.Unscan()
+	}
+	return nil, newParseError(tokstr(tok, lit), []string{"identifier", "string", "number", "bool"}, pos)
+}
+
+// parseNumericLiteral parses literal of a number, integer or duration token.
+func (p *Parser) parseNumericLiteral(tok Token, pos Pos, lit string) (Expr, error) {
+	switch tok {
+	case NUMBER:
+		v, err := strconv.ParseFloat(lit, 64)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse number", Pos: pos}
+		}
+		return &NumberLiteral{Val: v}, nil
+	case INTEGER:
+		v, err := strconv.ParseInt(lit, 10, 64)
+		if err != nil {
+			return nil, &ParseError{Message: "unable to parse integer", Pos: pos}
+		}
+		return &IntegerLiteral{Val: v}, nil
+	default:
+		v, err := ParseDuration(lit)
+		if err != nil {
+			return nil, &ParseError{Message: err.Error(), Pos: pos}
+		}
+		return &DurationLiteral{Val: v}, nil
+	}
+}
+
+// parseList parses a parenthesized and comma separated list of expressions.
+func (p *Parser) parseList() (*ListLiteral, error) {
+	if err := p.parseTokens([]Token{LPAREN}); err != nil {
+		return nil, err
+	}
+	list := &ListLiteral{}
+	for {
+		v, err := p.parseUnaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		list.Vals = append(list.Vals, v)
+
+		if tok, pos, lit := p.ScanIgnoreWhitespace(); tok == RPAREN {
+			return list, nil
+		} else if tok != COMMA {
+			return nil, newParseError(tokstr(tok, lit), []string{",", ")"}, pos)
+		}
+	}
+}
+
+// parseRegex parses a regular expression literal.
+func (p *Parser) parseRegex() (*RegexLiteral, error) {
+	// whitespace is skipped by peeking, as scanning a token would read the
+	// regex as a division operator
+	if isWhitespace(p.peekRune()) {
+		p.Scan()
+	}
+	tok, pos, lit := p.ScanRegex()
+	if tok != REGEX {
+		return nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
+	}
+	re, err := compileRegex(lit)
+	if err != nil {
+		return nil, &ParseError{Message: err.Error(), Pos: pos}
+	}
+	return &RegexLiteral{Val: re}, nil
+}
+
 // Scan returns the next token from the underlying scanner.
 func (p *Parser) Scan() (tok Token, pos Pos, lit string) {
 	return p.scan(p.s.Scan)
@@ -225,6 +1213,13 @@ func (p *Parser) scan(fn scanFunc) (tok Token, pos Pos, lit string) {
 		k := strings.TrimPrefix(lit, "$")
 		if len(k) != 0 {
 			if v, ok := p.params[k]; ok {
+				if e, ok := v.(ErrorValue); ok {
+					if p.paramErr == nil {
+						msg := fmt.Sprintf("invalid bound parameter %s: %s", k, e)
+						p.paramErr = &ParseError{Message: msg, Pos: pos}
+					}
+					return ILLEGAL, pos, lit
+				}
 				tok, lit = v.TokenType(), v.Value()
 			}
 		}
@@ -456,17 +1451,63 @@ type ParseError struct {
 	Found    string
 	Expected []string
 	Pos      Pos
+
+	// Suggestion is the keyword or privilege name closest to what was found,
+	// empty if nothing is close enough.
+	Suggestion string
+
+	// Err is the underlying error if any, e.g. ErrInvalidIP.
+	Err error
 }
 
 // newParseError returns a new instance of ParseError.
 func newParseError(found string, expected []string, pos Pos) *ParseError {
-	return &ParseError{Found: found, Expected: expected, Pos: pos}
+	return &ParseError{Found: found, Expected: expected, Pos: pos, Suggestion: suggestKeyword(found, expected)}
 }
 
 // Error returns the string representation of the error.
 func (e *ParseError) Error() string {
-	if e.Message != "" {
-		return fmt.Sprintf("%s at line %d, char %d", e.Message, e.Pos.Line+1, e.Pos.Char+1)
+	msg := e.Message
+	if msg == "" {
+		msg = fmt.Sprintf("found %s, expected %s", e.Found, strings.Join(e.Expected, ", "))
+	}
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(" (did you mean %s?)", e.Suggestion)
+	}
+	return fmt.Sprintf("%s at line %d, char %d", msg, e.Pos.Line+1, e.Pos.Char+1)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Caret returns the line of the query where the error occurred, followed by
+// a line with a caret under the offending character, e.g.
+//
+//	GRNAT SELECT ON mydb TO alice
+//	^
+func (e *ParseError) Caret(query string) string {
+	lines := strings.Split(query, "\n")
+	if e.Pos.Line < 0 || e.Pos.Line >= len(lines) {
+		return ""
+	}
+	line := strings.TrimSuffix(lines[e.Pos.Line], "\r")
+
+	// keep tabs so that the caret is aligned however tabs are displayed
+	var indent strings.Builder
+	for i, r := range []rune(line) {
+		if i >= e.Pos.Char {
+			break
+		}
+		if r == '\t' {
+			indent.WriteRune('\t')
+		} else {
+			indent.WriteRune(' ')
+		}
+	}
+	for i := len([]rune(line)); i < e.Pos.Char; i++ {
+		indent.WriteRune(' ')
 	}
-	return fmt.Sprintf("found %s, expected %s at line %d, char %d", e.Found, strings.Join(e.Expected, ", "), e.Pos.Line+1, e.Pos.Char+1)
+	return line + "\n" + indent.String() + "^"
 }
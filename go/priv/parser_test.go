@@ -0,0 +1,830 @@
+package priv_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestParseStatement(t *testing.T) {
+	var tests = []struct {
+		s    string
+		stmt priv.Statement
+		err  string
+	}{
+		{
+			s: `GRANT SELECT, DELETE ON "my.db".autogen.cpu TO alice`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege, priv.DeletePrivilege},
+				On:         priv.NewResourcePath("my.db", "autogen", "cpu"),
+				Name:       "alice",
+			},
+		},
+		{
+			s: `grant create cq on mydb..cpu to role ops;`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.CreateCQPrivilege},
+				On:         priv.NewResourcePath("mydb", "autogen", "cpu"),
+				Name:       "ops",
+				Role:       true,
+			},
+		},
+		{
+			s: `GRANT SELECT ON mydb.autogen./^cpu_\/.*/ TO bob`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege},
+				On:         priv.NewRegexResourcePath(regexp.MustCompile(`^cpu_/.*`), "mydb", "autogen"),
+				Name:       "bob",
+			},
+		},
+		{
+			s: `REVOKE INSERT ON mydb..* FROM bob`,
+			stmt: &priv.RevokeStatement{
+				Privileges: []priv.Privilege{priv.InsertPrivilege},
+				On:         priv.NewRegexResourcePath(regexp.MustCompile(`.*`), "mydb", "autogen"),
+				Name:       "bob",
+			},
+		},
+		{
+			s: `GRANT ALL PRIVILEGES ON mydb TO alice`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.AllResourcePrivileges},
+				On:         priv.NewResourcePath("mydb"),
+				Name:       "alice",
+			},
+		},
+		{
+			s: `GRANT ALL TO admin`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.AllGlobalPrivileges},
+				On:         priv.NewResourcePath(),
+				Name:       "admin",
+			},
+		},
+		{
+			s: `GRANT CREATE USER, SHOW USERS, AUDIT TO "bob smith"`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.CreateUserPrivilege, priv.ShowUsersPrivilege, priv.AuditPrivilege},
+				On:         priv.NewResourcePath(),
+				Name:       "bob smith",
+			},
+		},
+		{
+			s: `REVOKE INSERT ON mydb.autogen FROM ROLE writers`,
+			stmt: &priv.RevokeStatement{
+				Privileges: []priv.Privilege{priv.InsertPrivilege},
+				On:         priv.NewResourcePath("mydb", "autogen"),
+				Name:       "writers",
+				Role:       true,
+			},
+		},
+		{
+			s: `REVOKE SHOW CQS FROM alice`,
+			stmt: &priv.RevokeStatement{
+				Privileges: []priv.Privilege{priv.ShowCQSPrivilege},
+				On:         priv.NewResourcePath(),
+				Name:       "alice",
+			},
+		},
+		{
+			s: `GRANT SELECT ON mydb TO alice FOR 2h`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege},
+				On:         priv.NewResourcePath("mydb"),
+				Name:       "alice",
+				Duration:   2 * time.Hour,
+			},
+		},
+		{
+			s: `GRANT AUDIT TO ROLE oncall UNTIL '2026-11-01'`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.AuditPrivilege},
+				On:         priv.NewResourcePath(),
+				Name:       "oncall",
+				Role:       true,
+				Expires:    time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			s: `GRANT INSERT ON mydb.autogen TO bob UNTIL '2026-11-01 08:30:00.5'`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.InsertPrivilege},
+				On:         priv.NewResourcePath("mydb", "autogen"),
+				Name:       "bob",
+				Expires:    time.Date(2026, 11, 1, 8, 30, 0, 500000000, time.UTC),
+			},
+		},
+		{
+			// UNTIL is only a keyword after the principal
+			s: `GRANT SELECT ON until.autogen.until TO until until '2026-11-01'`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege},
+				On:         priv.NewResourcePath("until", "autogen", "until"),
+				Name:       "until",
+				Expires:    time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			s: `GRANT SELECT ON mydb.autogen.cpu WHERE host =~ /^web/ TO ops FOR 1h`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege},
+				On:         priv.NewResourcePath("mydb", "autogen", "cpu"),
+				Condition: &priv.BinaryExpr{Op: priv.EQREGEX, LHS: &priv.VarRef{Val: "host"},
+					RHS: &priv.RegexLiteral{Val: regexp.MustCompile(`^web`)}},
+				Name:     "ops",
+				Duration: time.Hour,
+			},
+		},
+		{
+			s:    `GRANT ROLE ops TO alice`,
+			stmt: &priv.GrantRoleStatement{Role: "ops", Name: "alice"},
+		},
+		{
+			s:    `GRANT ROLE ops TO ROLE admins`,
+			stmt: &priv.GrantRoleStatement{Role: "ops", Name: "admins", ToRole: true},
+		},
+		{
+			s:    `REVOKE ROLE ops FROM alice`,
+			stmt: &priv.RevokeRoleStatement{Role: "ops", Name: "alice"},
+		},
+		{
+			s:    `REVOKE ROLE ops FROM ROLE admins`,
+			stmt: &priv.RevokeRoleStatement{Role: "ops", Name: "admins", FromRole: true},
+		},
+		{
+			s:    `CREATE USER alice WITH PASSWORD 'it\'s secret'`,
+			stmt: &priv.CreateUserStatement{Name: "alice", Password: "it's secret"},
+		},
+		{
+			s:    `DROP USER alice`,
+			stmt: &priv.DropUserStatement{Name: "alice"},
+		},
+		{
+			s:    `CREATE ROLE ops`,
+			stmt: &priv.CreateRoleStatement{Name: "ops"},
+		},
+		{
+			s:    `DROP ROLE "ops team"`,
+			stmt: &priv.DropRoleStatement{Name: "ops team"},
+		},
+		{
+			s:    `ALTER USER alice ACCOUNT LOCK`,
+			stmt: &priv.AlterUserStatement{Name: "alice", Locked: boolPtr(true)},
+		},
+		{
+			s:    `ALTER USER alice ACCOUNT UNLOCK WITH PASSWORD 'secret'`,
+			stmt: &priv.AlterUserStatement{Name: "alice", Locked: boolPtr(false), Password: stringPtr("secret")},
+		},
+		{
+			s:    `ALTER USER svc BINDHOST '10.0.0.0/8', '192.168.1.5' ACCOUNT LOCK`,
+			stmt: &priv.AlterUserStatement{Name: "svc", Locked: boolPtr(true), BindHosts: []string{"10.0.0.0/8", "192.168.1.5"}},
+		},
+		{
+			s:    `ALTER USER svc BINDHOST 'fd00::/8'`,
+			stmt: &priv.AlterUserStatement{Name: "svc", BindHosts: []string{"fd00::/8"}},
+		},
+		{
+			s: `SELECT * FROM cpu, autogen.mem, mydb..disk, "my.db".daily."net io"`,
+			stmt: &priv.SelectStatement{
+				Fields: []string{"*"},
+				Sources: []*priv.Measurement{
+					{Name: "cpu"},
+					{RetentionPolicy: "autogen", Name: "mem"},
+					{Database: "mydb", Name: "disk"},
+					{Database: "my.db", RetentionPolicy: "daily", Name: "net io"},
+				},
+			},
+		},
+		{
+			s: `SELECT usage, idle FROM cpu`,
+			stmt: &priv.SelectStatement{
+				Fields:  []string{"usage", "idle"},
+				Sources: []*priv.Measurement{{Name: "cpu"}},
+			},
+		},
+		{
+			s:    `INSERT INTO mydb.autogen.cpu`,
+			stmt: &priv.InsertStatement{Into: &priv.Measurement{Database: "mydb", RetentionPolicy: "autogen", Name: "cpu"}},
+		},
+		{
+			s:    `DELETE FROM cpu`,
+			stmt: &priv.DeleteStatement{Source: &priv.Measurement{Name: "cpu"}},
+		},
+		{
+			s:    `DROP MEASUREMENT daily.cpu`,
+			stmt: &priv.DropMeasurementStatement{Measurement: &priv.Measurement{RetentionPolicy: "daily", Name: "cpu"}},
+		},
+		{
+			s:    `DROP DATABASE mydb`,
+			stmt: &priv.DropDatabaseStatement{Name: "mydb"},
+		},
+		{
+			s:    `CREATE DATABASE mydb`,
+			stmt: &priv.CreateDatabaseStatement{Name: "mydb"},
+		},
+		{s: `SHOW USERS`, stmt: &priv.ShowUsersStatement{}},
+		{s: `SHOW ROLES`, stmt: &priv.ShowRolesStatement{}},
+		{s: `SHOW DATABASES`, stmt: &priv.ShowDatabasesStatement{}},
+		{s: `SHOW CONTINUOUS QUERIES`, stmt: &priv.ShowContinuousQueriesStatement{}},
+		{s: `SHOW GRANTS FOR alice`, stmt: &priv.ShowGrantsStatement{Name: "alice"}},
+		{s: `SHOW GRANTS FOR ROLE "ops team"`, stmt: &priv.ShowGrantsStatement{Name: "ops team", Role: true}},
+		{s: `KILL QUERY 1`, err: `found KILL, expected SELECT, INSERT, DELETE, SHOW, CREATE, DROP, ALTER, GRANT, REVOKE at line 1, char 1`},
+		{s: `SELECT FROM cpu`, err: `found FROM, expected identifier at line 1, char 8`},
+		{s: `SELECT * cpu`, err: `found cpu, expected FROM at line 1, char 10`},
+		{s: `SELECT * FROM a.b.c.d`, err: `too many segments in "a"."b"."c".d at line 1, char 1`},
+		{s: `INSERT cpu`, err: `found cpu, expected INTO at line 1, char 8`},
+		{s: `SHOW CONTINUOUS`, err: `found EOF, expected QUERIES at line 1, char 17`},
+		{s: `SHOW SERIES`, err: `found SERIES, expected USERS, ROLES, DATABASES, CONTINUOUS, GRANTS at line 1, char 6`},
+		{s: `SHOW GRANTS alice`, err: `found alice, expected FOR at line 1, char 13`},
+		{s: `SHOW GRANTS FOR`, err: `found EOF, expected identifier at line 1, char 17`},
+		{s: `CREATE MEASUREMENT cpu`, err: `found MEASUREMENT, expected DATABASE, USER, ROLE at line 1, char 8`},
+		{s: `CREATE USER alice`, err: `found EOF, expected WITH at line 1, char 19`},
+		{s: `CREATE USER alice WITH PASSWORD secret`, err: `found secret, expected string at line 1, char 33`},
+		{s: `ALTER USER alice`, err: `found EOF, expected WITH, ACCOUNT, BINDHOST at line 1, char 18`},
+		{s: `ALTER USER alice BINDHOST`, err: `found EOF, expected string at line 1, char 27`},
+		{s: `ALTER USER alice BINDHOST '10.0.0.1',`, err: `found EOF, expected string at line 1, char 38`},
+		{s: `ALTER USER alice BINDHOST '10.0.0.0/33'`, err: `IP address invalid: '10.0.0.0/33' at line 1, char 26`},
+		{s: `ALTER USER alice BINDHOST '10.0.0.1' BINDHOST '::1'`, err: `found BINDHOST, expected EOF at line 1, char 38`},
+		{s: `ALTER USER alice ACCOUNT ENABLE`, err: `found ENABLE, expected LOCK, UNLOCK at line 1, char 26`},
+		{s: `ALTER USER alice ACCOUNT LOCK ACCOUNT UNLOCK`, err: `found ACCOUNT, expected EOF at line 1, char 31`},
+		{s: `ALTER ROLE ops ACCOUNT LOCK`, err: `found ROLE, expected USER at line 1, char 7`},
+		{s: `GRANT ROLE ops alice`, err: `found alice, expected TO at line 1, char 16`},
+		{s: `GRANT ON mydb TO alice`, err: `found ON, expected privilege at line 1, char 7`},
+		{s: `GRANT SELECT, ON mydb TO alice`, err: `found ON, expected privilege at line 1, char 15`},
+		{s: `GRANT SELCT ON mydb TO alice`, err: `unknown privilege 'SELCT' (did you mean SELECT?) at line 1, char 7`},
+		{s: `GRANT SELECT ON mydb./cpu/ TO alice`, err: `regex is only supported on measurement at line 1, char 21`},
+		{s: `GRANT SELECT ON mydb.autogen./(/ TO alice`, err: "error parsing regexp: missing closing ): `(` at line 1, char 29"},
+		{s: `GRANT SELECT ON mydb.autogen./cpu TO alice`, err: `found BADREGEX, expected regex at line 1, char 29`},
+		{s: `GRANT SELECT ON mydb alice`, err: `found alice, expected TO at line 1, char 22`},
+		{s: `GRANT SELECT ON mydb TO`, err: `found EOF, expected identifier at line 1, char 25`},
+		{s: `GRANT SHOW USERS ON mydb TO alice`, err: `global privilege SHOW USERS can not be applied on resource at line 1, char 7`},
+		{s: `GRANT SELECT ON mydb TO alice bob`, err: `found bob, expected EOF at line 1, char 31`},
+		{s: `REVOKE SELECT ON mydb TO alice`, err: `found TO, expected FROM at line 1, char 23`},
+		{s: `GRANT SELECT ON mydb TO alice FOR`, err: `found EOF, expected duration at line 1, char 35`},
+		{s: `GRANT SELECT ON mydb TO alice FOR 0s`, err: `duration must be positive at line 1, char 35`},
+		{s: `GRANT SELECT ON mydb TO alice UNTIL 2h`, err: `found 2h, expected string at line 1, char 37`},
+		{s: `GRANT SELECT ON mydb TO alice UNTIL 'tomorrow'`, err: `invalid time 'tomorrow' at line 1, char 36`},
+		{s: `GRANT SELECT ON mydb TO alice UNTIL '2026-13-01'`, err: `invalid time '2026-13-01' at line 1, char 36`},
+		{s: `GRANT SELECT ON mydb TO alice FOR 2h UNTIL '2026-11-01'`, err: `found UNTIL, expected EOF at line 1, char 38`},
+		{s: `REVOKE SELECT ON mydb FROM alice FOR 2h`, err: `found FOR, expected EOF at line 1, char 34`},
+		{s: `GRANT SELECT ON mydb WHERE TO alice`, err: `found TO, expected identifier, string, number, bool at line 1, char 28`},
+		{s: `GRANT SELECT WHERE host = 'a' TO alice`, err: `found WHERE, expected TO at line 1, char 14`},
+		{s: `REVOKE SELECT ON mydb WHERE host = 'a' FROM alice`, err: `found WHERE, expected FROM at line 1, char 23`},
+	}
+
+	for _, test := range tests {
+		stmt, err := priv.ParseStatement(test.s)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("parse %s got error '%v' expect error '%s'", test.s, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parse %s got unexpected error '%v'", test.s, err)
+		}
+		if !reflect.DeepEqual(stmt, test.stmt) {
+			t.Fatalf("parse %s got %#v expect %#v", test.s, stmt, test.stmt)
+		}
+		assertRoundTrip(t, stmt)
+	}
+}
+
+// assertRoundTrip checks parsing string representation of a statement
+// yields the same statement.
+func assertRoundTrip(t *testing.T, stmt priv.Statement) {
+	t.Helper()
+	again, err := priv.ParseStatement(stmt.String())
+	if err != nil {
+		t.Fatalf("parse %s got unexpected error '%v'", stmt, err)
+	}
+	if !reflect.DeepEqual(again, stmt) {
+		t.Fatalf("parse %s got %#v expect %#v", stmt, again, stmt)
+	}
+}
+
+func TestStatementString(t *testing.T) {
+	var tests = []struct {
+		s   string
+		exp string
+	}{
+		{s: `grant select,delete on "my.db".autogen.cpu to alice`, exp: `GRANT SELECT, DELETE ON "my.db".autogen.cpu TO alice`},
+		{s: `grant create cq on mydb..cpu to role "ops team"`, exp: `GRANT CREATE CQ ON mydb.autogen.cpu TO ROLE "ops team"`},
+		{s: `GRANT SELECT ON mydb.autogen./^cpu_\/.*/ TO bob FOR 120m`, exp: `GRANT SELECT ON mydb.autogen./^cpu_\/.*/ TO bob FOR 2h`},
+		{s: `GRANT SELECT ON mydb..* TO bob UNTIL '2026-11-01 00:00:00'`, exp: `GRANT SELECT ON mydb.autogen./.*/ TO bob UNTIL '2026-11-01'`},
+		{s: `GRANT AUDIT TO bob UNTIL '2026-11-01 08:30:00.5'`, exp: `GRANT AUDIT TO bob UNTIL '2026-11-01 08:30:00.5'`},
+		{s: `grant select on until to until until '2026-11-01'`, exp: `GRANT SELECT ON until TO until UNTIL '2026-11-01'`},
+		{s: `GRANT ALL TO admin`, exp: `GRANT ALL PRIVILEGES TO admin`},
+		{s: `grant all on mydb to "user"`, exp: `GRANT ALL PRIVILEGES ON mydb TO "user"`},
+		{s: `revoke read, write on mydb from role readers`, exp: `REVOKE READ, WRITE ON mydb FROM ROLE readers`},
+		{s: `grant role ops to role admins`, exp: `GRANT ROLE ops TO ROLE admins`},
+		{s: `revoke role ops from alice`, exp: `REVOKE ROLE ops FROM alice`},
+		{s: `create user "bob smith" with password 'it\'s'`, exp: `CREATE USER "bob smith" WITH PASSWORD 'it\'s'`},
+		{s: `alter user bob account lock with password 'x'`, exp: `ALTER USER bob WITH PASSWORD 'x' ACCOUNT LOCK`},
+		{s: `alter user bob account unlock`, exp: `ALTER USER bob ACCOUNT UNLOCK`},
+		{s: `alter user bob bindhost '::1','10.0.0.0/8' with password 'x'`, exp: `ALTER USER bob WITH PASSWORD 'x' BINDHOST '::1', '10.0.0.0/8'`},
+		{s: `drop user bob`, exp: `DROP USER bob`},
+		{s: `create role ops`, exp: `CREATE ROLE ops`},
+		{s: `drop role ops`, exp: `DROP ROLE ops`},
+		{s: `select * from cpu`, exp: `SELECT * FROM cpu`},
+		{s: `select value,"host name" from mydb..cpu, daily.mem`, exp: `SELECT value, "host name" FROM mydb..cpu, daily.mem`},
+		{s: `insert into mydb.autogen."cpu.load"`, exp: `INSERT INTO mydb.autogen."cpu.load"`},
+		{s: `delete from cpu`, exp: `DELETE FROM cpu`},
+		{s: `drop measurement "select"`, exp: `DROP MEASUREMENT "select"`},
+		{s: `create database mydb`, exp: `CREATE DATABASE mydb`},
+		{s: `drop database mydb`, exp: `DROP DATABASE mydb`},
+		{s: `show users`, exp: `SHOW USERS`},
+		{s: `show roles`, exp: `SHOW ROLES`},
+		{s: `show databases`, exp: `SHOW DATABASES`},
+		{s: `show continuous queries`, exp: `SHOW CONTINUOUS QUERIES`},
+		{s: `show grants for role "ops team"`, exp: `SHOW GRANTS FOR ROLE "ops team"`},
+	}
+	for _, test := range tests {
+		stmt, err := priv.ParseStatement(test.s)
+		if err != nil {
+			t.Fatalf("parse %s got unexpected error '%v'", test.s, err)
+		}
+		if act := stmt.String(); act != test.exp {
+			t.Fatalf("string of %s got %s expect %s", test.s, act, test.exp)
+		}
+		assertRoundTrip(t, stmt)
+	}
+}
+
+func TestStatementStringFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		resource := randomResourcePath(r)
+		privilege := randomPrivilege(r)
+		if len(resource.Segs) > 0 {
+			privilege &= priv.AllResourcePrivileges
+		} else {
+			resource = priv.NewResourcePath()
+		}
+		if privilege == priv.NoPrivilege {
+			continue
+		}
+
+		var privileges []priv.Privilege
+		for mask := priv.Privilege(1); mask <= privilege; mask <<= 1 {
+			if privilege&mask == mask {
+				privileges = append(privileges, mask)
+			}
+		}
+		name := randomSegs[r.Intn(len(randomSegs))]
+		role := r.Intn(2) == 0
+
+		assertRoundTrip(t, &priv.GrantStatement{Privileges: privileges, On: resource, Name: name, Role: role,
+			Duration: time.Duration(r.Intn(3)) * 90 * time.Second})
+		assertRoundTrip(t, &priv.RevokeStatement{Privileges: privileges, On: resource, Name: name, Role: role})
+		assertRoundTrip(t, &priv.SelectStatement{Fields: []string{name},
+			Sources: []*priv.Measurement{{Database: "mydb", Name: randomSegs[r.Intn(len(randomSegs))]}}})
+		assertRoundTrip(t, &priv.AlterUserStatement{Name: name, Password: &name})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	s := `-- bootstrap privileges
+CREATE USER alice WITH PASSWORD 'secret';;
+GRANT SELECT ON mydb TO alice ;
+  GRANT ROLE ops TO alice`
+
+	q, err := priv.ParseQuery(s)
+	if err != nil {
+		t.Fatalf("parse query got error '%v'", err)
+	}
+	exp := &priv.Query{
+		Statements: []priv.Statement{
+			&priv.CreateUserStatement{Name: "alice", Password: "secret"},
+			&priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege},
+				On:         priv.NewResourcePath("mydb"),
+				Name:       "alice",
+			},
+			&priv.GrantRoleStatement{Role: "ops", Name: "alice"},
+		},
+		Positions: []priv.StatementPos{
+			{Start: priv.Pos{Line: 1, Char: 0}, End: priv.Pos{Line: 1, Char: 40}},
+			{Start: priv.Pos{Line: 2, Char: 0}, End: priv.Pos{Line: 2, Char: 30}},
+			{Start: priv.Pos{Line: 3, Char: 2}, End: priv.Pos{Line: 3, Char: 26}},
+		},
+	}
+	if !reflect.DeepEqual(q, exp) {
+		t.Fatalf("parse query got %#v expect %#v", q, exp)
+	}
+	if again, err := priv.ParseQuery(q.String()); err != nil || !reflect.DeepEqual(again.Statements, q.Statements) {
+		t.Fatalf("parse query %s got %#v, '%v'", q, again, err)
+	}
+
+	var executed []priv.Statement
+	errFailed := errors.New("failed")
+	err = q.Execute(func(stmt priv.Statement) error {
+		executed = append(executed, stmt)
+		if _, ok := stmt.(*priv.GrantStatement); ok {
+			return errFailed
+		}
+		return nil
+	})
+	var e *priv.ExecutionError
+	if !errors.As(err, &e) || e.Index != 1 || e.Statement != q.Statements[1] || !errors.Is(err, errFailed) {
+		t.Fatalf("execute query got error '%v'", err)
+	}
+	if act, exp := err.Error(), "statement 2 at line 3, char 1: failed"; act != exp {
+		t.Fatalf("execute query got error '%s' expect '%s'", act, exp)
+	}
+	if len(executed) != 2 {
+		t.Fatalf("execute query got %d statements executed expect 2", len(executed))
+	}
+	if err := q.Execute(func(priv.Statement) error { return nil }); err != nil {
+		t.Fatalf("execute query got error '%v'", err)
+	}
+
+	// queries built without parsing have no positions
+	built := &priv.Query{Statements: q.Statements}
+	err = built.Execute(func(priv.Statement) error { return errFailed })
+	if !errors.As(err, &e) || e.Index != 0 || e.Pos != (priv.StatementPos{}) {
+		t.Fatalf("execute query without positions got error '%v'", err)
+	}
+
+	for _, s := range []string{``, `;`, ` ; -- nothing`} {
+		if q, err := priv.ParseQuery(s); err != nil || len(q.Statements) != 0 {
+			t.Fatalf("parse empty query %s got %v, '%v'", s, q, err)
+		}
+	}
+
+	var tests = []struct {
+		s   string
+		err string
+	}{
+		{s: `DROP USER alice DROP USER bob`, err: `found DROP, expected ; at line 1, char 17`},
+		{s: "DROP USER alice;\nDROP USR bob", err: `found USR, expected DATABASE, MEASUREMENT, USER, ROLE (did you mean USER?) at line 2, char 6`},
+		{s: `DROP USER $user`, err: `found $user, expected identifier at line 1, char 11`},
+	}
+	for _, test := range tests {
+		if _, err := priv.ParseQuery(test.s); err == nil || err.Error() != test.err {
+			t.Fatalf("parse query %s got error '%v' expect error '%s'", test.s, err, test.err)
+		}
+	}
+}
+
+func TestParseStatementWithParams(t *testing.T) {
+	var tests = []struct {
+		s      string
+		params string
+		stmt   priv.Statement
+		err    string
+	}{
+		{
+			s:      `GRANT SELECT ON $db.$rp.cpu TO $user FOR $d`,
+			params: `{"db": {"identifier": "my db"}, "rp": {"ident": "autogen"}, "user": {"identifier": "alice"}, "d": {"duration": "2h"}}`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege},
+				On:         priv.NewResourcePath("my db", "autogen", "cpu"),
+				Name:       "alice",
+				Duration:   2 * time.Hour,
+			},
+		},
+		{
+			s:      `GRANT INSERT ON mydb TO bob UNTIL $t`,
+			params: `{"t": "2026-11-01"}`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.InsertPrivilege},
+				On:         priv.NewResourcePath("mydb"),
+				Name:       "bob",
+				Expires:    time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			s:      `CREATE USER $user WITH PASSWORD $password`,
+			params: `{"user": {"identifier": "alice"}, "password": "'; DROP USER admin"}`,
+			stmt:   &priv.CreateUserStatement{Name: "alice", Password: "'; DROP USER admin"},
+		},
+		{
+			s:      `GRANT SELECT ON mydb TO $user`,
+			params: `{"user": "alice"}`,
+			err:    `found alice, expected identifier at line 1, char 25`,
+		},
+		{
+			s:      `GRANT SELECT ON mydb TO $user`,
+			params: `{}`,
+			err:    `found $user, expected identifier at line 1, char 25`,
+		},
+		{
+			s:      `GRANT SELECT ON mydb TO $user`,
+			params: `{"user": null}`,
+			err:    `invalid bound parameter user: unable to bind parameter with type <nil> at line 1, char 25`,
+		},
+		{
+			s:      `GRANT SELECT ON mydb TO alice FOR $d`,
+			params: `{"d": {"duration": "2 hours"}}`,
+			err:    `invalid bound parameter d: invalid duration 2 hours at line 1, char 35`,
+		},
+		{
+			s:      `GRANT SELECT ON mydb TO $user`,
+			params: `{"user": {"identifier": "alice", "string": "bob"}}`,
+			err:    `invalid bound parameter user: bound parameter object must have exactly one key at line 1, char 25`,
+		},
+		{
+			s:      `GRANT SELECT ON mydb TO $user`,
+			params: `{"user": {"identifier": 1}}`,
+			err:    `invalid bound parameter user: unable to bind 1 as identifier at line 1, char 25`,
+		},
+		{
+			s:      `GRANT SELECT ON mydb TO $user`,
+			params: `{"user": {"name": "alice"}}`,
+			err:    `invalid bound parameter user: unknown bound parameter type name at line 1, char 25`,
+		},
+		{
+			s:      `GRANT SELECT ON $db TO alice`,
+			params: `{"db": {"identifier": "mydb"}, "user": null}`,
+			stmt: &priv.GrantStatement{
+				Privileges: []priv.Privilege{priv.SelectPrivilege},
+				On:         priv.NewResourcePath("mydb"),
+				Name:       "alice",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var params map[string]interface{}
+		if err := json.Unmarshal([]byte(test.params), &params); err != nil {
+			t.Fatalf("unmarshal params %s got error '%v'", test.params, err)
+		}
+
+		stmt, err := priv.NewParserWithParams(strings.NewReader(test.s), params).ParseStatement()
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("parse %s with %s got error '%v' expect error '%s'", test.s, test.params, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parse %s with %s got unexpected error '%v'", test.s, test.params, err)
+		}
+		if !reflect.DeepEqual(stmt, test.stmt) {
+			t.Fatalf("parse %s with %s got %#v expect %#v", test.s, test.params, stmt, test.stmt)
+		}
+		assertRoundTrip(t, stmt)
+	}
+}
+
+func TestBindValue(t *testing.T) {
+	var tests = []struct {
+		v   interface{}
+		tok priv.Token
+		lit string
+	}{
+		{v: "alice", tok: priv.STRING, lit: "alice"},
+		{v: true, tok: priv.TRUE, lit: "true"},
+		{v: false, tok: priv.FALSE, lit: "false"},
+		{v: 1.5, tok: priv.NUMBER, lit: "1.5"},
+		{v: int64(-3), tok: priv.INTEGER, lit: "-3"},
+		{v: json.Number("7"), tok: priv.INTEGER, lit: "7"},
+		{v: json.Number("7.25"), tok: priv.NUMBER, lit: "7.25"},
+		{v: 90 * time.Minute, tok: priv.DURATIONVAL, lit: "90m"},
+		{v: map[string]interface{}{"regex": "^cpu"}, tok: priv.REGEX, lit: "^cpu"},
+		{v: map[string]interface{}{"integer": 2.0}, tok: priv.INTEGER, lit: "2"},
+		{v: map[string]interface{}{"number": 2.0}, tok: priv.NUMBER, lit: "2"},
+		{v: map[string]interface{}{"boolean": true}, tok: priv.TRUE, lit: "true"},
+		{v: map[string]interface{}{"integer": 2.5}, tok: priv.BOUNDPARAM, lit: "unable to bind 2.5 as integer"},
+		{v: map[string]interface{}{"regex": "("}, tok: priv.BOUNDPARAM, lit: "error parsing regexp: missing closing ): `(`"},
+		{v: []interface{}{}, tok: priv.BOUNDPARAM, lit: "unable to bind parameter with type []interface {}"},
+	}
+	for _, test := range tests {
+		v := priv.BindValue(test.v)
+		if tok, lit := v.TokenType(), v.Value(); tok != test.tok || lit != test.lit {
+			t.Fatalf("bind %#v got %s %s expect %s %s", test.v, tok, lit, test.tok, test.lit)
+		}
+	}
+}
+
+func TestGrantStatementPrivilege(t *testing.T) {
+	stmt, err := priv.ParseStatement(`GRANT SELECT, DELETE, DROP ON mydb TO alice`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := priv.SelectPrivilege | priv.DeletePrivilege | priv.DropPrivilege
+	if act := stmt.(*priv.GrantStatement).Privilege(); act != exp {
+		t.Fatalf("privilege of grant statement got %s expect %s", act, exp)
+	}
+}
+
+func boolPtr(v bool) *bool { return &v }
+
+func stringPtr(v string) *string { return &v }
+
+func TestParseErrorInvalidIP(t *testing.T) {
+	_, err := priv.ParseStatement(`ALTER USER svc BINDHOST '10.0.0.1', '10.0.0.999'`)
+	var perr *priv.ParseError
+	if !errors.As(err, &perr) || !errors.Is(err, priv.ErrInvalidIP) {
+		t.Fatalf("parse got error '%v' expect parse error wrapping '%v'", err, priv.ErrInvalidIP)
+	}
+}
+
+func TestParseErrorSuggestion(t *testing.T) {
+	var tests = []struct {
+		s          string
+		suggestion string
+	}{
+		{s: `GRNAT SELECT ON mydb TO alice`, suggestion: "GRANT"},
+		{s: `DRP USER bob`, suggestion: "DROP"},
+		{s: `REVOKE SELECT ON mydb FORM alice`, suggestion: "FROM"},
+		{s: `GRANT ALL PRIVILEGE ON mydb TO alice`, suggestion: "ALL PRIVILEGES"},
+		{s: `GRANT selct ON mydb TO alice`, suggestion: "SELECT"},
+		{s: `GRANT SHOW USR TO alice`, suggestion: "SHOW USERS"},
+		{s: `XYZ USER bob`},
+		{s: `GRANT SELECT ON mydb TO`},
+	}
+
+	for _, test := range tests {
+		_, err := priv.ParseStatement(test.s)
+		perr, ok := err.(*priv.ParseError)
+		if !ok {
+			t.Fatalf("parse %s got error '%v' expect parse error", test.s, err)
+		}
+		if perr.Suggestion != test.suggestion {
+			t.Fatalf("parse %s got suggestion '%s' expect '%s'", test.s, perr.Suggestion, test.suggestion)
+		}
+	}
+}
+
+func TestParseErrorCaret(t *testing.T) {
+	var tests = []struct {
+		s     string
+		caret string
+	}{
+		{
+			s:     `GRNAT SELECT ON mydb TO alice`,
+			caret: "GRNAT SELECT ON mydb TO alice\n^",
+		},
+		{
+			s:     `GRANT SELECT ON mydb TOO alice`,
+			caret: "GRANT SELECT ON mydb TOO alice\n                     ^",
+		},
+		{
+			s:     "DROP USER alice;\n\tDROP USR bob",
+			caret: "\tDROP USR bob\n\t     ^",
+		},
+		{
+			s:     `GRANT SELECT ON mydb TO`,
+			caret: "GRANT SELECT ON mydb TO\n                        ^",
+		},
+	}
+
+	for _, test := range tests {
+		_, err := priv.ParseQuery(test.s)
+		perr, ok := err.(*priv.ParseError)
+		if !ok {
+			t.Fatalf("parse %s got error '%v' expect parse error", test.s, err)
+		}
+		if act := perr.Caret(test.s); act != test.caret {
+			t.Fatalf("parse %s got caret\n%s\nexpect\n%s", test.s, act, test.caret)
+		}
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	ref := func(name string) *priv.VarRef { return &priv.VarRef{Val: name} }
+	integer := func(v int64) *priv.IntegerLiteral { return &priv.IntegerLiteral{Val: v} }
+	binary := func(op priv.Token, lhs, rhs priv.Expr) *priv.BinaryExpr {
+		return &priv.BinaryExpr{Op: op, LHS: lhs, RHS: rhs}
+	}
+
+	var tests = []struct {
+		s    string
+		expr priv.Expr
+		str  string
+		err  string
+	}{
+		{s: `host`, expr: ref("host")},
+		{s: `"my host"`, expr: ref("my host")},
+		{s: `'server01'`, expr: &priv.StringLiteral{Val: "server01"}},
+		{s: `100`, expr: integer(100)},
+		{s: `-100`, expr: integer(-100)},
+		{s: `1.5`, expr: &priv.NumberLiteral{Val: 1.5}},
+		{s: `-.5`, expr: &priv.NumberLiteral{Val: -0.5}, str: `-0.5`},
+		{s: `2.`, expr: &priv.NumberLiteral{Val: 2}, str: `2.0`},
+		{s: `true`, expr: &priv.BooleanLiteral{Val: true}},
+		{s: `FALSE`, expr: &priv.BooleanLiteral{Val: false}, str: `false`},
+		{s: `90m`, expr: &priv.DurationLiteral{Val: 90 * time.Minute}},
+		{s: `-1d`, expr: &priv.DurationLiteral{Val: -24 * time.Hour}},
+		{s: `(host)`, expr: &priv.ParenExpr{Expr: ref("host")}},
+		{
+			s:    `1 + 2 * 3`,
+			expr: binary(priv.ADD, integer(1), binary(priv.MUL, integer(2), integer(3))),
+		},
+		{
+			s:    `1 * 2 + 3`,
+			expr: binary(priv.ADD, binary(priv.MUL, integer(1), integer(2)), integer(3)),
+		},
+		{
+			s:    `1 - 2 - 3`,
+			expr: binary(priv.SUB, binary(priv.SUB, integer(1), integer(2)), integer(3)),
+		},
+		{
+			s:    `1 - (2 - 3)`,
+			expr: binary(priv.SUB, integer(1), &priv.ParenExpr{Expr: binary(priv.SUB, integer(2), integer(3))}),
+		},
+		{
+			s:    `a | b & c ^ d % e`,
+			expr: binary(priv.BITWISE_XOR, binary(priv.BITWISE_OR, ref("a"), binary(priv.BITWISE_AND, ref("b"), ref("c"))), binary(priv.MOD, ref("d"), ref("e"))),
+		},
+		{
+			s: `a = 1 OR b > 2 AND c <= 3`,
+			expr: binary(priv.OR,
+				binary(priv.EQ, ref("a"), integer(1)),
+				binary(priv.AND, binary(priv.GT, ref("b"), integer(2)), binary(priv.LTE, ref("c"), integer(3)))),
+		},
+		{
+			s: `(a = 1 OR b >= 2) AND c < 3 - 1`,
+			expr: binary(priv.AND,
+				&priv.ParenExpr{Expr: binary(priv.OR, binary(priv.EQ, ref("a"), integer(1)), binary(priv.GTE, ref("b"), integer(2)))},
+				binary(priv.LT, ref("c"), binary(priv.SUB, integer(3), integer(1)))),
+		},
+		{
+			s: `host =~ /^server\d+$/ AND region !~/us\/east/`,
+			expr: binary(priv.AND,
+				binary(priv.EQREGEX, ref("host"), &priv.RegexLiteral{Val: regexp.MustCompile(`^server\d+$`)}),
+				binary(priv.NEQREGEX, ref("region"), &priv.RegexLiteral{Val: regexp.MustCompile(`us/east`)})),
+			str: `host =~ /^server\d+$/ AND region !~ /us\/east/`,
+		},
+		{
+			s: `host IN ('a', "b") AND n + 1 IN (2, 3.5)`,
+			expr: binary(priv.AND,
+				binary(priv.IN, ref("host"), &priv.ListLiteral{Vals: []priv.Expr{&priv.StringLiteral{Val: "a"}, ref("b")}}),
+				binary(priv.IN, binary(priv.ADD, ref("n"), integer(1)), &priv.ListLiteral{Vals: []priv.Expr{integer(2), &priv.NumberLiteral{Val: 3.5}}})),
+			str: `host IN ('a', b) AND n + 1 IN (2, 3.5)`,
+		},
+		{
+			s:    `time > 1h AND host != 'a' -- comment`,
+			expr: binary(priv.AND, binary(priv.GT, ref("time"), &priv.DurationLiteral{Val: time.Hour}), binary(priv.NEQ, ref("host"), &priv.StringLiteral{Val: "a"})),
+			str:  `time > 1h AND host != 'a'`,
+		},
+
+		{s: ``, err: `found EOF, expected identifier, string, number, bool at line 1, char 1`},
+		{s: `(a`, err: `found EOF, expected ) at line 1, char 4`},
+		{s: `a +`, err: `found EOF, expected identifier, string, number, bool at line 1, char 4`},
+		{s: `- a`, err: `found -, expected identifier, string, number, bool at line 1, char 1`},
+		{s: `a b`, err: `found b, expected EOF at line 1, char 3`},
+		{s: `a IN b`, err: `found b, expected ( at line 1, char 6`},
+		{s: `a IN ()`, err: `found ), expected identifier, string, number, bool at line 1, char 7`},
+		{s: `a IN (1 2)`, err: `found 2, expected ,, ) at line 1, char 9`},
+		{s: `a =~ 'b'`, err: `found BADREGEX, expected regex at line 1, char 5`},
+		{s: `a =~ /x/y/`, err: `found y, expected EOF at line 1, char 9`},
+		{s: `99999999999999999999`, err: `unable to parse integer at line 1, char 1`},
+	}
+
+	for _, test := range tests {
+		expr, err := priv.ParseExpr(test.s)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("parse expr %s got error '%v' expect error '%s'", test.s, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("parse expr %s got unexpected error '%v'", test.s, err)
+		}
+		if !reflect.DeepEqual(expr, test.expr) {
+			t.Fatalf("parse expr %s got %s expect %s", test.s, expr, test.expr)
+		}
+
+		str := test.str
+		if str == "" {
+			str = test.s
+		}
+		if act := expr.String(); act != str {
+			t.Fatalf("parse expr %s got string %s expect %s", test.s, act, str)
+		}
+		if again, err := priv.ParseExpr(str); err != nil || !reflect.DeepEqual(again, expr) {
+			t.Fatalf("parse expr %s again got %v, error '%v'", str, again, err)
+		}
+	}
+}
+
+func TestParseExprWithParams(t *testing.T) {
+	p := priv.NewParserWithParams(strings.NewReader(`host = $host AND n > $n`), map[string]interface{}{
+		"host": "server01",
+		"n":    map[string]interface{}{"integer": json.Number("3")},
+	})
+	expr, err := p.ParseExpr()
+	if err != nil {
+		t.Fatalf("parse expr got unexpected error '%v'", err)
+	}
+	if act, exp := expr.String(), `host = 'server01' AND n > 3`; act != exp {
+		t.Fatalf("parse expr got %s expect %s", act, exp)
+	}
+
+	p = priv.NewParserWithParams(strings.NewReader(`host = $host`), map[string]interface{}{"host": []string{}})
+	if _, err := p.ParseExpr(); err == nil || !strings.HasPrefix(err.Error(), "invalid bound parameter host") {
+		t.Fatalf("parse expr got error '%v' expect invalid bound parameter", err)
+	}
+}
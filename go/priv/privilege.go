@@ -2,8 +2,10 @@ package priv
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -66,6 +68,9 @@ func (p Privilege) String() string {
 func PrivilegeOf(name string) (Privilege, error) {
 	p, ok := name2privilege[strings.ToUpper(strings.TrimSpace(name))]
 	if !ok {
+		if suggestion := suggestPrivilege(name); suggestion != "" {
+			return NoPrivilege, fmt.Errorf("unknown privilege '%s' (did you mean %s?)", name, suggestion)
+		}
 		return NoPrivilege, fmt.Errorf("unknown privilege '%s'", name)
 	}
 	return p, nil
@@ -107,8 +112,11 @@ func init() {
 }
 
 // ResourcePath represent dot seperated path, eg `"my.db".autogen.cpu`
+// A path may also refer to all measurements matching a regex, in which case
+// Segs holds database and retention policy, eg `mydb.autogen./^cpu_.*/`
 type ResourcePath struct {
-	Segs []string
+	Segs  []string
+	Regex *regexp.Regexp
 }
 
 var GlobalResource = NewResourcePath()
@@ -125,11 +133,7 @@ func CreateResourcePath(resource string) (*ResourcePath, error) {
 
 	qr := strings.NewReader(string(resource))
 	p := NewParser(qr)
-	segs, err := p.parseSegmentedIdents()
-	if err != nil {
-		return nil, err
-	}
-	return NewResourcePath(segs...), nil
+	return p.parseResourcePath()
 }
 
 func NewResourcePath(segs ...string) *ResourcePath {
@@ -140,7 +144,16 @@ func NewResourcePath(segs ...string) *ResourcePath {
 		segs[1] = "autogen" // autogen may have omited
 	}
 
-	return &ResourcePath{segs}
+	return &ResourcePath{Segs: segs}
+}
+
+// NewRegexResourcePath creates path of all measurements matching regex in
+// the given database and retention policy.
+func NewRegexResourcePath(regex *regexp.Regexp, database, retentionPolicy string) *ResourcePath {
+	if retentionPolicy == "" {
+		retentionPolicy = "autogen"
+	}
+	return &ResourcePath{Segs: []string{database, retentionPolicy}, Regex: regex}
 }
 
 func (r *ResourcePath) String() string {
@@ -152,10 +165,19 @@ func (r *ResourcePath) String() string {
 		}
 		buf.WriteString(QuoteIdent(seg))
 	}
+	if r.Regex != nil {
+		buf.WriteString(".")
+		buf.WriteString(QuoteRegex(r.Regex))
+	}
 
 	return buf.String()
 }
 
+// QuoteRegex returns a regex literal delimited by slashes.
+func QuoteRegex(regex *regexp.Regexp) string {
+	return "/" + escapeRegex(regex.String()) + "/"
+}
+
 // PrivilegeSet manipulates privilges on resources.
 type PrivilegeSet interface {
 	// SetAll set full privileges to privilege set.
@@ -170,19 +192,37 @@ type PrivilegeSet interface {
 	Add(resource *ResourcePath, privilege Privilege)
 	// Delete some privielges from all resources under the given resource name.
 	Delete(resource *ResourcePath, privilege Privilege)
-	// UnionWith combine all privileges of 2 privilege sets.
+	// Deny some privileges on given resource and all resources under it.
+	Deny(resource *ResourcePath, privilege Privilege)
+	// Undeny removes denied privileges from all resources under the given resource name.
+	Undeny(resource *ResourcePath, privilege Privilege)
+	// UnionWith combine all privileges of 2 privilege sets. Denies of both
+	// sets are kept, so the result may not contain privileges the set had
+	// before if s denies them.
 	UnionWith(s PrivilegeSet)
 	// DifferentWith delete all privileges from the given privilege set.
 	DifferentWith(s PrivilegeSet)
+	// IntersectWith keeps only privileges also contained by the given privilege set.
+	IntersectWith(s PrivilegeSet)
+	// SymmetricDifference keeps privileges contained by exactly one of the 2
+	// privilege sets.
+	SymmetricDifference(s PrivilegeSet)
 	// GlobalContain checks if root node have the given privileges.
 	// It should be noticed that this does not mean have privileges on every resources.
 	GlobalContain(privilege Privilege) bool
 	// Contain checks if privileges set contains privileges on the given resource.
 	Contain(resource *ResourcePath, privilege Privilege) bool
-	// Contains checks if the privilege set contains all privileges from another set.
+	// Contains checks if the privilege set contains all privileges from
+	// another set, neither set is modified.
 	Contains(s PrivilegeSet) bool
+	// Equal checks if 2 privilege sets contain each other and deny the same
+	// privileges.
+	Equal(s PrivilegeSet) bool
 	// Powerless check set if don't has any privilege.
 	Powerless() bool
+	// Clone returns a deep copy of the privilege set, which is modified
+	// independently.
+	Clone() PrivilegeSet
 }
 
 // NewPrivilegeTree create an empty privilege tree
@@ -191,105 +231,274 @@ func NewPrivilegeTree() *PrivilegeTree {
 }
 
 // PrivilegeTree is an implementation of PrivilegeSet interface.
+//
+// Besides literal children in Tree, a node may have pattern children in
+// Patterns keyed by regex, which give privileges to children not presented
+// in Tree. Literal children take precedence over patterns, if more than one
+// pattern matches a name, privileges of all matching patterns are combined.
+// A pattern which neither changes inherited privileges nor denies or
+// conditionally grants any privilege matches nothing, as if it were removed.
+// To keep literal children consistent with patterns, a new literal child
+// starts with privileges given by patterns it matches, and adding or deleting
+// privileges on a pattern also applies to existing literal children it matches.
+//
+// Besides granted privileges stored as deltas, a node may deny privileges
+// explicitly. Denied privileges are not toggles, they are never granted on the
+// node and all nodes under it, no matter they are granted on the same node,
+// its parents or children, before or after the deny. In other words, deny
+// beats allow at the same or deeper level. A deny is only removed by Undeny,
+// SetAll or ClearAll.
+//
+// A node may also grant privileges on rows satisfying conditions only, which
+// are kept in Conditions keyed by string of the condition. Conditional
+// privileges are absolute rather than deltas, a condition of a node overrides
+// the same one inherited from its parents, so that privileges revoked on the
+// node are revoked from rows of its parents' conditions too. They are checked
+// by ContainWith and ignored by Contain.
 type PrivilegeTree struct {
-	Privilege Privilege
-	Tree      map[string]*PrivilegeTree
+	Privilege  Privilege
+	Denied     Privilege
+	Tree       map[string]*PrivilegeTree
+	Patterns   map[string]*PrivilegeTree
+	Regex      *regexp.Regexp // regex of a pattern node, nil for literal nodes
+	Conditions map[string]*ConditionalPrivilege
 }
 
 func (t *PrivilegeTree) implPrivilegeSet() {
 	var _ PrivilegeSet = (*PrivilegeTree)(nil)
 }
 
-// SetAll set full privileges to privilege tree.
+// SetAll set full privileges to privilege tree, all denies are removed.
 func (t *PrivilegeTree) SetAll() {
 	t.Privilege = AllGlobalPrivileges
+	t.Denied = NoPrivilege
 	t.Tree = make(map[string]*PrivilegeTree)
+	t.Patterns = nil
+	t.Conditions = nil
 }
 
-// ClearAll clear all privileges from privilege tree.
+// ClearAll clear all privileges and denies from privilege tree.
 func (t *PrivilegeTree) ClearAll() {
 	t.Privilege = NoPrivilege
+	t.Denied = NoPrivilege
 	t.Tree = make(map[string]*PrivilegeTree)
+	t.Patterns = nil
+	t.Conditions = nil
 }
 
 // AddGlobal add some privileges to global resource.
 func (t *PrivilegeTree) AddGlobal(privilege Privilege) {
 	t.Privilege |= privilege
-	for _, v := range t.Tree {
-		if v != nil {
-			v.DeleteGlobal(privilege)
-		}
-	}
+	t.deleteChildren(privilege, t.inheritConditions(nil))
 }
 
-// DeleteGlobal delete some privileges from all resources.
+// DeleteGlobal delete some privileges from all resources, including
+// privileges granted under conditions.
 func (t *PrivilegeTree) DeleteGlobal(privilege Privilege) {
+	t.deleteGlobal(privilege, nil)
+}
+
+// deleteGlobal delete some privileges from the node and all nodes under it,
+// conditions is conditional privileges in effect on its parent.
+func (t *PrivilegeTree) deleteGlobal(privilege Privilege, conditions map[string]*ConditionalPrivilege) {
 	t.Privilege &^= privilege
+	t.deleteConditions(privilege, conditions)
+	t.deleteChildren(privilege, t.inheritConditions(conditions))
+	t.prune()
+}
+
+// deleteChildren delete some privileges from all literal and pattern children,
+// so that children inherit these privileges from t. Conditional grants of
+// these privileges are deleted as well, as they are covered by t. conditions
+// is conditional privileges in effect on t.
+func (t *PrivilegeTree) deleteChildren(privilege Privilege, conditions map[string]*ConditionalPrivilege) {
 	for _, v := range t.Tree {
 		if v != nil {
-			v.DeleteGlobal(privilege)
+			v.deleteGlobal(privilege, conditions)
+		}
+	}
+	for _, v := range t.Patterns {
+		if v != nil {
+			v.deleteGlobal(privilege, conditions)
 		}
 	}
-	t.prune()
 }
 
 // Add some privileges to given resource
 func (t *PrivilegeTree) Add(resource *ResourcePath, privilege Privilege) {
 	privilege &= AllResourcePrivileges
 
-	sum := NoPrivilege
-	for _, seg := range resource.Segs {
-		sum ^= t.Privilege
-		if t.Tree[seg] == nil {
-			t.Tree[seg] = NewPrivilegeTree()
-		}
-		t = t.Tree[seg]
+	sum, conditions, targets := t.targets(resource)
+	for _, t := range targets {
+		result := ^sum            // make sure (result ^ sum) & privilege = privilege
+		result &= privilege       // clear all bits unrelated with incoming privilege
+		t.Privilege &^= privilege // reset related bits to zero
+		t.Privilege |= result     // set with new value
+
+		t.deleteChildren(privilege, t.inheritConditions(conditions))
+		t.prune()
+	}
+}
+
+// Delete some privielges from all resources under the given resource name,
+// including privileges granted under conditions on the resource, its parents
+// or resources under it.
+func (t *PrivilegeTree) Delete(resource *ResourcePath, privilege Privilege) {
+	privilege &= AllResourcePrivileges
+
+	sum, conditions, targets := t.targets(resource)
+	sum &= privilege // clear all bits unrelated with incoming privilege
+	for _, t := range targets {
+		t.Privilege &^= privilege // reset related bits to zero
+		t.Privilege |= sum        // set with new value, (t.Privilege & privilege) ^ sum = 0
+
+		t.revokeConditions(privilege, conditions)
+		t.deleteChildren(privilege, t.inheritConditions(conditions))
+		t.prune()
 	}
-	result := ^sum            // make sure (result ^ sum) & privilege = privilege
-	result &= privilege       // clear all bits unrelated with incoming privilege
-	t.Privilege &^= privilege // reset related bits to zero
-	t.Privilege |= result     // set with new value
+}
+
+// Deny some privileges on given resource and all resources under it.
+// Only resource privileges can be denied on a resource other than global.
+func (t *PrivilegeTree) Deny(resource *ResourcePath, privilege Privilege) {
+	if len(resource.Segs) > 0 {
+		privilege &= AllResourcePrivileges
+	}
+
+	_, _, targets := t.targets(resource)
+	for _, t := range targets {
+		t.Denied |= privilege
+	}
+}
 
+// Undeny removes denied privileges from all resources under the given resource
+// name, privileges denied on its parents are still denied.
+func (t *PrivilegeTree) Undeny(resource *ResourcePath, privilege Privilege) {
+	_, _, targets := t.targets(resource)
+	for _, t := range targets {
+		t.undeny(privilege)
+		t.prune()
+	}
+}
+
+func (t *PrivilegeTree) undeny(privilege Privilege) {
+	t.Denied &^= privilege
 	for _, v := range t.Tree {
 		if v != nil {
-			v.DeleteGlobal(privilege)
+			v.undeny(privilege)
+		}
+	}
+	for _, v := range t.Patterns {
+		if v != nil {
+			v.undeny(privilege)
 		}
 	}
-	t.prune()
 }
 
-// Delete some privielges from all resources under the given resource name.
-func (t *PrivilegeTree) Delete(resource *ResourcePath, privilege Privilege) {
-	privilege &= AllResourcePrivileges
-
+// targets finds nodes of the given resource and creates them if not exist,
+// and returns effective privilege and conditional privileges of their parent.
+// For a regex resource, the pattern node and all literal siblings it matches
+// are returned.
+func (t *PrivilegeTree) targets(resource *ResourcePath) (Privilege, map[string]*ConditionalPrivilege, []*PrivilegeTree) {
 	sum := NoPrivilege
+	var conditions map[string]*ConditionalPrivilege
 	for _, seg := range resource.Segs {
 		sum ^= t.Privilege
-		if t.Tree[seg] == nil {
-			t.Tree[seg] = NewPrivilegeTree()
+		conditions = t.inheritConditions(conditions)
+		t = t.child(seg, sum, conditions)
+	}
+	if resource.Regex == nil {
+		return sum, conditions, []*PrivilegeTree{t}
+	}
+
+	sum ^= t.Privilege
+	conditions = t.inheritConditions(conditions)
+	targets := []*PrivilegeTree{t.pattern(resource.Regex)}
+	for k, v := range t.Tree {
+		if v != nil && resource.Regex.MatchString(k) {
+			targets = append(targets, v)
 		}
-		t = t.Tree[seg]
 	}
-	sum &= privilege          // clear all bits unrelated with incoming privilege
-	t.Privilege &^= privilege // reset related bits to zero
-	t.Privilege |= sum        // set with new value, (t.Privilege & privilege) ^ sum = 0
+	return sum, conditions, targets
+}
 
-	for _, v := range t.Tree {
-		if v != nil {
-			v.DeleteGlobal(privilege)
+// child returns literal child of given name, creates it if not exists.
+// sum and conditions are the effective privilege and conditional privileges
+// of t.
+func (t *PrivilegeTree) child(name string, sum Privilege, conditions map[string]*ConditionalPrivilege) *PrivilegeTree {
+	c := t.Tree[name]
+	if c == nil {
+		c = t.virtualChild(name, sum, conditions)
+		t.Tree[name] = c
+	}
+	return c
+}
+
+// virtualChild returns a node standing for a child not presented in Tree,
+// whose privilege, denied privilege and conditions are given by patterns it
+// matches. sum and conditions are the effective privilege and conditional
+// privileges of t.
+func (t *PrivilegeTree) virtualChild(name string, sum Privilege, conditions map[string]*ConditionalPrivilege) *PrivilegeTree {
+	c := NewPrivilegeTree()
+	c.Privilege = sum ^ t.match(name, sum)
+	c.Denied = t.matchDenied(name)
+	c.setConditions(t.matchConditions(name, conditions), conditions)
+	return c
+}
+
+// match returns effective privilege of a child not presented in Tree, that is
+// union of all patterns matching the name, or sum if no pattern matches.
+// sum is the effective privilege of t.
+func (t *PrivilegeTree) match(name string, sum Privilege) Privilege {
+	matched, result := false, NoPrivilege
+	for _, v := range t.Patterns {
+		if v != nil && !v.redundant() && v.Regex.MatchString(name) {
+			matched = true
+			result |= sum ^ v.Privilege
 		}
 	}
+	if !matched {
+		return sum
+	}
+	return result
+}
 
-	t.prune()
+// matchDenied returns privileges denied by any pattern matching the name.
+func (t *PrivilegeTree) matchDenied(name string) Privilege {
+	denied := NoPrivilege
+	for _, v := range t.Patterns {
+		if v != nil && v.Denied != NoPrivilege && v.Regex.MatchString(name) {
+			denied |= v.Denied
+		}
+	}
+	return denied
 }
 
-// UnionWith combine all privileges of 2 privilege trees.
+// pattern returns pattern child of given regex, creates it if not exists.
+func (t *PrivilegeTree) pattern(regex *regexp.Regexp) *PrivilegeTree {
+	if t.Patterns == nil {
+		t.Patterns = make(map[string]*PrivilegeTree)
+	}
+	c := t.Patterns[regex.String()]
+	if c == nil {
+		c = NewPrivilegeTree()
+		c.Regex = regex
+		t.Patterns[regex.String()] = c
+	}
+	return c
+}
+
+// UnionWith combine all privileges of 2 privilege trees. As deny beats allow,
+// denies of s are kept and privileges of t denied by s are lost, so t does not
+// always contain what it had before.
 func (t *PrivilegeTree) UnionWith(s PrivilegeSet) {
-	t.union(NoPrivilege, NoPrivilege, NoPrivilege, s.(*PrivilegeTree), true)
+	t.union(NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, treeOf(s), true)
 	t.prune()
 }
 
-func (t *PrivilegeTree) union(tsum, ssum, newsum Privilege, s *PrivilegeTree, root bool) {
+// union grants effective privileges of s on t, tconds, sconds and newconds are
+// conditional privileges in effect on parents of t, s and the result.
+func (t *PrivilegeTree) union(tsum, ssum, newsum Privilege, tconds, sconds, newconds map[string]*ConditionalPrivilege, s *PrivilegeTree, root bool) {
 	if t == nil || s == nil {
 		return
 	}
@@ -302,85 +511,251 @@ func (t *PrivilegeTree) union(tsum, ssum, newsum Privilege, s *PrivilegeTree, ro
 	}
 	newsum ^= current
 	t.Privilege = current
-
+	t.Denied |= s.Denied // deny beats allow
+	tconds = t.inheritConditions(tconds)
+	sconds = s.inheritConditions(sconds)
+	conditions := unionConditions(tconds, sconds)
+	t.setConditions(conditions, newconds)
+	newconds = conditions
+
+	// literal children are handled before patterns, as new literal children
+	// are initialized with privileges of patterns before they change.
 	for k, v := range s.Tree {
-		if tt := t.Tree[k]; tt == nil && v != nil {
-			t.Tree[k] = NewPrivilegeTree()
+		if v != nil {
+			t.child(k, tsum, tconds).union(tsum, ssum, newsum, tconds, sconds, newconds, v, false)
 		}
-		t.Tree[k].union(tsum, ssum, newsum, v, false)
 	}
 	for k, v := range t.Tree {
 		if _, exist := s.Tree[k]; !exist {
-			v.union(tsum, ssum, newsum, NewPrivilegeTree(), false)
+			v.union(tsum, ssum, newsum, tconds, sconds, newconds, s.virtualChild(k, ssum, sconds), false)
+		}
+	}
+	for _, v := range s.Patterns {
+		if v != nil {
+			t.pattern(v.Regex).union(tsum, ssum, newsum, tconds, sconds, newconds, v, false)
+		}
+	}
+	for k, v := range t.Patterns {
+		if _, exist := s.Patterns[k]; !exist {
+			v.union(tsum, ssum, newsum, tconds, sconds, newconds, NewPrivilegeTree(), false)
 		}
 	}
 }
 
 // DifferentWith delete all privileges from the given privilege tree.
 func (t *PrivilegeTree) DifferentWith(s PrivilegeSet) {
-	t.sub(NoPrivilege, NoPrivilege, NoPrivilege, s.(*PrivilegeTree), true)
+	t.sub(NoPrivilege, NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, treeOf(s), true)
 	t.prune()
 }
 
-func (t *PrivilegeTree) sub(tsum, ssum, newsum Privilege, s *PrivilegeTree, root bool) {
+// sub deletes effective privileges of s from t, denied is privileges denied on
+// parents of s, denies of t are kept. Conditional privileges of t are deleted
+// if s grants them without condition or under the same condition. tconds,
+// sconds and newconds are conditional privileges in effect on parents of t, s
+// and the result.
+func (t *PrivilegeTree) sub(tsum, ssum, denied, newsum Privilege, tconds, sconds, newconds map[string]*ConditionalPrivilege, s *PrivilegeTree, root bool) {
 	if t == nil || s == nil {
 		return
 	}
 
 	tsum ^= t.Privilege
 	ssum ^= s.Privilege
-	current := tsum&^ssum ^ newsum
+	denied |= s.Denied
+	current := tsum&^(ssum&^denied) ^ newsum
 	if !root {
 		current &= AllResourcePrivileges
 	}
 	newsum ^= current
 	t.Privilege = current
-
+	tconds = t.inheritConditions(tconds)
+	sconds = s.inheritConditions(sconds)
+	conditions := subConditions(tconds, sconds, ssum, denied)
+	t.setConditions(conditions, newconds)
+	newconds = conditions
+
+	// literal children are handled before patterns, as new literal children
+	// are initialized with privileges of patterns before they change.
 	for k, v := range s.Tree {
-		tt := t.Tree[k]
-		if tt == nil && v != nil {
-			t.Tree[k] = NewPrivilegeTree()
+		if v != nil {
+			t.child(k, tsum, tconds).sub(tsum, ssum, denied, newsum, tconds, sconds, newconds, v, false)
 		}
-
-		t.Tree[k].sub(tsum, ssum, newsum, v, false)
 	}
 	for k, v := range t.Tree {
 		if _, exist := s.Tree[k]; !exist {
-			v.sub(tsum, ssum, newsum, NewPrivilegeTree(), false)
+			v.sub(tsum, ssum, denied, newsum, tconds, sconds, newconds, s.virtualChild(k, ssum, sconds), false)
+		}
+	}
+	for _, v := range s.Patterns {
+		if v != nil {
+			t.pattern(v.Regex).sub(tsum, ssum, denied, newsum, tconds, sconds, newconds, v, false)
+		}
+	}
+	for k, v := range t.Patterns {
+		if _, exist := s.Patterns[k]; !exist {
+			v.sub(tsum, ssum, denied, newsum, tconds, sconds, newconds, NewPrivilegeTree(), false)
 		}
 	}
 }
 
+// IntersectWith keeps only privileges also contained by the given privilege
+// set, which is t - (t - s). Denies of t are kept.
+func (t *PrivilegeTree) IntersectWith(s PrivilegeSet) {
+	diff := t.clone()
+	diff.sub(NoPrivilege, NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, treeOf(s), true)
+	t.sub(NoPrivilege, NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, diff, true)
+	t.prune()
+}
+
+// SymmetricDifference keeps privileges contained by exactly one of the 2
+// privilege sets, which is (t - s) | (s - t). As in UnionWith, denies of both
+// sets are kept.
+func (t *PrivilegeTree) SymmetricDifference(s PrivilegeSet) {
+	other := copyTreeOf(s) // s may be t itself
+	otherOnly := other.clone()
+	otherOnly.sub(NoPrivilege, NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, t, true)
+	t.sub(NoPrivilege, NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, other, true)
+	t.union(NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, otherOnly, true)
+	t.prune()
+}
+
 // GlobalContain checks if root node have the given privileges.
 // It should be noticed that this does not mean have privileges on every resources.
 func (t *PrivilegeTree) GlobalContain(privilege Privilege) bool {
-	return privilege&^t.compatibleWithReadWrite(t.Privilege) == NoPrivilege
+	return privilege&^t.effective(t.Privilege, t.Denied) == NoPrivilege
 }
 
 // Contain checks if privileges set contains privileges on the given resource.
+// For a regex resource, privileges granted on exactly the same regex are checked.
 func (t *PrivilegeTree) Contain(resource *ResourcePath, privilege Privilege) bool {
-	sum := NoPrivilege
-	sum ^= t.Privilege
+	sum, denied := t.lookup(resource)
+	return t.effective(sum, denied)&privilege == privilege
+}
+
+// lookup returns privileges granted and denied on the given resource, legacy
+// READ and WRITE privileges are not expanded.
+func (t *PrivilegeTree) lookup(resource *ResourcePath) (Privilege, Privilege) {
+	sum, denied := t.Privilege, t.Denied
 	for _, seg := range resource.Segs {
 		if t.Tree[seg] == nil {
-			break
+			if t.Patterns != nil {
+				sum = t.match(seg, sum)
+				denied |= t.matchDenied(seg)
+			}
+			return sum, denied
 		}
 		t = t.Tree[seg]
 		sum ^= t.Privilege
+		denied |= t.Denied
 	}
-
-	return t.compatibleWithReadWrite(sum)&privilege == privilege
+	if resource.Regex != nil {
+		if p := t.Patterns[resource.Regex.String()]; p != nil {
+			sum ^= p.Privilege
+			denied |= p.Denied
+		}
+	}
+	return sum, denied
 }
 
-// Contains checks if the privilege set contains all privileges from another set.
+// Contains checks if the privilege set contains all privileges from another
+// set, that is nothing left after deleting privileges of t from a copy of s.
 func (t *PrivilegeTree) Contains(s PrivilegeSet) bool {
-	s.(*PrivilegeTree).sub(NoPrivilege, NoPrivilege, NoPrivilege, t, true)
-	return s.Powerless()
+	other := copyTreeOf(s)
+	other.sub(NoPrivilege, NoPrivilege, NoPrivilege, NoPrivilege, nil, nil, nil, t, true)
+	return other.Powerless()
+}
+
+// Equal checks if 2 privilege sets contain each other and deny the same
+// privileges. Denies are compared even if they deny nothing granted, as they
+// still take effect on privileges added later.
+func (t *PrivilegeTree) Equal(s PrivilegeSet) bool {
+	other := treeOf(s)
+	return t.Contains(other) && other.Contains(t) && t.sameDenied(other, NoPrivilege, NoPrivilege)
+}
+
+// sameDenied checks if privileges denied on every resource of t and s are the
+// same, tdenied and sdenied are privileges denied on their parents.
+func (t *PrivilegeTree) sameDenied(s *PrivilegeTree, tdenied, sdenied Privilege) bool {
+	tdenied |= t.Denied
+	sdenied |= s.Denied
+	if tdenied != sdenied {
+		return false
+	}
+
+	for k, v := range t.Tree {
+		if v == nil {
+			continue
+		}
+		c := s.Tree[k]
+		if c == nil {
+			c = s.virtualChild(k, NoPrivilege, nil)
+		}
+		if !v.sameDenied(c, tdenied, sdenied) {
+			return false
+		}
+	}
+	for k, v := range s.Tree {
+		if v != nil && t.Tree[k] == nil {
+			if !t.virtualChild(k, NoPrivilege, nil).sameDenied(v, tdenied, sdenied) {
+				return false
+			}
+		}
+	}
+	for k, v := range t.Patterns {
+		if v == nil {
+			continue
+		}
+		c := s.Patterns[k]
+		if c == nil {
+			c = NewPrivilegeTree()
+		}
+		if !v.sameDenied(c, tdenied, sdenied) {
+			return false
+		}
+	}
+	for k, v := range s.Patterns {
+		if v != nil && t.Patterns[k] == nil {
+			if !NewPrivilegeTree().sameDenied(v, tdenied, sdenied) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Clone returns a deep copy of the privilege tree.
+func (t *PrivilegeTree) Clone() PrivilegeSet {
+	return t.clone()
+}
+
+// clone makes a deep copy of the privilege tree.
+func (t *PrivilegeTree) clone() *PrivilegeTree {
+	c := &PrivilegeTree{Privilege: t.Privilege, Denied: t.Denied, Tree: make(map[string]*PrivilegeTree, len(t.Tree)), Regex: t.Regex}
+	if t.Conditions != nil {
+		// conditional privileges are never modified in place, so they are shared
+		c.Conditions = make(map[string]*ConditionalPrivilege, len(t.Conditions))
+		for k, v := range t.Conditions {
+			c.Conditions[k] = v
+		}
+	}
+	for k, v := range t.Tree {
+		if v != nil {
+			c.Tree[k] = v.clone()
+		}
+	}
+	if t.Patterns != nil {
+		c.Patterns = make(map[string]*PrivilegeTree, len(t.Patterns))
+		for k, v := range t.Patterns {
+			if v != nil {
+				c.Patterns[k] = v.clone()
+			}
+		}
+	}
+	return c
 }
 
 // tidy free useless memory.
 func (t *PrivilegeTree) prune() {
-	if t.Powerless() {
+	if t.redundant() {
 		t.ClearAll()
 		return
 	}
@@ -389,6 +764,91 @@ func (t *PrivilegeTree) prune() {
 			v.prune()
 		}
 	}
+	for _, v := range t.Patterns {
+		if v != nil {
+			v.prune()
+		}
+	}
+}
+
+// Normalize rewrites the tree into a canonical minimal form granting and
+// denying the same privileges. Nil children are removed, so are leaf children
+// which are the same as they would be if not presented, denies already given
+// by parents, conditional privileges already granted unconditionally and
+// conditions the same as those inherited from parents.
+// Patterns changing nothing are removed as well, so trees which are Equal and
+// have the same patterns have the same String once normalized.
+func (t *PrivilegeTree) Normalize() {
+	t.normalize(NoPrivilege, NoPrivilege, nil)
+}
+
+// normalize normalizes the node, sum, denied and conditions are privileges
+// granted, denied and granted under conditions on its parent.
+func (t *PrivilegeTree) normalize(sum, denied Privilege, conditions map[string]*ConditionalPrivilege) {
+	t.Denied &^= denied
+	sum ^= t.Privilege
+	denied |= t.Denied
+
+	for k, c := range t.Conditions {
+		left := c.Privilege &^ sum
+		if left == conditionPrivilege(conditions, k)&^sum {
+			delete(t.Conditions, k)
+		} else if left != c.Privilege {
+			t.Conditions[k] = &ConditionalPrivilege{Condition: c.Condition, Privilege: left}
+		}
+	}
+	if len(t.Conditions) == 0 {
+		t.Conditions = nil
+	}
+	conditions = t.inheritConditions(conditions)
+
+	for k, v := range t.Patterns {
+		if v != nil {
+			v.normalize(sum, denied, conditions)
+		}
+		if v == nil || v.redundant() {
+			delete(t.Patterns, k)
+		}
+	}
+	if len(t.Patterns) == 0 {
+		t.Patterns = nil
+	}
+
+	// patterns are normalized first, as literal children are compared with
+	// what patterns would give them
+	if t.Tree == nil {
+		t.Tree = make(map[string]*PrivilegeTree)
+	}
+	for k, v := range t.Tree {
+		if v == nil {
+			delete(t.Tree, k)
+			continue
+		}
+		v.normalize(sum, denied, conditions)
+		if len(v.Tree) == 0 && len(v.Patterns) == 0 && v.sameAs(t.virtualChild(k, sum, conditions)) {
+			delete(t.Tree, k)
+		}
+	}
+}
+
+// sameAs checks if 2 nodes have the same privileges, denied privileges and
+// conditional privileges, children are not compared.
+func (t *PrivilegeTree) sameAs(o *PrivilegeTree) bool {
+	if t.Privilege != o.Privilege || t.Denied != o.Denied || len(t.Conditions) != len(o.Conditions) {
+		return false
+	}
+	for k, c := range t.Conditions {
+		if oc := o.Conditions[k]; oc == nil || oc.Privilege != c.Privilege {
+			return false
+		}
+	}
+	return true
+}
+
+// effective returns privileges granted by sum with denied privileges excluded,
+// a denied READ or WRITE does not grant its legacy group.
+func (t *PrivilegeTree) effective(sum, denied Privilege) Privilege {
+	return t.compatibleWithReadWrite(sum&^denied) &^ denied
 }
 
 // read privilege or write privilege in old version equals a group of privileges
@@ -403,17 +863,54 @@ func (t *PrivilegeTree) compatibleWithReadWrite(privilege Privilege) Privilege {
 	return privilege
 }
 
-// if PrivilegeTree don't has any privilege
+// if PrivilegeTree don't has any privilege, privileges granted but denied are
+// not counted.
 func (t *PrivilegeTree) Powerless() bool {
-	if t.Privilege != NoPrivilege {
+	return t.powerless(NoPrivilege, NoPrivilege)
+}
+
+func (t *PrivilegeTree) powerless(sum, denied Privilege) bool {
+	sum ^= t.Privilege
+	denied |= t.Denied
+	if sum&^denied != NoPrivilege {
 		return false
 	}
+	for _, c := range t.Conditions {
+		if c.Privilege&^denied != NoPrivilege {
+			return false
+		}
+	}
 
 	for _, v := range t.Tree {
-		if v != nil {
-			if !v.Powerless() {
-				return false
-			}
+		if v != nil && !v.powerless(sum, denied) {
+			return false
+		}
+	}
+	for _, v := range t.Patterns {
+		if v != nil && !v.powerless(sum, denied) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// redundant checks if the node and all nodes under it neither change inherited
+// privileges, nor deny or conditionally grant any privilege, so that they can
+// be removed.
+func (t *PrivilegeTree) redundant() bool {
+	if t.Privilege != NoPrivilege || t.Denied != NoPrivilege || len(t.Conditions) > 0 {
+		return false
+	}
+
+	for _, v := range t.Tree {
+		if v != nil && !v.redundant() {
+			return false
+		}
+	}
+	for _, v := range t.Patterns {
+		if v != nil && !v.redundant() {
+			return false
 		}
 	}
 
@@ -422,44 +919,59 @@ func (t *PrivilegeTree) Powerless() bool {
 
 /*
 String serialize PrivilegeTree to string, use BFS strategy.
-One node can be serialized as (name, privilege), an empty node can be
-serialized as ().
+One node can be serialized as (name,privilege), where name is quoted if it is
+not a plain identifier, e.g. ("",1) for the root, and privilege is the delta
+from its parent kept by the node. An empty node can be serialized as ().
 All children of a node can be bracketed in [], and all nodes of one floor can
-be bracketed in {}
+be bracketed in {}, children are sorted by name so that the same tree is
+always serialized to the same string.
+A pattern node is serialized as (/regex/,privilege), after all literal nodes
+of the same parent sorted by regex. Denied privileges of a node follow its privilege if any,
+e.g. (daily,0,8), then conditional privileges follow sorted by condition,
+e.g. (cpu,2,WHERE host = 'a':16).
 
 Example, a tree as follow:
 
-        ┌- (yourdb, 3)
-        |
-(, 1) --|              ┌- (daily, 5)
-		|              |
-		└- (mydb, 2) --|                 ┌- (mem, 7)
-					   |                 |
-					   └- (autogen, 4) --|
-										 |
-										 └- (cpu, 6)
+           ┌- (yourdb,8)
+           |
+("",1) ----|                 ┌- (daily,0,8)
+           |                 |
+           └- (mydb,2) ------|                   ┌- (mem,32)
+                             |                   |
+                             └- (autogen,4) -----|
+                                                 |
+                                                 └- (cpu,2,WHERE host = 'a':16)
 will be serialized as:
-{[(, 1)]} {[(yourdb, 3)(mydb, 2)]} {[][(daily, 5)(autogen, 4)]} {[][(mem, 7)(cpu, 6)]}
+{[("",1)]}{[(mydb,2)(yourdb,8)]}{[(autogen,4)(daily,0,8)][]}{[(cpu,2,WHERE host = 'a':16)(mem,32)][]}{[][]}
+
+Trees granting the same privileges may still be serialized differently unless
+they are normalized by Normalize.
 */
 
 func (t *PrivilegeTree) String() string {
 	buf := &bytes.Buffer{}
 
-	floor := []map[string]*PrivilegeTree{{"": t}}
+	floor := []*PrivilegeTree{{Tree: map[string]*PrivilegeTree{"": t}}}
 	for len(floor) > 0 {
-		newFloor := make([]map[string]*PrivilegeTree, 0)
+		newFloor := make([]*PrivilegeTree, 0)
 
 		buf.WriteString("{")
 		for _, f := range floor {
 			buf.WriteString("[")
-			for k, v := range f {
-				if v != nil {
-					buf.WriteString(fmt.Sprintf("(%s,%d)", QuoteIdent(k), v.Privilege))
-					newFloor = append(newFloor, v.Tree)
+			for _, k := range sortedKeys(f.Tree) {
+				if v := f.Tree[k]; v != nil {
+					buf.WriteString(fmt.Sprintf("(%s,%s)", QuoteIdent(k), v.privilegeString()))
+					newFloor = append(newFloor, v)
 				} else {
 					buf.WriteString("()")
 				}
 			}
+			for _, k := range sortedKeys(f.Patterns) {
+				if v := f.Patterns[k]; v != nil {
+					buf.WriteString(fmt.Sprintf("(%s,%s)", QuoteRegex(v.Regex), v.privilegeString()))
+					newFloor = append(newFloor, v)
+				}
+			}
 			buf.WriteString("]")
 		}
 		buf.WriteString("}")
@@ -470,54 +982,210 @@ func (t *PrivilegeTree) String() string {
 	return buf.String()
 }
 
-// LoadPrivilegeTree unserialize PrivilegeTree from string.
-// Uncomplete version, can not handle situation which character '{}[]()' contained
-// in node name.
-func LoadPrivilegeTree(s string) (*PrivilegeTree, error) {
-	s = strings.TrimSpace(s)
-	runes := []rune(s)
+// sortedKeys returns keys of children in sorted order.
+func sortedKeys(children map[string]*PrivilegeTree) []string {
+	keys := make([]string, 0, len(children))
+	for k := range children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-	floors, err := parseSections(runes, '{', '}')
-	if err != nil {
-		return nil, err
+// privilegeString formats privilege, denied privilege and conditional
+// privileges of a node for String.
+func (t *PrivilegeTree) privilegeString() string {
+	s := strconv.Itoa(int(t.Privilege))
+	if t.Denied != NoPrivilege {
+		s = fmt.Sprintf("%d,%d", t.Privilege, t.Denied)
+	}
+	for _, c := range t.sortedConditions() {
+		s += fmt.Sprintf(",%s %s:%d", WHERE, c.Condition, c.Privilege)
 	}
+	return s
+}
 
-	for _, floor := range floors {
-		children, err := parseSections(floor, '[', ']')
+// LoadPrivilegeTree unserialize PrivilegeTree from string produced by String.
+// Node names are scanned as fql identifiers, so names quoted by QuoteIdent may
+// contain any character including '{}[](),'.
+func LoadPrivilegeTree(s string) (*PrivilegeTree, error) {
+	p := NewParser(strings.NewReader(s))
+
+	// root floor is parsed as children of a virtual holder node
+	var root *PrivilegeTree
+	parents := []*PrivilegeTree{NewPrivilegeTree()}
+	for {
+		tok, pos, lit := p.ScanIgnoreWhitespace()
+		if tok == EOF && len(parents) == 0 {
+			break
+		} else if !isDelimiter(tok, lit, '{') {
+			return nil, newParseError(tokstr(tok, lit), []string{"{"}, pos)
+		}
+
+		children, err := parseFloor(p, parents)
 		if err != nil {
 			return nil, err
 		}
-		for _, f := range children {
-			fmt.Println(string(f))
+
+		if root == nil {
+			if len(children) != 1 {
+				return nil, &ParseError{Message: "expect exactly one root node", Pos: pos}
+			}
+			root = children[0]
 		}
+		parents = children
 	}
 
-	return nil, nil
+	return root, nil
 }
 
-func parseSections(s []rune, start, end rune) ([][]rune, error) {
-	var sections [][]rune
-
-	len := len(s)
-	var left int
-	for left < len-1 {
-		if s[left] != start {
-			left++
-			continue
+// parseFloor parses all nodes of one floor, nodes in the i-th [] are children
+// of the i-th parent. Returns non nil nodes in the order they present.
+// This function assumes the '{' has already been consumed.
+func parseFloor(p *Parser, parents []*PrivilegeTree) ([]*PrivilegeTree, error) {
+	var children []*PrivilegeTree
+	for _, parent := range parents {
+		if tok, pos, lit := p.ScanIgnoreWhitespace(); !isDelimiter(tok, lit, '[') {
+			return nil, newParseError(tokstr(tok, lit), []string{"["}, pos)
 		}
-		var right int
-		for right = left + 1; right < len; right++ {
-			if s[right] == end {
+
+		for {
+			tok, pos, lit := p.ScanIgnoreWhitespace()
+			if isDelimiter(tok, lit, ']') {
 				break
+			} else if tok != LPAREN {
+				return nil, newParseError(tokstr(tok, lit), []string{"(", "]"}, pos)
 			}
+
+			name, child, err := parseNode(p)
+			if err != nil {
+				return nil, err
+			} else if child == nil {
+				continue
+			}
+
+			nodes := parent.Tree
+			if child.Regex != nil {
+				if parent.Patterns == nil {
+					parent.Patterns = make(map[string]*PrivilegeTree)
+				}
+				nodes = parent.Patterns
+			}
+			if _, ok := nodes[name]; ok {
+				msg := fmt.Sprintf("duplicate node %s", QuoteIdent(name))
+				if child.Regex != nil {
+					msg = fmt.Sprintf("duplicate node %s", QuoteRegex(child.Regex))
+				}
+				return nil, &ParseError{Message: msg, Pos: pos}
+			}
+			nodes[name] = child
+			children = append(children, child)
 		}
-		if right >= len {
-			return nil, errors.New("")
+	}
+
+	if tok, pos, lit := p.ScanIgnoreWhitespace(); !isDelimiter(tok, lit, '}') {
+		return nil, newParseError(tokstr(tok, lit), []string{"}"}, pos)
+	}
+	return children, nil
+}
+
+// parseNode parses (name,privilege) or () which stands for a nil node,
+// denied privilege may follow privilege as (name,privilege,denied), and
+// conditional privileges may follow them as (name,privilege,WHERE expr:privilege).
+// A pattern node is parsed as (/regex/,privilege), and named by its regex.
+// This function assumes the '(' has already been consumed.
+func parseNode(p *Parser) (string, *PrivilegeTree, error) {
+	var regex *regexp.Regexp
+	if p.peekRune() == '/' {
+		tok, pos, lit := p.ScanRegex()
+		if tok != REGEX {
+			return "", nil, newParseError(tokstr(tok, lit), []string{"regex"}, pos)
+		}
+		var err error
+		if regex, err = regexp.Compile(lit); err != nil {
+			return "", nil, &ParseError{Message: err.Error(), Pos: pos}
+		}
+		if err := p.parseTokens([]Token{COMMA}); err != nil {
+			return "", nil, err
+		}
+	} else if tok, _, _ := p.ScanIgnoreWhitespace(); tok == RPAREN {
+		return "", nil, nil
+	} else {
+		p.Unscan()
+	}
+
+	// name of root node may be left empty without quotes
+	var name string
+	if regex != nil {
+		name = regex.String()
+	} else if tok, _, _ := p.ScanIgnoreWhitespace(); tok != COMMA {
+		p.Unscan()
+
+		var err error
+		if name, err = p.ParseIdent(); err != nil {
+			return "", nil, err
+		}
+		if err := p.parseTokens([]Token{COMMA}); err != nil {
+			return "", nil, err
+		}
+	}
+
+	child := NewPrivilegeTree()
+	child.Regex = regex
+	var err error
+	if child.Privilege, err = parseNodePrivilege(p); err != nil {
+		return "", nil, err
+	}
+	for i := 0; ; i++ {
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok != COMMA {
+			p.Unscan()
+			break
 		}
+		if tok, _, _ := p.ScanIgnoreWhitespace(); tok != WHERE && i == 0 {
+			p.Unscan()
+			if child.Denied, err = parseNodePrivilege(p); err != nil {
+				return "", nil, err
+			}
+			continue
+		} else if tok != WHERE {
+			p.Unscan()
+			tok, pos, lit := p.ScanIgnoreWhitespace()
+			return "", nil, newParseError(tokstr(tok, lit), []string{"WHERE"}, pos)
+		}
+
+		condition, err := p.ParseExpr()
+		if err != nil {
+			return "", nil, err
+		}
+		if err := p.parseTokens([]Token{COLON}); err != nil {
+			return "", nil, err
+		}
+		privilege, err := parseNodePrivilege(p)
+		if err != nil {
+			return "", nil, err
+		}
+		child.addCondition(condition, privilege)
+	}
+
+	if err := p.parseTokens([]Token{RPAREN}); err != nil {
+		return "", nil, err
+	}
+	return name, child, nil
+}
 
-		sections = append(sections, s[left+1:right])
-		left = right + 1
+func parseNodePrivilege(p *Parser) (Privilege, error) {
+	tok, pos, lit := p.ScanIgnoreWhitespace()
+	if tok != INTEGER {
+		return NoPrivilege, newParseError(tokstr(tok, lit), []string{"integer"}, pos)
+	}
+	n, err := strconv.ParseInt(lit, 10, 64)
+	if err != nil {
+		return NoPrivilege, &ParseError{Message: err.Error(), Pos: pos}
 	}
+	return Privilege(n), nil
+}
 
-	return sections, nil
+// isDelimiter checks if token is the given character which is not a fql token.
+func isDelimiter(tok Token, lit string, ch rune) bool {
+	return tok == ILLEGAL && lit == string(ch)
 }
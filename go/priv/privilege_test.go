@@ -1,7 +1,11 @@
 package priv_test
 
 import (
+	"math/rand"
+	"reflect"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/musenwill/exercise/priv"
 )
@@ -41,6 +45,25 @@ func TestPrivilegeOf(t *testing.T) {
 	}
 }
 
+func TestPrivilegeOfSuggestion(t *testing.T) {
+	var tests = []struct {
+		s string
+		e string
+	}{
+		{s: "selct", e: "unknown privilege 'selct' (did you mean SELECT?)"},
+		{s: "all privilege", e: "unknown privilege 'all privilege' (did you mean ALL PRIVILEGES?)"},
+		{s: "create  qc", e: "unknown privilege 'create  qc' (did you mean CREATE CQ?)"},
+		{s: "whatever", e: "unknown privilege 'whatever'"},
+	}
+
+	for _, test := range tests {
+		_, err := priv.PrivilegeOf(test.s)
+		if err == nil || err.Error() != test.e {
+			t.Fatalf("privilege of %s got error '%v' expect '%s'", test.s, err, test.e)
+		}
+	}
+}
+
 func TestPrivilegeGroup(t *testing.T) {
 	var tests = []struct {
 		privilege priv.Privilege
@@ -137,6 +160,23 @@ func BenchmarkPrivilegeContainShallowPath(b *testing.B) {
 	}
 }
 
+// 8   	 3295568	       372.4 ns/op	       0 B/op	       0 allocs/op
+func BenchmarkPrivilegeContainPattern(b *testing.B) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(priv.GrantPrivilege | priv.InsertPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.DeletePrivilege|priv.DropPrivilege|priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^cpu_/"), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^mem_/"), priv.SelectPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen./_total$/"), priv.SelectPrivilege)
+
+	resource := priv.CreateResourcePathUnsafe("mydb.autogen.cpu_system")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		set.Contain(resource, priv.SelectPrivilege)
+	}
+}
+
 // 8   	 1000000	      1053 ns/op	       0 B/op	       0 allocs/op
 func BenchmarkPrivilegeContains(b *testing.B) {
 	setA := priv.NewPrivilegeTree()
@@ -339,6 +379,43 @@ func TestResourcePathErr(t *testing.T) {
 	}
 }
 
+func TestQuoteRegex(t *testing.T) {
+	var tests = []struct {
+		regex string
+		s     string
+	}{
+		{regex: `^cpu`, s: `/^cpu/`},
+		{regex: `a/b`, s: `/a\/b/`},
+		{regex: `a\/b`, s: `/a\/b/`},
+		{regex: `a\\/b`, s: `/a\\\/b/`},
+	}
+	for _, test := range tests {
+		regex := regexp.MustCompile(test.regex)
+		if act := priv.QuoteRegex(regex); act != test.s {
+			t.Fatalf("quote regex %s got %s expect %s", test.regex, act, test.s)
+		}
+
+		resource := priv.NewRegexResourcePath(regex, "mydb", "autogen")
+		parsed, err := priv.CreateResourcePath(resource.String())
+		if err != nil {
+			t.Fatalf("resource path %s got error '%v'", resource, err)
+		}
+		if parsed.String() != resource.String() || parsed.Regex.MatchString("a/b") != regex.MatchString("a/b") {
+			t.Fatalf("resource path %s got %s", resource, parsed)
+		}
+
+		set := priv.NewPrivilegeTree()
+		set.Add(resource, priv.SelectPrivilege)
+		loaded, err := priv.LoadPrivilegeTree(set.String())
+		if err != nil {
+			t.Fatalf("load privilege tree %s got error '%v'", set, err)
+		}
+		if loaded.String() != set.String() {
+			t.Fatalf("load privilege tree %s got %s", set, loaded)
+		}
+	}
+}
+
 func TestPrivilegeSetAddDelete(t *testing.T) {
 	set := priv.NewPrivilegeTree()
 	set.AddGlobal(priv.GrantPrivilege | priv.InsertPrivilege)
@@ -884,6 +961,236 @@ func TestReadWritecompatibility(t *testing.T) {
 	runCases(t, setA, tests)
 }
 
+func TestPrivilegePattern(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu_total"), priv.DropPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^cpu_/"), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen./_total$/"), priv.DeletePrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen.cpu_user"), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.InsertPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen.*"), priv.InsertPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.mem"), priv.InsertPrivilege)
+
+	runCases(t, set, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		// patterns apply to measurements not granted explicitly
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu_system"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.disk"), p: priv.SelectPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.daily.cpu_system"), p: priv.SelectPrivilege, t: false},
+		// all matching patterns are combined
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu_idle_total"), p: priv.SelectPrivilege | priv.DeletePrivilege, t: true},
+		// patterns also apply to existing measurements they match
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu_total"), p: priv.SelectPrivilege | priv.DeletePrivilege | priv.DropPrivilege, t: true},
+		// explicit grant on measurement takes precedence over patterns
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu_user"), p: priv.SelectPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.mem"), p: priv.InsertPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.disk"), p: priv.InsertPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen"), p: priv.InsertPrivilege, t: true},
+		// regex resource refers to the pattern itself
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen./^cpu_/"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen./_total$/"), p: priv.SelectPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.*"), p: priv.InsertPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen./^mem/"), p: priv.InsertPrivilege, t: true},
+	})
+
+	// grant on parent overrides patterns under it
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.SelectPrivilege)
+	if !set.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.cpu_user"), priv.SelectPrivilege) ||
+		!set.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.disk"), priv.SelectPrivilege) {
+		t.Fatalf("expect privilege SELECT on all measurements of mydb.autogen")
+	}
+
+	loaded, err := priv.LoadPrivilegeTree(set.String())
+	if err != nil {
+		t.Fatalf("load privilege tree %s got error '%v'", set, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("load privilege tree %s got %s", set, loaded)
+	}
+}
+
+func TestPrivilegePatternUnion(t *testing.T) {
+	setA := priv.NewPrivilegeTree()
+	setA.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.DeletePrivilege)
+
+	setB := priv.NewPrivilegeTree()
+	setB.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^cpu|^mem/"), priv.SelectPrivilege)
+
+	setA.UnionWith(setB)
+	runCases(t, setA, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), p: priv.SelectPrivilege | priv.DeletePrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.mem"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.disk"), p: priv.SelectPrivilege, t: false},
+	})
+	setA.DifferentWith(setB)
+	runCases(t, setA, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), p: priv.SelectPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), p: priv.DeletePrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.mem"), p: priv.SelectPrivilege, t: false},
+	})
+}
+
+func TestPrivilegePatternChangingNothing(t *testing.T) {
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+	revoke := priv.NewPrivilegeTree()
+	revoke.Add(priv.CreateResourcePathUnsafe("mydb.autogen./u/"), priv.InsertPrivilege)
+
+	// a pattern granting what is inherited matches nothing, whether pruned or
+	// not, so cpu only matches /u/ of which INSERT is deleted
+	pruned := priv.NewPrivilegeTree()
+	pruned.AddGlobal(priv.InsertPrivilege)
+	pruned.Undeny(priv.CreateResourcePathUnsafe("mydb.autogen./^c/"), priv.ReadPrivilege)
+	kept := pruned.Clone().(*priv.PrivilegeTree)
+	kept.Add(priv.CreateResourcePathUnsafe("mydb.autogen.mem"), priv.SelectPrivilege)
+	kept.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^c/"), priv.InsertPrivilege)
+	for _, set := range []*priv.PrivilegeTree{pruned, kept} {
+		set.DifferentWith(revoke)
+		if set.Contain(cpu, priv.InsertPrivilege) {
+			t.Fatalf("different with %s got %s", revoke, set)
+		}
+	}
+}
+
+func TestPrivilegeDeny(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.Deny(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.SelectPrivilege)
+	set.Deny(priv.CreateResourcePathUnsafe("mydb.autogen./^secret_/"), priv.InsertPrivilege)
+	set.Deny(priv.CreateResourcePathUnsafe("yourdb"), priv.ReadPrivilege)
+	set.Deny(priv.CreateResourcePathUnsafe(""), priv.AuditPrivilege)
+
+	// grants before or after the deny, on parents, the same node or children
+	// never override it
+	set.AddGlobal(priv.SelectPrivilege | priv.InsertPrivilege | priv.ReadPrivilege | priv.AuditPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.secret_key"), priv.InsertPrivilege)
+	set.AddGlobal(priv.SelectPrivilege)
+
+	runCases(t, set, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		{r: priv.CreateResourcePathUnsafe("global"), p: priv.SelectPrivilege | priv.InsertPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("global"), p: priv.AuditPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.daily.cpu"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen"), p: priv.SelectPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), p: priv.SelectPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), p: priv.InsertPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.secret_key"), p: priv.InsertPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.secret_token"), p: priv.InsertPrivilege, t: false},
+		// denied READ does not grant its legacy group, other grants still work
+		{r: priv.CreateResourcePathUnsafe("yourdb.autogen.cpu"), p: priv.ReadPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("yourdb.autogen.cpu"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("yourdb.autogen.cpu"), p: priv.CreateCQPrivilege, t: false},
+	})
+
+	// deleting privileges does not remove denies
+	set.DeleteGlobal(priv.AllGlobalPrivileges)
+	set.AddGlobal(priv.SelectPrivilege)
+	if set.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege) {
+		t.Fatalf("expect deny survives delete, got %s", set)
+	}
+
+	loaded, err := priv.LoadPrivilegeTree(set.String())
+	if err != nil {
+		t.Fatalf("load privilege tree %s got error '%v'", set, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("load privilege tree %s got %s", set, loaded)
+	}
+
+	// undeny on children does not affect denies of parents
+	set.Undeny(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege)
+	if set.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege) {
+		t.Fatalf("expect deny on parent still works, got %s", set)
+	}
+	set.Undeny(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege|priv.InsertPrivilege)
+	if !set.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.SelectPrivilege) ||
+		!set.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.secret_key"), priv.SelectPrivilege) {
+		t.Fatalf("expect denies removed, got %s", set)
+	}
+}
+
+func TestPrivilegeDenySetOperations(t *testing.T) {
+	// Contains modifies its argument, so every check gets a new set
+	newAllow := func() *priv.PrivilegeTree {
+		set := priv.NewPrivilegeTree()
+		set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege|priv.InsertPrivilege)
+		return set
+	}
+	newDeny := func() *priv.PrivilegeTree {
+		set := priv.NewPrivilegeTree()
+		set.Deny(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.SelectPrivilege)
+		return set
+	}
+	allow, deny := newAllow(), newDeny()
+	if !deny.Powerless() {
+		t.Fatalf("expect set with only denies powerless")
+	}
+	if !allow.Contains(newDeny()) {
+		t.Fatalf("expect any set contains set with only denies")
+	}
+
+	// deny from one set beats allow from another
+	union := priv.NewPrivilegeTree()
+	union.UnionWith(allow)
+	union.UnionWith(deny)
+	runCases(t, union, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.secret"), p: priv.SelectPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.secret"), p: priv.InsertPrivilege, t: true},
+	})
+	if union.Contains(newAllow()) {
+		t.Fatalf("expect %s not contains %s", union, allow)
+	}
+	other := priv.NewPrivilegeTree()
+	other.UnionWith(union)
+	if !allow.Contains(other) {
+		t.Fatalf("expect %s contains %s", allow, union)
+	}
+
+	// denied privileges are not subtracted, denies of the set are kept
+	diff := priv.NewPrivilegeTree()
+	diff.UnionWith(union)
+	diff.DifferentWith(allow)
+	if !diff.Powerless() {
+		t.Fatalf("expect %s powerless", diff)
+	}
+	diff.AddGlobal(priv.SelectPrivilege)
+	if diff.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.SelectPrivilege) {
+		t.Fatalf("expect deny kept after different, got %s", diff)
+	}
+
+	secret := priv.NewPrivilegeTree()
+	secret.Add(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.SelectPrivilege|priv.InsertPrivilege)
+	secret.DifferentWith(union)
+	runCases(t, secret, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.secret"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.secret"), p: priv.InsertPrivilege, t: false},
+	})
+}
+
 func runCases(t *testing.T, set priv.PrivilegeSet, tests []struct {
 	r *priv.ResourcePath
 	p priv.Privilege
@@ -920,3 +1227,486 @@ func compare(a, b []string) bool {
 
 	return true
 }
+
+func TestPrivilegeTreeClone(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		a := randomPrivilegeTree(r)
+		b := a.Clone().(*priv.PrivilegeTree)
+		if !reflect.DeepEqual(a, b) || !a.Equal(b) {
+			t.Fatalf("clone %s got %s", a, b)
+		}
+
+		before := a.Clone()
+		b.SetAll()
+		if !reflect.DeepEqual(a, before) {
+			t.Fatalf("tree %s changed by its clone to %s", before, a)
+		}
+	}
+}
+
+func TestPrivilegeTreeEqualDenied(t *testing.T) {
+	empty := priv.NewPrivilegeTree()
+	denied := priv.NewPrivilegeTree()
+	denied.Deny(priv.CreateResourcePathUnsafe(""), priv.SelectPrivilege)
+
+	// a deny granting nothing yet still takes effect on privileges added later
+	if denied.Equal(empty) || empty.Equal(denied) {
+		t.Fatalf("%s equals %s", denied, empty)
+	}
+	denied.Normalize()
+	empty.Normalize()
+	if denied.String() == empty.String() {
+		t.Fatalf("normalize %s got the same string as %s", denied, empty)
+	}
+
+	// denies given by parents are the same as denies on children
+	child := priv.NewPrivilegeTree()
+	child.Deny(priv.CreateResourcePathUnsafe(""), priv.SelectPrivilege)
+	child.Deny(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.SelectPrivilege)
+	pattern := priv.NewPrivilegeTree()
+	pattern.Deny(priv.CreateResourcePathUnsafe(""), priv.SelectPrivilege)
+	pattern.Deny(priv.NewRegexResourcePath(regexp.MustCompile("^c"), "mydb", "autogen"), priv.SelectPrivilege)
+	if !child.Equal(denied) || !denied.Equal(child) || !pattern.Equal(denied) || !denied.Equal(pattern) {
+		t.Fatalf("%s, %s and %s are not equal", denied, child, pattern)
+	}
+
+	pattern.Deny(priv.NewRegexResourcePath(regexp.MustCompile("^c"), "mydb", "autogen"), priv.InsertPrivilege)
+	if pattern.Equal(denied) || denied.Equal(pattern) {
+		t.Fatalf("%s equals %s", pattern, denied)
+	}
+	for _, set := range []priv.PrivilegeSet{priv.NewConcurrentPrivilegeSet(), priv.NewExpiringPrivilegeSet(nil)} {
+		set.UnionWith(denied)
+		if !set.Equal(denied) || set.Equal(empty) {
+			t.Fatalf("union of %s got %s", denied, set)
+		}
+	}
+}
+
+func TestPrivilegeTreeContainsSideEffectFree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a, b := randomPrivilegeTree(r), randomPrivilegeTree(r)
+		a0, b0 := a.Clone(), b.Clone()
+		a.Contains(b)
+		a.Equal(b)
+		if !reflect.DeepEqual(a, a0) || !reflect.DeepEqual(b, b0) {
+			t.Fatalf("%s contains %s changed trees to %s and %s", a0, b0, a, b)
+		}
+	}
+}
+
+func TestPrivilegeTreeSetAlgebra(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	union := func(a, b priv.PrivilegeSet) priv.PrivilegeSet { c := a.Clone(); c.UnionWith(b); return c }
+	intersect := func(a, b priv.PrivilegeSet) priv.PrivilegeSet { c := a.Clone(); c.IntersectWith(b); return c }
+	diff := func(a, b priv.PrivilegeSet) priv.PrivilegeSet { c := a.Clone(); c.DifferentWith(b); return c }
+	symdiff := func(a, b priv.PrivilegeSet) priv.PrivilegeSet { c := a.Clone(); c.SymmetricDifference(b); return c }
+	subset := func(a, b priv.PrivilegeSet) bool { return b.Contains(a) }
+
+	// laws holding for all trees
+	for i := 0; i < 500; i++ {
+		a, b := randomPrivilegeTree(r), randomPrivilegeTree(r)
+		var laws = []struct {
+			name string
+			ok   bool
+		}{
+			{name: "A = A", ok: a.Equal(a)},
+			{name: "A ⊆ A", ok: subset(a, a)},
+			{name: "A∩B ⊆ A", ok: subset(intersect(a, b), a)},
+			{name: "A∩B ⊆ B", ok: subset(intersect(a, b), b)},
+			{name: "A∩A = A", ok: intersect(a, a).Equal(a)},
+			{name: "A−B ⊆ A", ok: subset(diff(a, b), a)},
+			{name: "(A−B)∩B = ∅", ok: intersect(diff(a, b), b).Powerless()},
+			{name: "A−A = ∅", ok: diff(a, a).Powerless()},
+			{name: "AΔA = ∅", ok: symdiff(a, a).Powerless()},
+			{name: "AΔB = BΔA", ok: symdiff(a, b).Equal(symdiff(b, a))},
+			{name: "AΔB = (A∪B)−(A∩B)", ok: symdiff(a, b).Equal(diff(union(a, b), intersect(a, b)))},
+			{name: "A = B implies A ⊆ B and B ⊆ A", ok: !a.Equal(b) || (subset(a, b) && subset(b, a))},
+		}
+		for _, law := range laws {
+			if !law.ok {
+				t.Fatalf("%s does not hold for A = %s, B = %s", law.name, a, b)
+			}
+		}
+	}
+
+	// laws holding for trees without deny, as union keeps denies of both sets
+	// and intersection keeps denies of the left set
+	for i := 0; i < 500; i++ {
+		a, b, c := randomGrantTree(r), randomGrantTree(r), randomGrantTree(r)
+		var laws = []struct {
+			name string
+			ok   bool
+		}{
+			{name: "A ⊆ A∪B", ok: subset(a, union(a, b))},
+			{name: "B ⊆ A∪B", ok: subset(b, union(a, b))},
+			{name: "A∪B = B∪A", ok: union(a, b).Equal(union(b, a))},
+			{name: "A∩B = B∩A", ok: intersect(a, b).Equal(intersect(b, a))},
+			{name: "A = B iff A ⊆ B and B ⊆ A", ok: a.Equal(b) == (subset(a, b) && subset(b, a))},
+			{name: "(A−B)∪(A∩B) = A", ok: union(diff(a, b), intersect(a, b)).Equal(a)},
+			{name: "A∩(B∪C) ⊆ (A∩B)∪(A∩C)", ok: subset(intersect(a, union(b, c)), union(intersect(a, b), intersect(a, c)))},
+			{name: "A ⊆ B iff A∩B = A", ok: subset(a, b) == intersect(a, b).Equal(a)},
+		}
+		for _, law := range laws {
+			if !law.ok {
+				t.Fatalf("%s does not hold for A = %s, B = %s, C = %s", law.name, a, b, c)
+			}
+		}
+	}
+}
+
+func TestPrivilegeSetAlgebraImplementations(t *testing.T) {
+	a := priv.NewPrivilegeTree()
+	a.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege|priv.InsertPrivilege)
+	b := priv.NewPrivilegeTree()
+	b.Add(priv.CreateResourcePathUnsafe("mydb"), priv.InsertPrivilege|priv.DeletePrivilege)
+	mydb := priv.CreateResourcePathUnsafe("mydb")
+
+	for _, set := range []priv.PrivilegeSet{priv.NewConcurrentPrivilegeSet(), priv.NewExpiringPrivilegeSet(nil)} {
+		set.UnionWith(a)
+		clone := set.Clone()
+		if !clone.Equal(a) || !set.Equal(clone) {
+			t.Fatalf("clone of %T got %s expect %s", set, clone, a)
+		}
+
+		clone.IntersectWith(b)
+		if !clone.Contain(mydb, priv.InsertPrivilege) || clone.Contain(mydb, priv.SelectPrivilege) || clone.Contain(mydb, priv.DeletePrivilege) {
+			t.Fatalf("intersection of %T got %s", set, clone)
+		}
+		if !set.Equal(a) {
+			t.Fatalf("%T changed by its clone to %s", set, set)
+		}
+
+		set.SymmetricDifference(b)
+		if !set.Contain(mydb, priv.SelectPrivilege|priv.DeletePrivilege) || set.Contain(mydb, priv.InsertPrivilege) {
+			t.Fatalf("symmetric difference of %T got %s", set, set)
+		}
+		set.SymmetricDifference(set)
+		if !set.Powerless() {
+			t.Fatalf("symmetric difference of %T with itself got %s", set, set)
+		}
+	}
+}
+
+func TestPrivilegeTreeNormalize(t *testing.T) {
+	mydb := priv.CreateResourcePathUnsafe("mydb")
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+
+	exp := priv.NewPrivilegeTree()
+	exp.AddGlobal(priv.ShowUsersPrivilege)
+	exp.Add(mydb, priv.SelectPrivilege)
+	exp.Deny(mydb, priv.DeletePrivilege)
+
+	var tests = []struct {
+		name  string
+		build func(tree *priv.PrivilegeTree)
+	}{
+		{
+			name: "empty nodes",
+			build: func(tree *priv.PrivilegeTree) {
+				tree.Add(cpu, priv.InsertPrivilege)
+				tree.Delete(cpu, priv.InsertPrivilege)
+			},
+		},
+		{
+			name: "nodes same as parent",
+			build: func(tree *priv.PrivilegeTree) {
+				tree.Add(cpu, priv.SelectPrivilege)
+				tree.Add(priv.CreateResourcePathUnsafe("mydb.daily"), priv.SelectPrivilege)
+			},
+		},
+		{
+			name: "nil children",
+			build: func(tree *priv.PrivilegeTree) {
+				tree.Tree["yourdb"] = nil
+				tree.Tree["mydb"].Tree["autogen"] = nil
+			},
+		},
+		{
+			name: "deny given by parent",
+			build: func(tree *priv.PrivilegeTree) {
+				tree.Deny(cpu, priv.DeletePrivilege)
+			},
+		},
+		{
+			name: "pattern changing nothing",
+			build: func(tree *priv.PrivilegeTree) {
+				tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^c/"), priv.InsertPrivilege)
+				tree.Delete(priv.CreateResourcePathUnsafe("mydb.autogen./^c/"), priv.InsertPrivilege)
+			},
+		},
+	}
+
+	exp.Normalize()
+	for _, test := range tests {
+		tree := priv.NewPrivilegeTree()
+		tree.AddGlobal(priv.ShowUsersPrivilege)
+		tree.Add(mydb, priv.SelectPrivilege)
+		tree.Deny(mydb, priv.DeletePrivilege)
+		test.build(tree)
+
+		tree.Normalize()
+		if tree.String() != exp.String() {
+			t.Fatalf("normalize %s got %s expect %s", test.name, tree, exp)
+		}
+	}
+
+	// a pattern changing nothing matches nothing, so it is removed even if
+	// another pattern revokes inherited privileges
+	tree := exp.Clone().(*priv.PrivilegeTree)
+	tree.Delete(priv.CreateResourcePathUnsafe("mydb.autogen./^c/"), priv.SelectPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen./u$/"), priv.InsertPrivilege)
+	tree.Delete(priv.CreateResourcePathUnsafe("mydb.autogen./u$/"), priv.InsertPrivilege)
+	if tree.Contain(cpu, priv.SelectPrivilege) {
+		t.Fatalf("pattern changing nothing got %s", tree)
+	}
+	tree.Normalize()
+	if len(tree.Tree["mydb"].Tree["autogen"].Patterns) != 1 || tree.Contain(cpu, priv.SelectPrivilege) {
+		t.Fatalf("normalize got %s", tree)
+	}
+
+	concurrent := priv.NewConcurrentPrivilegeSet()
+	concurrent.UnionWith(tree)
+	concurrent.Normalize()
+	expiring := priv.NewExpiringPrivilegeSet(nil)
+	expiring.UnionWith(tree)
+	mustNil(t, expiring.AddUntil(cpu, priv.InsertPrivilege, time.Now().Add(time.Hour)))
+	expiring.Delete(cpu, priv.InsertPrivilege)
+	expiring.Normalize()
+	if !concurrent.Equal(tree) || !expiring.Equal(tree) {
+		t.Fatalf("normalize got %s and %s expect %s", concurrent, expiring, tree)
+	}
+}
+
+func TestPrivilegeTreeNormalizeFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		tree := randomPrivilegeTree(r)
+		normalized := tree.Clone().(*priv.PrivilegeTree)
+		normalized.Normalize()
+		if !normalized.Equal(tree) || !tree.Equal(normalized) {
+			t.Fatalf("normalize %s got %s", tree, normalized)
+		}
+		for j := 0; j < 20; j++ {
+			resource, privilege := randomResourcePath(r), randomPrivilege(r)
+			if normalized.Contain(resource, privilege) != tree.Contain(resource, privilege) {
+				t.Fatalf("normalize %s got %s, which differs on %s %s", tree, normalized, resource, privilege)
+			}
+			if normalized.GlobalContain(privilege) != tree.GlobalContain(privilege) {
+				t.Fatalf("normalize %s got %s, which differs on global %s", tree, normalized, privilege)
+			}
+		}
+
+		s := normalized.String()
+		normalized.Normalize()
+		if act := normalized.String(); act != s {
+			t.Fatalf("normalize %s again got %s", s, act)
+		}
+		if act := normalized.Clone().(*priv.PrivilegeTree).String(); act != s {
+			t.Fatalf("string of clone of %s got %s", s, act)
+		}
+		loaded, err := priv.LoadPrivilegeTree(s)
+		if err != nil || loaded.String() != s {
+			t.Fatalf("load privilege tree %s got %s, error '%v'", s, loaded, err)
+		}
+	}
+
+	// the same privileges built in different orders are serialized the same
+	for i := 0; i < 1000; i++ {
+		a, b := randomPrivilegeTree(r), randomPrivilegeTree(r)
+		ab := a.Clone().(*priv.PrivilegeTree)
+		ab.UnionWith(b)
+		ab.Normalize()
+		ba := b.Clone().(*priv.PrivilegeTree)
+		ba.UnionWith(a)
+		ba.Normalize()
+		if !ab.Equal(ba) || ab.String() != ba.String() {
+			t.Fatalf("union of %s and %s normalized to %s and %s", a, b, ab, ba)
+		}
+	}
+}
+
+func TestPrivilegeTreeStringSorted(t *testing.T) {
+	set, err := priv.LoadPrivilegeTree(`{[(, 1)]} {[(yourdb, 3)(mydb, 2)]} {[][(daily, 5)(autogen, 4)(/^m/, 8)]} {[][(mem, 7)(cpu, 6)][]} {[][]}`)
+	if err != nil {
+		t.Fatalf("load privilege tree got error '%v'", err)
+	}
+
+	exp := `{[("",1)]}{[(mydb,2)(yourdb,3)]}{[(autogen,4)(daily,5)(/^m/,8)][]}{[(cpu,6)(mem,7)][][]}{[][]}`
+	for i := 0; i < 20; i++ {
+		if act := set.String(); act != exp {
+			t.Fatalf("string got %s expect %s", act, exp)
+		}
+	}
+}
+
+func TestLoadPrivilegeTree(t *testing.T) {
+	set := priv.NewPrivilegeTree()
+	set.AddGlobal(priv.GrantPrivilege | priv.InsertPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe(`"my.db"`), priv.SelectPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe(`"my.db"."{rp}"`), priv.SelectPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe(`"my.db"."{rp}"."cpu[0](a,b)"`), priv.DeletePrivilege|priv.DropPrivilege)
+	set.Add(priv.CreateResourcePathUnsafe(`"my.db"."{rp}"."say \"hi\""`), priv.DeletePrivilege)
+	set.Add(priv.CreateResourcePathUnsafe(`"select".autogen`), priv.SelectPrivilege)
+
+	loaded, err := priv.LoadPrivilegeTree(set.String())
+	if err != nil {
+		t.Fatalf("load privilege tree %s got error '%v'", set, err)
+	}
+	if !reflect.DeepEqual(loaded, set) {
+		t.Fatalf("load privilege tree %s got %s", set, loaded)
+	}
+
+	// whitespaces between sections are allowed
+	loaded, err = priv.LoadPrivilegeTree(`{[(, 1)]} {[(yourdb, 3)(mydb, 2)]} {[][(daily, 5)(autogen, 4)]} {[][(mem, 7)(cpu, 6)]} {[][]}`)
+	if err != nil {
+		t.Fatalf("load privilege tree got error '%v'", err)
+	}
+	if act, exp := loaded.Tree["mydb"].Tree["autogen"].Tree["cpu"].Privilege, priv.Privilege(6); act != exp {
+		t.Fatalf("load privilege tree got privilege %d of mydb.autogen.cpu expect %d", act, exp)
+	}
+}
+
+func TestLoadPrivilegeTreeFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		set := randomPrivilegeTree(r)
+		loaded, err := priv.LoadPrivilegeTree(set.String())
+		if err != nil {
+			t.Fatalf("load privilege tree %s got error '%v'", set, err)
+		}
+		if !reflect.DeepEqual(loaded, set) {
+			t.Fatalf("load privilege tree %s got %s", set, loaded)
+		}
+	}
+}
+
+func TestLoadPrivilegeTreeErr(t *testing.T) {
+	var tests = []struct {
+		s string
+		e string
+	}{
+		{
+			s: ``,
+			e: `found EOF, expected { at line 1, char 1`,
+		},
+		{
+			s: `{[(,1)]}`,
+			e: `found EOF, expected { at line 1, char 9`,
+		},
+		{
+			s: `{[(,1)(a,2)]}{[][]}`,
+			e: `expect exactly one root node at line 1, char 1`,
+		},
+		{
+			s: `{[(,1)]}{[(a,2)][]}`,
+			e: `found [, expected } at line 1, char 17`,
+		},
+		{
+			s: `{[(,1)]}{[(a,2)]}{}`,
+			e: `found }, expected [ at line 1, char 19`,
+		},
+		{
+			s: `{[(,1)]}{[(a,2)(a,3)]}{[][]}`,
+			e: `duplicate node a at line 1, char 16`,
+		},
+		{
+			s: `{[(,1)]}{[(a,x)]}{[]}`,
+			e: `found x, expected integer at line 1, char 14`,
+		},
+		{
+			s: `{[(,1)]}{[(a 1)]}{[]}`,
+			e: `found 1, expected , at line 1, char 14`,
+		},
+		{
+			s: `{[(,1)]}{[(select,1)]}{[]}`,
+			e: `found SELECT, expected identifier at line 1, char 12`,
+		},
+		{
+			s: `{[(,1)]}{[(a,1]}{[]}`,
+			e: `found ], expected ) at line 1, char 15`,
+		},
+		{
+			s: `{[(,1)]}{[a]}`,
+			e: `found a, expected (, ] at line 1, char 11`,
+		},
+		{
+			s: `{[(,1)]}{[("a,1)]}{[]}`,
+			e: `found a,1)]}{[]}, expected identifier at line 1, char 11`,
+		},
+	}
+	for _, test := range tests {
+		if _, err := priv.LoadPrivilegeTree(test.s); err == nil || err.Error() != test.e {
+			t.Fatalf("load privilege tree %s got error '%v' expect error '%v'", test.s, err, test.e)
+		}
+	}
+}
+
+var randomSegs = []string{"mydb", "yourdb", "autogen", "daily", "cpu", "mem", "select", "",
+	"my.db", "{rp}", "[m]", "(a,b)", `say "hi"`, `back\slash`, "new\nline", "数据库"}
+
+var randomPrivileges = []priv.Privilege{priv.ReadPrivilege, priv.WritePrivilege, priv.CreateCQPrivilege,
+	priv.InsertPrivilege, priv.SelectPrivilege, priv.DeletePrivilege, priv.DropPrivilege,
+	priv.ShowUsersPrivilege, priv.GrantPrivilege, priv.AuditPrivilege, priv.ShowCQSPrivilege}
+
+var randomRegexes = []*regexp.Regexp{regexp.MustCompile(`^cpu`), regexp.MustCompile(`m`),
+	regexp.MustCompile(`.*`), regexp.MustCompile(`a/b`), regexp.MustCompile(`^(my|your)db$`)}
+
+// randomResourcePath creates a random resource path of at most 3 segments,
+// the last of which may be a regex.
+func randomResourcePath(r *rand.Rand) *priv.ResourcePath {
+	segs := make([]string, r.Intn(4))
+	for i := range segs {
+		segs[i] = randomSegs[r.Intn(len(randomSegs))]
+	}
+	if len(segs) == 2 && r.Intn(2) == 0 {
+		return priv.NewRegexResourcePath(randomRegexes[r.Intn(len(randomRegexes))], segs[0], segs[1])
+	}
+	return priv.NewResourcePath(segs...)
+}
+
+// randomPrivilege combines a few random privileges.
+func randomPrivilege(r *rand.Rand) priv.Privilege {
+	p := priv.NoPrivilege
+	for i := r.Intn(3) + 1; i > 0; i-- {
+		p |= randomPrivileges[r.Intn(len(randomPrivileges))]
+	}
+	return p
+}
+
+// randomPrivilegeTree creates a privilege tree by random operations.
+func randomPrivilegeTree(r *rand.Rand) *priv.PrivilegeTree {
+	return randomTree(r, 6)
+}
+
+// randomGrantTree creates a privilege tree by random operations except
+// denies, which are part of set identity and kept by union, and so break
+// some laws of set algebra.
+func randomGrantTree(r *rand.Rand) *priv.PrivilegeTree {
+	return randomTree(r, 4)
+}
+
+// randomTree creates a privilege tree by random operations, the first ops of
+// which are chosen from.
+func randomTree(r *rand.Rand, ops int) *priv.PrivilegeTree {
+	set := priv.NewPrivilegeTree()
+	for i := r.Intn(16); i > 0; i-- {
+		switch r.Intn(ops) {
+		case 0:
+			set.AddGlobal(randomPrivilege(r))
+		case 1:
+			set.DeleteGlobal(randomPrivilege(r))
+		case 2:
+			set.Add(randomResourcePath(r), randomPrivilege(r))
+		case 3:
+			set.Delete(randomResourcePath(r), randomPrivilege(r))
+		case 4:
+			set.Deny(randomResourcePath(r), randomPrivilege(r))
+		case 5:
+			set.Undeny(randomResourcePath(r), randomPrivilege(r))
+		}
+	}
+	return set
+}
@@ -0,0 +1,744 @@
+package priv_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/musenwill/exercise/priv"
+	"go.uber.org/zap/zapcore"
+)
+
+// referenceEntry is privileges of a resource in referencePrivilegeSet.
+type referenceEntry struct {
+	granted    priv.Privilege            // absolute, not a delta from its parent
+	denied     priv.Privilege            // denied on the resource itself, not its parents
+	conditions map[string]priv.Privilege // absolute, keyed by string of the condition
+}
+
+// referencePrivilegeSet is a PrivilegeSet kept as simple as possible to check
+// other implementations against. It maps every resource of referencePaths to
+// privileges granted on it, so that an operation is applied to each resource
+// under the resource of the operation separately, and privileges of a
+// resource are looked up by its key directly. Resources not in referencePaths
+// always have the same privileges as their nearest parent in it, as no
+// operation refers them. Patterns are not supported.
+type referencePrivilegeSet struct {
+	entries map[string]*referenceEntry // keyed by segments each after referenceSep
+}
+
+// referenceSep leads segments in keys of referencePrivilegeSet, which is never
+// part of a segment in tests. The global resource has an empty key.
+const referenceSep = "\x00"
+
+var _ priv.PrivilegeSet = (*referencePrivilegeSet)(nil)
+
+func newReferencePrivilegeSet() *referencePrivilegeSet {
+	s := &referencePrivilegeSet{entries: make(map[string]*referenceEntry, len(referencePaths))}
+	for _, path := range referencePaths {
+		s.entries[referenceKey(path)] = &referenceEntry{}
+	}
+	return s
+}
+
+func referenceKey(resource *priv.ResourcePath) string {
+	if resource.Regex != nil {
+		panic("patterns are not supported by referencePrivilegeSet")
+	}
+	if len(resource.Segs) == 0 {
+		return ""
+	}
+	return referenceSep + strings.Join(resource.Segs, referenceSep)
+}
+
+// under checks if the resource of key is the resource of parent or under it.
+func under(key, parent string) bool {
+	return parent == "" || key == parent || strings.HasPrefix(key, parent+referenceSep)
+}
+
+// entry returns entry of the resource of key, or of its nearest parent in
+// referencePaths.
+func (s *referencePrivilegeSet) entry(key string) (string, *referenceEntry) {
+	for s.entries[key] == nil {
+		key = key[:strings.LastIndex(key, referenceSep)]
+	}
+	return key, s.entries[key]
+}
+
+// lookup returns privileges granted on the resource of key, and denied on it
+// or its parents.
+func (s *referencePrivilegeSet) lookup(key string) (granted, denied priv.Privilege) {
+	key, e := s.entry(key)
+	for ; key != ""; key = key[:strings.LastIndex(key, referenceSep)] {
+		denied |= s.entries[key].denied
+	}
+	return e.granted, denied | s.entries[""].denied
+}
+
+// update applies fn to entries of the resource and resources under it.
+func (s *referencePrivilegeSet) update(resource *priv.ResourcePath, fn func(e *referenceEntry)) {
+	key := referenceKey(resource)
+	for k, e := range s.entries {
+		if under(k, key) {
+			fn(e)
+		}
+	}
+}
+
+func (s *referencePrivilegeSet) SetAll() {
+	s.ClearAll()
+	s.AddGlobal(priv.AllGlobalPrivileges)
+}
+
+func (s *referencePrivilegeSet) ClearAll() {
+	*s = *newReferencePrivilegeSet()
+}
+
+func (s *referencePrivilegeSet) AddGlobal(privilege priv.Privilege) {
+	s.update(priv.NewResourcePath(), func(e *referenceEntry) { e.granted |= privilege })
+}
+
+// DeleteGlobal deletes privileges from all resources, including privileges
+// granted under conditions.
+func (s *referencePrivilegeSet) DeleteGlobal(privilege priv.Privilege) {
+	s.update(priv.NewResourcePath(), func(e *referenceEntry) { e.revoke(privilege) })
+}
+
+func (s *referencePrivilegeSet) Add(resource *priv.ResourcePath, privilege priv.Privilege) {
+	privilege &= priv.AllResourcePrivileges
+	s.update(resource, func(e *referenceEntry) { e.granted |= privilege })
+}
+
+// AddWhere grants privileges on rows satisfying the condition of the resource
+// and resources under it.
+func (s *referencePrivilegeSet) AddWhere(resource *priv.ResourcePath, privilege priv.Privilege, condition priv.Expr) {
+	privilege &= priv.AllResourcePrivileges
+	key := condition.String()
+	s.update(resource, func(e *referenceEntry) {
+		if e.conditions == nil {
+			e.conditions = make(map[string]priv.Privilege)
+		}
+		e.conditions[key] |= privilege
+	})
+}
+
+// Delete deletes privileges from the resource and resources under it,
+// including privileges granted under conditions.
+func (s *referencePrivilegeSet) Delete(resource *priv.ResourcePath, privilege priv.Privilege) {
+	privilege &= priv.AllResourcePrivileges
+	s.update(resource, func(e *referenceEntry) { e.revoke(privilege) })
+}
+
+func (s *referencePrivilegeSet) Deny(resource *priv.ResourcePath, privilege priv.Privilege) {
+	if len(resource.Segs) > 0 {
+		privilege &= priv.AllResourcePrivileges
+	}
+	s.entries[referenceKey(resource)].denied |= privilege
+}
+
+func (s *referencePrivilegeSet) Undeny(resource *priv.ResourcePath, privilege priv.Privilege) {
+	s.update(resource, func(e *referenceEntry) { e.denied &^= privilege })
+}
+
+// revoke deletes privileges granted with or without conditions.
+func (e *referenceEntry) revoke(privilege priv.Privilege) {
+	e.granted &^= privilege
+	for k := range e.conditions {
+		e.conditions[k] &^= privilege
+	}
+}
+
+// UnionWith grants privileges granted by either set, with or without
+// conditions, and denies privileges denied by either set.
+func (s *referencePrivilegeSet) UnionWith(o priv.PrivilegeSet) {
+	other := o.(*referencePrivilegeSet).clone() // o may be s itself
+	for k, e := range s.entries {
+		oe := other.entries[k]
+		e.granted |= oe.granted
+		e.denied |= oe.denied
+		for c, p := range oe.conditions {
+			if e.conditions == nil {
+				e.conditions = make(map[string]priv.Privilege)
+			}
+			e.conditions[c] |= p
+		}
+	}
+}
+
+// DifferentWith revokes privileges granted and not denied by o, conditional
+// privileges are revoked by privileges o grants without conditions or under
+// the same condition. Denies of s are kept.
+func (s *referencePrivilegeSet) DifferentWith(o priv.PrivilegeSet) {
+	other := o.(*referencePrivilegeSet).clone()
+	for k, e := range s.entries {
+		granted, denied := other.lookup(k)
+		granted &^= denied
+		e.granted &^= granted
+		for c, p := range e.conditions {
+			e.conditions[c] = p &^ (granted | other.entries[k].conditions[c]&^denied)
+		}
+	}
+}
+
+// IntersectWith keeps s - (s - o).
+func (s *referencePrivilegeSet) IntersectWith(o priv.PrivilegeSet) {
+	diff := s.clone()
+	diff.DifferentWith(o)
+	s.DifferentWith(diff)
+}
+
+// SymmetricDifference keeps (s - o) | (o - s).
+func (s *referencePrivilegeSet) SymmetricDifference(o priv.PrivilegeSet) {
+	otherOnly := o.(*referencePrivilegeSet).clone()
+	otherOnly.DifferentWith(s)
+	s.DifferentWith(o)
+	s.UnionWith(otherOnly)
+}
+
+func (s *referencePrivilegeSet) GlobalContain(privilege priv.Privilege) bool {
+	return s.Contain(priv.NewResourcePath(), privilege)
+}
+
+func (s *referencePrivilegeSet) Contain(resource *priv.ResourcePath, privilege priv.Privilege) bool {
+	granted, denied := s.lookup(referenceKey(resource))
+	return referenceEffective(granted, denied)&privilege == privilege
+}
+
+// ContainWith checks privileges granted without conditions, or under
+// conditions the tags satisfy.
+func (s *referencePrivilegeSet) ContainWith(resource *priv.ResourcePath, privilege priv.Privilege, tags map[string]string) bool {
+	key, e := s.entry(referenceKey(resource))
+	granted, denied := s.lookup(key)
+	if len(e.conditions) == 0 {
+		return referenceEffective(granted, denied)&privilege == privilege
+	}
+	values := make(map[string]interface{}, len(tags))
+	for k, v := range tags {
+		values[k] = v
+	}
+	for c, p := range e.conditions {
+		if ok, err := priv.EvalBool(referenceConditions[c], values); err == nil && ok {
+			granted |= p
+		}
+	}
+	return referenceEffective(granted, denied)&privilege == privilege
+}
+
+// referenceEffective returns privileges granted and not denied, legacy READ
+// and WRITE grant their groups.
+func referenceEffective(granted, denied priv.Privilege) priv.Privilege {
+	effective := granted &^ denied
+	if effective&priv.ReadPrivilege != 0 {
+		effective |= priv.ReadGroupPrivileges
+	}
+	if effective&priv.WritePrivilege != 0 {
+		effective |= priv.WriteGroupPrivileges
+	}
+	return effective &^ denied
+}
+
+// Contains checks if every resource has privileges granted by o and not
+// denied also granted by s and not denied, and every privilege o grants under
+// a condition granted by s without condition or under the same condition.
+// Legacy READ and WRITE are not expanded.
+func (s *referencePrivilegeSet) Contains(o priv.PrivilegeSet) bool {
+	other := o.(*referencePrivilegeSet)
+	for k, oe := range other.entries {
+		granted, denied := s.lookup(k)
+		granted &^= denied
+		og, od := other.lookup(k)
+		if og&^od&^granted != priv.NoPrivilege {
+			return false
+		}
+		for c, p := range oe.conditions {
+			if p&^od&^(granted|s.entries[k].conditions[c]&^denied) != priv.NoPrivilege {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Equal checks if 2 sets contain each other and every resource is denied the
+// same privileges.
+func (s *referencePrivilegeSet) Equal(o priv.PrivilegeSet) bool {
+	other := o.(*referencePrivilegeSet)
+	for k := range s.entries {
+		_, denied := s.lookup(k)
+		if _, od := other.lookup(k); denied != od {
+			return false
+		}
+	}
+	return s.Contains(o) && o.Contains(s)
+}
+
+func (s *referencePrivilegeSet) Powerless() bool {
+	return newReferencePrivilegeSet().Contains(s)
+}
+
+func (s *referencePrivilegeSet) Clone() priv.PrivilegeSet {
+	return s.clone()
+}
+
+func (s *referencePrivilegeSet) clone() *referencePrivilegeSet {
+	c := &referencePrivilegeSet{entries: make(map[string]*referenceEntry, len(s.entries))}
+	for k, e := range s.entries {
+		ce := &referenceEntry{granted: e.granted, denied: e.denied}
+		for c, p := range e.conditions {
+			if ce.conditions == nil {
+				ce.conditions = make(map[string]priv.Privilege, len(e.conditions))
+			}
+			ce.conditions[c] = p
+		}
+		c.entries[k] = ce
+	}
+	return c
+}
+
+// referenceSegs are segments of resources in differential tests, few enough
+// for operations to hit the same resources often.
+var referenceSegs = []string{"mydb", "autogen", "cpu", ""}
+
+// referencePaths are all resources of at most 3 segments from referenceSegs.
+var referencePaths = func() []*priv.ResourcePath {
+	paths := []*priv.ResourcePath{priv.NewResourcePath()}
+	for i := 0; len(paths[i].Segs) < 3; i++ {
+		for _, seg := range referenceSegs {
+			segs := append(append([]string(nil), paths[i].Segs...), seg)
+			paths = append(paths, priv.NewResourcePath(segs...))
+		}
+	}
+	return paths
+}()
+
+// referenceConditions are conditions of differential tests keyed by their
+// strings.
+var referenceConditions = func() map[string]priv.Expr {
+	conditions := make(map[string]priv.Expr)
+	for _, s := range []string{`host = 'a'`, `region = 'us'`} {
+		condition, err := priv.ParseExpr(s)
+		if err != nil {
+			panic(err)
+		}
+		conditions[condition.String()] = condition
+	}
+	return conditions
+}()
+
+// referenceTags are tags of rows checked by ContainWith in differential tests,
+// which satisfy either or both of referenceConditions.
+var referenceTags = []map[string]string{
+	{"host": "a"},
+	{"host": "b", "region": "us"},
+	{"host": "a", "region": "us"},
+}
+
+// randomReferencePath creates a random resource of at most 3 segments from
+// referenceSegs.
+func randomReferencePath(r *rand.Rand) *priv.ResourcePath {
+	segs := make([]string, r.Intn(4))
+	for i := range segs {
+		segs[i] = referenceSegs[r.Intn(len(referenceSegs))]
+	}
+	return priv.NewResourcePath(segs...)
+}
+
+// conditionalPrivilegeSet is a PrivilegeSet checking conditional privileges.
+type conditionalPrivilegeSet interface {
+	priv.PrivilegeSet
+	ContainWith(resource *priv.ResourcePath, privilege priv.Privilege, tags map[string]string) bool
+}
+
+// addWhere grants privileges under the condition by whichever method the set
+// supports, permanently.
+func addWhere(set priv.PrivilegeSet, resource *priv.ResourcePath, privilege priv.Privilege, condition priv.Expr) {
+	switch set := set.(type) {
+	case *priv.ExpiringPrivilegeSet:
+		_ = set.AddWhereUntil(resource, privilege, condition, time.Time{})
+	case interface {
+		AddWhere(*priv.ResourcePath, priv.Privilege, priv.Expr)
+	}:
+		set.AddWhere(resource, privilege, condition)
+	case *priv.AuditedPrivilegeSet:
+		if err := set.AddWhere(resource, privilege, condition); err != nil {
+			panic(err)
+		}
+	default:
+		panic(fmt.Sprintf("%T does not support conditional privileges", set))
+	}
+}
+
+// applyRandomOp applies a random operation to each of the sets and returns
+// its description.
+func applyRandomOp(r *rand.Rand, sets ...priv.PrivilegeSet) string {
+	resource, privilege := randomReferencePath(r), randomPrivilege(r)
+	var desc string
+	var op func(set priv.PrivilegeSet)
+	switch r.Intn(23) {
+	case 0:
+		desc, op = "SetAll", func(set priv.PrivilegeSet) { set.SetAll() }
+	case 1:
+		desc, op = "ClearAll", func(set priv.PrivilegeSet) { set.ClearAll() }
+	case 2, 3:
+		desc = fmt.Sprintf("AddGlobal %s", privilege)
+		op = func(set priv.PrivilegeSet) { set.AddGlobal(privilege) }
+	case 4, 5:
+		desc = fmt.Sprintf("DeleteGlobal %s", privilege)
+		op = func(set priv.PrivilegeSet) { set.DeleteGlobal(privilege) }
+	case 6, 7, 8, 9:
+		desc = fmt.Sprintf("Add %s on '%s'", privilege, resource)
+		op = func(set priv.PrivilegeSet) { set.Add(resource, privilege) }
+	case 10, 11, 12:
+		var condition priv.Expr
+		for _, c := range referenceConditions {
+			if condition == nil || r.Intn(2) == 0 {
+				condition = c
+			}
+		}
+		desc = fmt.Sprintf("AddWhere %s on '%s' WHERE %s", privilege, resource, condition)
+		op = func(set priv.PrivilegeSet) { addWhere(set, resource, privilege, condition) }
+	case 13, 14, 15, 16:
+		desc = fmt.Sprintf("Delete %s on '%s'", privilege, resource)
+		op = func(set priv.PrivilegeSet) { set.Delete(resource, privilege) }
+	case 17, 18, 19:
+		desc = fmt.Sprintf("Deny %s on '%s'", privilege, resource)
+		op = func(set priv.PrivilegeSet) { set.Deny(resource, privilege) }
+	default:
+		desc = fmt.Sprintf("Undeny %s on '%s'", privilege, resource)
+		op = func(set priv.PrivilegeSet) { set.Undeny(resource, privilege) }
+	}
+	for _, set := range sets {
+		op(set)
+	}
+	return desc
+}
+
+// sameContain checks if 2 sets give the same answers to GlobalContain,
+// Contain and ContainWith if both support it on all referencePaths, and
+// returns the first difference if not.
+func sameContain(a, b priv.PrivilegeSet) (string, bool) {
+	ca, conditional := a.(conditionalPrivilegeSet)
+	cb, ok := b.(conditionalPrivilegeSet)
+	conditional = conditional && ok
+
+	privileges := append([]priv.Privilege{priv.ReadGroupPrivileges, priv.WriteGroupPrivileges}, randomPrivileges...)
+	for _, privilege := range privileges {
+		if a.GlobalContain(privilege) != b.GlobalContain(privilege) {
+			return "global contain " + privilege.String(), false
+		}
+		for _, path := range referencePaths {
+			contain := a.Contain(path, privilege)
+			if contain != b.Contain(path, privilege) {
+				return "contain " + privilege.String() + " on " + path.String(), false
+			}
+			// conditions only grant more than Contain checks
+			if contain || !conditional || privilege&priv.AllResourcePrivileges != privilege {
+				continue
+			}
+			for _, tags := range referenceTags {
+				if ca.ContainWith(path, privilege, tags) != cb.ContainWith(path, privilege, tags) {
+					return fmt.Sprintf("contain %s on %s with %v", privilege, path, tags), false
+				}
+			}
+		}
+	}
+	if a.Powerless() != b.Powerless() {
+		return "powerless", false
+	}
+	return "", true
+}
+
+// privilegeSetImplementations are implementations of PrivilegeSet checked
+// against referencePrivilegeSet.
+var privilegeSetImplementations = []struct {
+	name string
+	new  func() priv.PrivilegeSet
+}{
+	{name: "tree", new: func() priv.PrivilegeSet { return priv.NewPrivilegeTree() }},
+	{name: "concurrent", new: func() priv.PrivilegeSet { return priv.NewConcurrentPrivilegeSet() }},
+	{name: "expiring", new: func() priv.PrivilegeSet { return priv.NewExpiringPrivilegeSet(nil) }},
+	{name: "audited", new: func() priv.PrivilegeSet {
+		return priv.NewAuditLog(zapcore.AddSync(ioutil.Discard), time.Now).Wrap(priv.NewPrivilegeTree(), "admin", "alice")
+	}},
+}
+
+func TestReferencePrivilegeSet(t *testing.T) {
+	mydb := priv.CreateResourcePathUnsafe("mydb")
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+
+	set := newReferencePrivilegeSet()
+	set.AddGlobal(priv.ShowUsersPrivilege)
+	set.Add(mydb, priv.ReadPrivilege)
+	set.Deny(cpu, priv.SelectPrivilege)
+	set.Delete(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.InsertPrivilege)
+	set.AddWhere(mydb, priv.DropPrivilege, mustParseExpr(t, `host = 'a'`))
+	set.Delete(cpu, priv.DropPrivilege)
+
+	runCases(t, set, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		{r: priv.NewResourcePath(), p: priv.ShowUsersPrivilege, t: true},
+		{r: mydb, p: priv.SelectPrivilege | priv.ShowUsersPrivilege, t: true},
+		{r: mydb, p: priv.DropPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.mem"), p: priv.SelectPrivilege, t: true},
+		{r: cpu, p: priv.CreateCQPrivilege, t: true},
+		{r: cpu, p: priv.SelectPrivilege, t: false},
+		{r: cpu, p: priv.InsertPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("mydb.daily"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("autogen"), p: priv.SelectPrivilege, t: false},
+	})
+
+	a := map[string]string{"host": "a"}
+	if !set.ContainWith(mydb, priv.DropPrivilege, a) || set.ContainWith(mydb, priv.DropPrivilege, nil) ||
+		set.ContainWith(cpu, priv.DropPrivilege, a) {
+		t.Fatalf("conditional privileges got %v", set.entries)
+	}
+
+	set.Undeny(mydb, priv.SelectPrivilege)
+	if !set.Contain(cpu, priv.SelectPrivilege) {
+		t.Fatalf("undeny on parent got %v", set.entries)
+	}
+}
+
+// TestPrivilegeSetPatternPrecedence checks how privileges of patterns combine
+// with each other and with literal resources, which referencePrivilegeSet
+// leaves out.
+func TestPrivilegeSetPatternPrecedence(t *testing.T) {
+	mydb := priv.CreateResourcePathUnsafe("mydb")
+	cpu := priv.CreateResourcePathUnsafe("mydb.autogen.cpu")
+	mem := priv.CreateResourcePathUnsafe("mydb.autogen.mem")
+	disk := priv.CreateResourcePathUnsafe("mydb.autogen.disk")
+	sum := priv.CreateResourcePathUnsafe("mydb.autogen.sum")
+	c := priv.NewRegexResourcePath(regexp.MustCompile("^c"), "mydb", "autogen")
+	u := priv.NewRegexResourcePath(regexp.MustCompile("u"), "mydb", "autogen")
+	host := mustParseExpr(t, `host = 'a'`)
+
+	type check struct {
+		r    *priv.ResourcePath
+		p    priv.Privilege
+		tags map[string]string // checked by ContainWith if not nil
+		t    bool
+	}
+	tests := []struct {
+		name   string
+		ops    func(set priv.PrivilegeSet)
+		checks []check
+	}{
+		{
+			name: "patterns grant to measurements they match",
+			ops:  func(set priv.PrivilegeSet) { set.Add(c, priv.SelectPrivilege) },
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, t: true},
+				{r: mem, p: priv.SelectPrivilege, t: false},
+				{r: c, p: priv.SelectPrivilege, t: true},
+				{r: u, p: priv.SelectPrivilege, t: false},
+			},
+		},
+		{
+			name: "all matching patterns combine",
+			ops: func(set priv.PrivilegeSet) {
+				set.Add(c, priv.SelectPrivilege)
+				set.Add(u, priv.DeletePrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege | priv.DeletePrivilege, t: true},
+				{r: mem, p: priv.DeletePrivilege, t: false},
+			},
+		},
+		{
+			name: "patterns revoke what is inherited",
+			ops: func(set priv.PrivilegeSet) {
+				set.Add(mydb, priv.SelectPrivilege)
+				set.Delete(c, priv.SelectPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, t: false},
+				{r: mem, p: priv.SelectPrivilege, t: true},
+			},
+		},
+		{
+			name: "patterns apply to existing measurements",
+			ops: func(set priv.PrivilegeSet) {
+				set.Add(cpu, priv.DropPrivilege)
+				set.Add(c, priv.SelectPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege | priv.DropPrivilege, t: true},
+			},
+		},
+		{
+			name: "measurements take precedence over patterns",
+			ops: func(set priv.PrivilegeSet) {
+				set.Add(c, priv.SelectPrivilege)
+				set.Delete(cpu, priv.SelectPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, t: false},
+				{r: c, p: priv.SelectPrivilege, t: true},
+			},
+		},
+		{
+			name: "parents take precedence over patterns",
+			ops: func(set priv.PrivilegeSet) {
+				set.Delete(c, priv.SelectPrivilege)
+				set.Add(priv.CreateResourcePathUnsafe("mydb.autogen"), priv.SelectPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, t: true},
+				{r: disk, p: priv.SelectPrivilege, t: true},
+			},
+		},
+		{
+			name: "patterns changing nothing match nothing",
+			ops: func(set priv.PrivilegeSet) {
+				set.Add(mydb, priv.SelectPrivilege)
+				set.Add(c, priv.SelectPrivilege)
+				set.Delete(u, priv.SelectPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, t: false},
+				{r: disk, p: priv.SelectPrivilege, t: true},
+			},
+		},
+		{
+			name: "patterns changing something combine with others",
+			ops: func(set priv.PrivilegeSet) {
+				set.Add(mydb, priv.SelectPrivilege)
+				set.Add(c, priv.InsertPrivilege)
+				set.Delete(u, priv.SelectPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege | priv.InsertPrivilege, t: true},
+				{r: sum, p: priv.SelectPrivilege, t: false},
+				{r: mem, p: priv.SelectPrivilege, t: true},
+			},
+		},
+		{
+			name: "denies of matching patterns combine",
+			ops: func(set priv.PrivilegeSet) {
+				set.Add(mydb, priv.SelectPrivilege|priv.InsertPrivilege)
+				set.Deny(c, priv.SelectPrivilege)
+				set.Deny(u, priv.InsertPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, t: false},
+				{r: cpu, p: priv.InsertPrivilege, t: false},
+				{r: sum, p: priv.SelectPrivilege, t: true},
+				{r: sum, p: priv.InsertPrivilege, t: false},
+				{r: disk, p: priv.SelectPrivilege | priv.InsertPrivilege, t: true},
+			},
+		},
+		{
+			name: "patterns grant under conditions",
+			ops:  func(set priv.PrivilegeSet) { addWhere(set, c, priv.SelectPrivilege, host) },
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, t: false},
+				{r: cpu, p: priv.SelectPrivilege, tags: map[string]string{"host": "a"}, t: true},
+				{r: cpu, p: priv.SelectPrivilege, tags: map[string]string{"host": "b"}, t: false},
+				{r: mem, p: priv.SelectPrivilege, tags: map[string]string{"host": "a"}, t: false},
+			},
+		},
+		{
+			name: "patterns revoke conditions inherited",
+			ops: func(set priv.PrivilegeSet) {
+				addWhere(set, mydb, priv.SelectPrivilege, host)
+				set.Delete(c, priv.SelectPrivilege)
+			},
+			checks: []check{
+				{r: cpu, p: priv.SelectPrivilege, tags: map[string]string{"host": "a"}, t: false},
+				{r: mem, p: priv.SelectPrivilege, tags: map[string]string{"host": "a"}, t: true},
+			},
+		},
+	}
+
+	for _, impl := range privilegeSetImplementations {
+		for _, test := range tests {
+			set := impl.new()
+			test.ops(set)
+			for _, check := range test.checks {
+				var act bool
+				if check.tags == nil {
+					act = set.Contain(check.r, check.p)
+				} else if cset, ok := set.(conditionalPrivilegeSet); ok {
+					act = cset.ContainWith(check.r, check.p, check.tags)
+				} else {
+					continue
+				}
+				if act != check.t {
+					t.Fatalf("%s: %s: contain %s on %s with %v expect %v", impl.name, test.name, check.p, check.r, check.tags, check.t)
+				}
+			}
+		}
+	}
+}
+
+// TestPrivilegeSetDifferential drives random operations through
+// implementations of PrivilegeSet and referencePrivilegeSet, and checks they
+// always give the same answers.
+func TestPrivilegeSetDifferential(t *testing.T) {
+	// the other implementations share the math of the tree, so they are
+	// checked less for delegating correctly
+	runs := map[string]int{"tree": 300}
+
+	binaryOps := []struct {
+		name string
+		op   func(a, b priv.PrivilegeSet)
+	}{
+		{name: "UnionWith", op: func(a, b priv.PrivilegeSet) { a.UnionWith(b) }},
+		{name: "DifferentWith", op: func(a, b priv.PrivilegeSet) { a.DifferentWith(b) }},
+		{name: "IntersectWith", op: func(a, b priv.PrivilegeSet) { a.IntersectWith(b) }},
+		{name: "SymmetricDifference", op: func(a, b priv.PrivilegeSet) { a.SymmetricDifference(b) }},
+	}
+
+	for _, impl := range privilegeSetImplementations {
+		n := runs[impl.name]
+		if n == 0 {
+			n = 100
+		}
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < n; i++ {
+			a, b := impl.new(), impl.new()
+			ra, rb := newReferencePrivilegeSet(), newReferencePrivilegeSet()
+			var ops []string
+
+			for j := r.Intn(10); j > 0; j-- {
+				ops = append(ops, "A: "+applyRandomOp(r, a, ra))
+				if diff, ok := sameContain(a, ra); !ok {
+					t.Fatalf("%s differs from reference on %s after %v", impl.name, diff, ops)
+				}
+			}
+			for j := r.Intn(10); j > 0; j-- {
+				ops = append(ops, "B: "+applyRandomOp(r, b, rb))
+				if diff, ok := sameContain(b, rb); !ok {
+					t.Fatalf("%s differs from reference on %s after %v", impl.name, diff, ops)
+				}
+			}
+
+			if a.Contains(b) != ra.Contains(rb) || b.Contains(a) != rb.Contains(ra) || a.Equal(b) != ra.Equal(rb) {
+				t.Fatalf("%s differs from reference on comparing sets after %v", impl.name, ops)
+			}
+
+			binary := binaryOps[r.Intn(len(binaryOps))]
+			binary.op(a, b)
+			binary.op(ra, rb)
+			ops = append(ops, "A "+binary.name+" B")
+			if diff, ok := sameContain(a, ra); !ok {
+				t.Fatalf("%s differs from reference on %s after %v", impl.name, diff, ops)
+			}
+
+			// operations go on after combining sets
+			for j := r.Intn(5); j > 0; j-- {
+				ops = append(ops, "A: "+applyRandomOp(r, a, ra))
+				if diff, ok := sameContain(a, ra); !ok {
+					t.Fatalf("%s differs from reference on %s after %v", impl.name, diff, ops)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package priv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	// ErrUserNotFound is returned when the user does not exist.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrUserExists is returned when creating a user which already exists.
+	ErrUserExists = errors.New("user already exists")
+	// ErrRoleNotFound is returned when the role does not exist.
+	ErrRoleNotFound = errors.New("role not found")
+	// ErrRoleExists is returned when creating a role which already exists.
+	ErrRoleExists = errors.New("role already exists")
+	// ErrRoleCycle is returned when granting a role would make a role contain itself.
+	ErrRoleCycle = errors.New("role grant forms a cycle")
+)
+
+// principalKey identifies a user or a role.
+type principalKey struct {
+	name string
+	role bool
+}
+
+func (k principalKey) String() string {
+	if k.role {
+		return "role " + QuoteIdent(k.name)
+	}
+	return "user " + QuoteIdent(k.name)
+}
+
+func (k principalKey) notFound() error {
+	if k.role {
+		return fmt.Errorf("%w: %s", ErrRoleNotFound, k.name)
+	}
+	return fmt.Errorf("%w: %s", ErrUserNotFound, k.name)
+}
+
+// principal is a user or a role in the role graph.
+type principal struct {
+	// privileges granted to the principal directly
+	privileges *PrivilegeTree
+	// roles granted to the principal
+	roles map[string]struct{}
+	// principals which have been granted this role, always empty for users
+	members map[principalKey]struct{}
+	// cached effective privileges, nil if invalidated
+	effective *PrivilegeTree
+	// addresses the user may connect from, always empty for roles
+	bindHosts BindHosts
+}
+
+// RoleGraph holds privileges of users and roles. Roles can be granted to
+// users or other roles, and a principal has all privileges of its roles.
+// It is safe for concurrent use.
+type RoleGraph struct {
+	mu         sync.Mutex
+	principals map[principalKey]*principal
+}
+
+// NewRoleGraph create an empty role graph.
+func NewRoleGraph() *RoleGraph {
+	return &RoleGraph{principals: make(map[principalKey]*principal)}
+}
+
+// CreateUser creates a user without any privilege.
+func (g *RoleGraph) CreateUser(name string) error {
+	return g.create(principalKey{name: name}, ErrUserExists)
+}
+
+// CreateRole creates a role without any privilege.
+func (g *RoleGraph) CreateRole(name string) error {
+	return g.create(principalKey{name: name, role: true}, ErrRoleExists)
+}
+
+func (g *RoleGraph) create(key principalKey, exists error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.principals[key]; ok {
+		return fmt.Errorf("%w: %s", exists, key.name)
+	}
+	g.principals[key] = &principal{
+		privileges: NewPrivilegeTree(),
+		roles:      make(map[string]struct{}),
+		members:    make(map[principalKey]struct{}),
+	}
+	return nil
+}
+
+// DropUser drops a user.
+func (g *RoleGraph) DropUser(name string) error {
+	return g.drop(principalKey{name: name})
+}
+
+// DropRole drops a role and revokes it from all users and roles having it.
+func (g *RoleGraph) DropRole(name string) error {
+	return g.drop(principalKey{name: name, role: true})
+}
+
+func (g *RoleGraph) drop(key principalKey) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.principals[key]
+	if !ok {
+		return key.notFound()
+	}
+	g.invalidate(key)
+
+	for member := range p.members {
+		delete(g.principals[member].roles, key.name)
+	}
+	for role := range p.roles {
+		delete(g.principals[principalKey{name: role, role: true}].members, key)
+	}
+	delete(g.principals, key)
+	return nil
+}
+
+// Users returns names of all users in sorted order.
+func (g *RoleGraph) Users() []string {
+	return g.names(false)
+}
+
+// Roles returns names of all roles in sorted order.
+func (g *RoleGraph) Roles() []string {
+	return g.names(true)
+}
+
+func (g *RoleGraph) names(role bool) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]string, 0, len(g.principals))
+	for k := range g.principals {
+		if k.role == role {
+			names = append(names, k.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UpdateUser modifies privileges granted to the user directly.
+func (g *RoleGraph) UpdateUser(name string, fn func(set PrivilegeSet)) error {
+	return g.update(principalKey{name: name}, fn)
+}
+
+// UpdateRole modifies privileges granted to the role directly, all users and
+// roles having this role are affected.
+func (g *RoleGraph) UpdateRole(name string, fn func(set PrivilegeSet)) error {
+	return g.update(principalKey{name: name, role: true}, fn)
+}
+
+func (g *RoleGraph) update(key principalKey, fn func(set PrivilegeSet)) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.principals[key]
+	if !ok {
+		return key.notFound()
+	}
+	fn(p.privileges)
+	g.invalidate(key)
+	return nil
+}
+
+// SetBindHosts restricts the user to connect from the given hosts only, an
+// empty set lifts the restriction.
+func (g *RoleGraph) SetBindHosts(user string, hosts BindHosts) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := principalKey{name: user}
+	p, ok := g.principals[key]
+	if !ok {
+		return key.notFound()
+	}
+	p.bindHosts = append(BindHosts(nil), hosts...)
+	return nil
+}
+
+// AlterUser applies attributes of the user altered by the statement which are
+// kept by the role graph, that is the BINDHOST clause. Password and account
+// lock are left to the caller.
+func (g *RoleGraph) AlterUser(stmt *AlterUserStatement) error {
+	if stmt.BindHosts == nil {
+		return nil
+	}
+	hosts, err := ParseBindHosts(stmt.BindHosts)
+	if err != nil {
+		return err
+	}
+	return g.SetBindHosts(stmt.Name, hosts)
+}
+
+// AuthorizeHost returns nil if the user may connect from addr, see
+// AuthorizeHost for errors returned.
+func (g *RoleGraph) AuthorizeHost(user, addr string) error {
+	hosts, err := g.BindHosts(user)
+	if err != nil {
+		return err
+	}
+	return AuthorizeHost(hosts, addr)
+}
+
+// BindHosts returns hosts the user may connect from, empty if unrestricted.
+func (g *RoleGraph) BindHosts(user string) (BindHosts, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := principalKey{name: user}
+	p, ok := g.principals[key]
+	if !ok {
+		return nil, key.notFound()
+	}
+	return append(BindHosts(nil), p.bindHosts...), nil
+}
+
+// GrantRole grants role to a user, or to another role if toRole is true.
+// Granting a role to itself or to any role it contains returns ErrRoleCycle.
+func (g *RoleGraph) GrantRole(role, to string, toRole bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	roleKey, toKey := principalKey{name: role, role: true}, principalKey{name: to, role: toRole}
+	r, ok := g.principals[roleKey]
+	if !ok {
+		return roleKey.notFound()
+	}
+	p, ok := g.principals[toKey]
+	if !ok {
+		return toKey.notFound()
+	}
+
+	if toRole && g.reachable(roleKey, toKey) {
+		return fmt.Errorf("%w: grant %s to %s", ErrRoleCycle, roleKey, toKey)
+	}
+
+	p.roles[role] = struct{}{}
+	r.members[toKey] = struct{}{}
+	g.invalidate(toKey)
+	return nil
+}
+
+// RevokeRole revokes role from a user, or from another role if fromRole is true.
+func (g *RoleGraph) RevokeRole(role, from string, fromRole bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	roleKey, fromKey := principalKey{name: role, role: true}, principalKey{name: from, role: fromRole}
+	r, ok := g.principals[roleKey]
+	if !ok {
+		return roleKey.notFound()
+	}
+	p, ok := g.principals[fromKey]
+	if !ok {
+		return fromKey.notFound()
+	}
+
+	delete(p.roles, role)
+	delete(r.members, fromKey)
+	g.invalidate(fromKey)
+	return nil
+}
+
+// EffectivePrivileges returns union of privileges granted to the user directly
+// and all privileges of its roles. The returned tree is a copy which can be
+// modified freely.
+func (g *RoleGraph) EffectivePrivileges(user string) (*PrivilegeTree, error) {
+	return g.effectivePrivileges(principalKey{name: user})
+}
+
+// EffectiveRolePrivileges returns union of privileges granted to the role
+// directly and all privileges of roles it contains.
+func (g *RoleGraph) EffectiveRolePrivileges(role string) (*PrivilegeTree, error) {
+	return g.effectivePrivileges(principalKey{name: role, role: true})
+}
+
+// ShowGrants returns effective privileges of the user or role named by the
+// statement as rows.
+func (g *RoleGraph) ShowGrants(stmt *ShowGrantsStatement) (Grants, error) {
+	tree, err := g.effectivePrivileges(principalKey{name: stmt.Name, role: stmt.Role})
+	if err != nil {
+		return nil, err
+	}
+	return GrantsOf(tree), nil
+}
+
+func (g *RoleGraph) effectivePrivileges(key principalKey) (*PrivilegeTree, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.principals[key]; !ok {
+		return nil, key.notFound()
+	}
+	return g.effective(key).clone(), nil
+}
+
+// effective returns cached effective privileges, computes it if invalidated.
+func (g *RoleGraph) effective(key principalKey) *PrivilegeTree {
+	p := g.principals[key]
+	if p.effective != nil {
+		return p.effective
+	}
+
+	effective := p.privileges.clone()
+	for role := range p.roles {
+		effective.UnionWith(g.effective(principalKey{name: role, role: true}))
+	}
+	p.effective = effective
+	return effective
+}
+
+// invalidate clears cached effective privileges of the principal and all
+// principals which inherit privileges from it.
+func (g *RoleGraph) invalidate(key principalKey) {
+	p := g.principals[key]
+	p.effective = nil
+	for member := range p.members {
+		g.invalidate(member)
+	}
+}
+
+// reachable checks if to can be reached from start by following granted roles,
+// which means start has been granted role to directly or indirectly.
+func (g *RoleGraph) reachable(start, to principalKey) bool {
+	if start == to {
+		return true
+	}
+	for role := range g.principals[start].roles {
+		if g.reachable(principalKey{name: role, role: true}, to) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package priv_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+func TestRoleGraphEffectivePrivileges(t *testing.T) {
+	g := priv.NewRoleGraph()
+	for _, name := range []string{"alice", "bob"} {
+		if err := g.CreateUser(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"reader", "writer", "admin"} {
+		if err := g.CreateRole(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustNil(t, g.UpdateRole("reader", func(set priv.PrivilegeSet) {
+		set.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	}))
+	mustNil(t, g.UpdateRole("writer", func(set priv.PrivilegeSet) {
+		set.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.InsertPrivilege)
+	}))
+	mustNil(t, g.UpdateRole("admin", func(set priv.PrivilegeSet) {
+		set.AddGlobal(priv.CreateUserPrivilege)
+	}))
+	mustNil(t, g.UpdateUser("alice", func(set priv.PrivilegeSet) {
+		set.Add(priv.CreateResourcePathUnsafe("yourdb"), priv.DropPrivilege)
+	}))
+
+	mustNil(t, g.GrantRole("reader", "writer", true))
+	mustNil(t, g.GrantRole("writer", "admin", true))
+	mustNil(t, g.GrantRole("admin", "alice", false))
+	mustNil(t, g.GrantRole("reader", "bob", false))
+
+	alice, err := g.EffectivePrivileges("alice")
+	mustNil(t, err)
+	runCases(t, alice, []struct {
+		r *priv.ResourcePath
+		p priv.Privilege
+		t bool
+	}{
+		{r: priv.CreateResourcePathUnsafe("global"), p: priv.CreateUserPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.daily"), p: priv.SelectPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), p: priv.SelectPrivilege | priv.InsertPrivilege, t: true},
+		{r: priv.CreateResourcePathUnsafe("mydb.autogen.mem"), p: priv.InsertPrivilege, t: false},
+		{r: priv.CreateResourcePathUnsafe("yourdb"), p: priv.DropPrivilege, t: true},
+	})
+
+	// modifying returned privileges does not affect the graph
+	alice.ClearAll()
+	alice, _ = g.EffectivePrivileges("alice")
+	if !alice.GlobalContain(priv.CreateUserPrivilege) {
+		t.Fatalf("expect effective privileges not affected by modifying returned copy")
+	}
+
+	// changing a role in the chain invalidates cached privileges
+	mustNil(t, g.UpdateRole("reader", func(set priv.PrivilegeSet) {
+		set.Delete(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+		set.Add(priv.CreateResourcePathUnsafe("yourdb"), priv.SelectPrivilege)
+	}))
+	for _, name := range []string{"alice", "bob"} {
+		set, err := g.EffectivePrivileges(name)
+		mustNil(t, err)
+		if set.Contain(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege) {
+			t.Fatalf("expect %s not contain privilege SELECT on mydb", name)
+		}
+		if !set.Contain(priv.CreateResourcePathUnsafe("yourdb"), priv.SelectPrivilege) {
+			t.Fatalf("expect %s contain privilege SELECT on yourdb", name)
+		}
+	}
+
+	mustNil(t, g.RevokeRole("writer", "admin", true))
+	alice, _ = g.EffectivePrivileges("alice")
+	if alice.Contain(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.InsertPrivilege) {
+		t.Fatalf("expect alice not contain privilege INSERT on mydb.autogen.cpu after revoke")
+	}
+
+	mustNil(t, g.DropRole("reader"))
+	bob, _ := g.EffectivePrivileges("bob")
+	if !bob.Powerless() {
+		t.Fatalf("expect bob powerless after drop role, got %s", bob)
+	}
+	mustNil(t, g.GrantRole("writer", "admin", true))
+
+	if act, exp := g.Roles(), []string{"admin", "writer"}; !reflect.DeepEqual(act, exp) {
+		t.Fatalf("roles got %v expect %v", act, exp)
+	}
+	if act, exp := g.Users(), []string{"alice", "bob"}; !reflect.DeepEqual(act, exp) {
+		t.Fatalf("users got %v expect %v", act, exp)
+	}
+}
+
+func TestRoleGraphCycle(t *testing.T) {
+	g := priv.NewRoleGraph()
+	for _, name := range []string{"a", "b", "c"} {
+		mustNil(t, g.CreateRole(name))
+	}
+	mustNil(t, g.GrantRole("a", "b", true))
+	mustNil(t, g.GrantRole("b", "c", true))
+
+	var tests = []struct {
+		role, to string
+	}{
+		{role: "a", to: "a"},
+		{role: "b", to: "a"},
+		{role: "c", to: "a"},
+		{role: "c", to: "b"},
+	}
+	for _, test := range tests {
+		if err := g.GrantRole(test.role, test.to, true); !errors.Is(err, priv.ErrRoleCycle) {
+			t.Fatalf("grant role %s to %s got error '%v' expect '%v'", test.role, test.to, err, priv.ErrRoleCycle)
+		}
+	}
+
+	// granting a role to a user never forms a cycle
+	mustNil(t, g.CreateUser("a"))
+	mustNil(t, g.GrantRole("c", "a", false))
+}
+
+func TestRoleGraphErr(t *testing.T) {
+	g := priv.NewRoleGraph()
+	mustNil(t, g.CreateUser("alice"))
+	mustNil(t, g.CreateRole("ops"))
+
+	var tests = []struct {
+		err error
+		exp error
+	}{
+		{err: g.CreateUser("alice"), exp: priv.ErrUserExists},
+		{err: g.CreateRole("ops"), exp: priv.ErrRoleExists},
+		{err: g.DropUser("bob"), exp: priv.ErrUserNotFound},
+		{err: g.DropRole("dev"), exp: priv.ErrRoleNotFound},
+		{err: g.GrantRole("dev", "alice", false), exp: priv.ErrRoleNotFound},
+		{err: g.GrantRole("ops", "bob", false), exp: priv.ErrUserNotFound},
+		{err: g.RevokeRole("ops", "dev", true), exp: priv.ErrRoleNotFound},
+		{err: g.UpdateUser("bob", func(priv.PrivilegeSet) {}), exp: priv.ErrUserNotFound},
+	}
+	for i, test := range tests {
+		if !errors.Is(test.err, test.exp) {
+			t.Fatalf("case %d got error '%v' expect '%v'", i, test.err, test.exp)
+		}
+	}
+
+	if _, err := g.EffectivePrivileges("ops"); !errors.Is(err, priv.ErrUserNotFound) {
+		t.Fatalf("effective privileges of unknown user got error '%v'", err)
+	}
+}
+
+func TestRoleGraphBindHosts(t *testing.T) {
+	g := priv.NewRoleGraph()
+	mustNil(t, g.CreateUser("svc"))
+	mustNil(t, g.CreateRole("ops"))
+
+	// hosts are bound from parsed statements and checked on connecting
+	stmt, err := priv.ParseStatement(`ALTER USER svc BINDHOST '10.0.0.0/8', '192.168.1.5'`)
+	mustNil(t, err)
+	mustNil(t, g.AlterUser(stmt.(*priv.AlterUserStatement)))
+
+	bound, err := g.BindHosts("svc")
+	mustNil(t, err)
+	if act, exp := bound.String(), "10.0.0.0/8, 192.168.1.5/32"; act != exp {
+		t.Fatalf("bind hosts of svc got %s expect %s", act, exp)
+	}
+	mustNil(t, g.AuthorizeHost("svc", "10.1.1.1:8086"))
+	mustNil(t, g.AuthorizeHost("svc", "192.168.1.5:8086"))
+	if err := g.AuthorizeHost("svc", "192.168.1.6:8086"); !errors.Is(err, priv.ErrHostNotAllowed) {
+		t.Fatalf("authorize host got error '%v' expect '%v'", err, priv.ErrHostNotAllowed)
+	}
+
+	// statements not mentioning hosts leave them unchanged
+	stmt, err = priv.ParseStatement(`ALTER USER svc ACCOUNT LOCK`)
+	mustNil(t, err)
+	mustNil(t, g.AlterUser(stmt.(*priv.AlterUserStatement)))
+	if err := g.AuthorizeHost("svc", "192.168.1.6:8086"); !errors.Is(err, priv.ErrHostNotAllowed) {
+		t.Fatalf("authorize host got error '%v' expect '%v'", err, priv.ErrHostNotAllowed)
+	}
+
+	if err := g.AlterUser(&priv.AlterUserStatement{Name: "svc", BindHosts: []string{"bad"}}); !errors.Is(err, priv.ErrInvalidIP) {
+		t.Fatalf("alter user with invalid host got error '%v' expect '%v'", err, priv.ErrInvalidIP)
+	}
+	if err := g.AuthorizeHost("bob", "10.1.1.1:8086"); !errors.Is(err, priv.ErrUserNotFound) {
+		t.Fatalf("authorize host of unknown user got error '%v' expect '%v'", err, priv.ErrUserNotFound)
+	}
+
+	mustNil(t, g.SetBindHosts("svc", nil))
+	if bound, _ := g.BindHosts("svc"); len(bound) != 0 {
+		t.Fatalf("expect bind hosts of svc lifted, got %s", bound)
+	}
+
+	if err := g.SetBindHosts("ops", bound); !errors.Is(err, priv.ErrUserNotFound) {
+		t.Fatalf("set bind hosts of role got error '%v' expect '%v'", err, priv.ErrUserNotFound)
+	}
+	if _, err := g.BindHosts("bob"); !errors.Is(err, priv.ErrUserNotFound) {
+		t.Fatalf("bind hosts of unknown user got error '%v' expect '%v'", err, priv.ErrUserNotFound)
+	}
+}
+
+func mustNil(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+}
@@ -0,0 +1,95 @@
+package priv
+
+import (
+	"sort"
+	"strings"
+)
+
+// knownPrivilegeNames are names of all privileges in sorted order.
+var knownPrivilegeNames []string
+
+func init() {
+	for name := range name2privilege {
+		knownPrivilegeNames = append(knownPrivilegeNames, name)
+	}
+	sort.Strings(knownPrivilegeNames)
+}
+
+// suggestPrivilege returns the privilege name closest to name, or an empty
+// string if no name is close enough.
+func suggestPrivilege(name string) string {
+	return suggest(strings.Join(strings.Fields(name), " "), knownPrivilegeNames)
+}
+
+// suggestKeyword returns the keyword in expected closest to found, or an empty
+// string if no keyword is close enough. Expected items which are not keywords,
+// e.g. identifier, are ignored.
+func suggestKeyword(found string, expected []string) string {
+	var keywords []string
+	for _, e := range expected {
+		if Lookup(e) != IDENT {
+			keywords = append(keywords, e)
+		}
+	}
+	return suggest(found, keywords)
+}
+
+// suggest returns the candidate closest to word ignoring case, the first one
+// is returned if more than one candidate are equally close. A candidate is
+// close enough if at most a third of it, plus one character, has to be edited.
+func suggest(word string, candidates []string) string {
+	word = strings.ToUpper(word)
+	best, bestDistance := "", -1
+	for _, c := range candidates {
+		d := editDistance(word, strings.ToUpper(c))
+		if d == 0 || d > len([]rune(c))/3+1 {
+			continue
+		}
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b, which is the
+// minimum number of single character insertions, deletions or substitutions
+// to change a into b.
+func editDistance(a, b string) int {
+	x, y := []rune(a), []rune(b)
+	if len(y) < len(x) {
+		x, y = y, x
+	}
+
+	// table[j] is distance between x[:i] and y[:j+1]
+	table := make([]int, len(y))
+	for j := range table {
+		table[j] = j + 1
+	}
+	for i := range x {
+		upLeft, left := i, i+1
+		for j := range y {
+			up := table[j]
+			cur := upLeft
+			if x[i] != y[j] {
+				cur = minInt(left, upLeft, up) + 1
+			}
+			left, upLeft, table[j] = cur, up, cur
+		}
+	}
+
+	if len(y) == 0 {
+		return 0
+	}
+	return table[len(y)-1]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
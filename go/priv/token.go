@@ -152,6 +152,10 @@ const (
 	ENABLE
 	DISABLE
 	keywordEnd
+
+	// UNTIL and the following are soft keywords, which are scanned as IDENT
+	// and only recognized by the parser where they are expected.
+	UNTIL
 )
 
 var tokens = [...]string{
@@ -169,6 +173,7 @@ var tokens = [...]string{
 	TRUE:        "TRUE",
 	FALSE:       "FALSE",
 	REGEX:       "REGEX",
+	BADREGEX:    "BADREGEX",
 
 	ADD:         "+",
 	SUB:         "-",
@@ -285,6 +290,7 @@ var tokens = [...]string{
 	ROLES:         "ROLES",
 	ENABLE:        "ENABLE",
 	DISABLE:       "DISABLE",
+	UNTIL:         "UNTIL",
 }
 
 var keywords map[string]Token
@@ -329,6 +335,9 @@ func (tok Token) Precedence() int {
 // isOperator returns true for operator tokens.
 func (tok Token) isOperator() bool { return tok > operatorBeg && tok < operatorEnd }
 
+// isKeyword returns true for keyword tokens.
+func (tok Token) isKeyword() bool { return tok > keywordBeg && tok < keywordEnd }
+
 // tokstr returns a literal if provided, otherwise returns the token string.
 func tokstr(tok Token, lit string) string {
 	if lit != "" {
@@ -0,0 +1,61 @@
+package priv
+
+import (
+	"sort"
+)
+
+// Walk visits every node of the tree with its effective privileges, which are
+// resolved from deltas along the path with denied privileges excluded and
+// legacy READ and WRITE expanded. The root is visited first with the global
+// resource, then children in depth first order, literal children sorted by
+// name before pattern children sorted by regex. Global privileges are only
+// reported on the root as they are meaningless on other resources.
+// Walking stops if fn returns false, fn must not modify the tree.
+func (t *PrivilegeTree) Walk(fn func(path *ResourcePath, effective Privilege) bool) {
+	t.walk(nil, NoPrivilege, NoPrivilege, fn)
+}
+
+// walk visits the node at segs and nodes under it, sum and denied are
+// privileges granted and denied on its parent. It returns false if walking
+// is stopped.
+func (t *PrivilegeTree) walk(segs []string, sum, denied Privilege, fn func(*ResourcePath, Privilege) bool) bool {
+	sum ^= t.Privilege
+	denied |= t.Denied
+
+	// each path has its own segments, so that fn may keep it
+	path := &ResourcePath{Segs: append([]string(nil), segs...), Regex: t.Regex}
+	effective := t.effective(sum, denied)
+	if len(segs) > 0 || t.Regex != nil {
+		effective &= AllResourcePrivileges
+	}
+	if !fn(path, effective) {
+		return false
+	}
+
+	names := make([]string, 0, len(t.Tree))
+	for k, v := range t.Tree {
+		if v != nil {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		if !t.Tree[k].walk(append(segs[:len(segs):len(segs)], k), sum, denied, fn) {
+			return false
+		}
+	}
+
+	patterns := make([]string, 0, len(t.Patterns))
+	for k, v := range t.Patterns {
+		if v != nil {
+			patterns = append(patterns, k)
+		}
+	}
+	sort.Strings(patterns)
+	for _, k := range patterns {
+		if !t.Patterns[k].walk(segs, sum, denied, fn) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package priv_test
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/musenwill/exercise/priv"
+)
+
+type walked struct {
+	path      string
+	privilege priv.Privilege
+}
+
+func walkAll(set interface {
+	Walk(func(*priv.ResourcePath, priv.Privilege) bool)
+}) []walked {
+	var visited []walked
+	set.Walk(func(path *priv.ResourcePath, effective priv.Privilege) bool {
+		visited = append(visited, walked{path: path.String(), privilege: effective})
+		return true
+	})
+	return visited
+}
+
+func TestPrivilegeTreeWalk(t *testing.T) {
+	tree := priv.NewPrivilegeTree()
+	tree.AddGlobal(priv.ShowUsersPrivilege | priv.InsertPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("yourdb"), priv.ReadPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb"), priv.SelectPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen.cpu"), priv.DeletePrivilege)
+	tree.Delete(priv.CreateResourcePathUnsafe("mydb.autogen.mem"), priv.InsertPrivilege)
+	tree.Deny(priv.CreateResourcePathUnsafe("mydb.autogen.secret"), priv.SelectPrivilege)
+	tree.Add(priv.CreateResourcePathUnsafe("mydb.autogen./^disk/"), priv.DropPrivilege)
+
+	exp := []walked{
+		{path: "", privilege: priv.ShowUsersPrivilege | priv.InsertPrivilege},
+		{path: "mydb", privilege: priv.InsertPrivilege | priv.SelectPrivilege},
+		{path: "mydb.autogen", privilege: priv.InsertPrivilege | priv.SelectPrivilege},
+		{path: "mydb.autogen.cpu", privilege: priv.InsertPrivilege | priv.SelectPrivilege | priv.DeletePrivilege},
+		{path: "mydb.autogen.mem", privilege: priv.SelectPrivilege},
+		{path: "mydb.autogen.secret", privilege: priv.InsertPrivilege},
+		{path: "mydb.autogen./^disk/", privilege: priv.InsertPrivilege | priv.SelectPrivilege | priv.DropPrivilege},
+		{path: "yourdb", privilege: priv.InsertPrivilege | priv.ReadPrivilege | priv.SelectPrivilege | priv.CreateCQPrivilege},
+	}
+	if act := walkAll(tree); !reflect.DeepEqual(act, exp) {
+		t.Fatalf("walk got %v expect %v", act, exp)
+	}
+
+	// walking stops as soon as fn returns false
+	var paths []string
+	tree.Walk(func(path *priv.ResourcePath, effective priv.Privilege) bool {
+		paths = append(paths, path.String())
+		return len(paths) < 3
+	})
+	if exp := []string{"", "mydb", "mydb.autogen"}; !reflect.DeepEqual(paths, exp) {
+		t.Fatalf("walk got %v expect %v", paths, exp)
+	}
+
+	// concurrent and expiring sets walk their current privileges
+	concurrent := priv.NewConcurrentPrivilegeSet()
+	concurrent.UnionWith(tree)
+	if act := walkAll(concurrent); !reflect.DeepEqual(act, exp) {
+		t.Fatalf("walk concurrent set got %v expect %v", act, exp)
+	}
+	expiring := priv.NewExpiringPrivilegeSet(nil)
+	expiring.UnionWith(tree)
+	if act := walkAll(expiring); !reflect.DeepEqual(act, exp) {
+		t.Fatalf("walk expiring set got %v expect %v", act, exp)
+	}
+}
+
+func TestPrivilegeTreeWalkFuzz(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		tree := randomPrivilegeTree(r)
+		s := tree.String()
+
+		var last string
+		tree.Walk(func(path *priv.ResourcePath, effective priv.Privilege) bool {
+			if len(path.Segs) == 0 && path.Regex == nil {
+				if !tree.GlobalContain(effective) {
+					t.Fatalf("walk %s got %s on global resource not contained", s, effective)
+				}
+				return true
+			}
+
+			// literal paths are visited in sorted order
+			if path.Regex == nil {
+				key := ""
+				for _, seg := range path.Segs {
+					key += seg + "\x00"
+				}
+				if key <= last {
+					t.Fatalf("walk %s visited %s out of order", s, path)
+				}
+				last = key
+			}
+
+			for mask := priv.Privilege(1); mask <= priv.DropPrivilege; mask <<= 1 {
+				if act, exp := effective&mask == mask, tree.Contain(path, mask); act != exp {
+					t.Fatalf("walk %s got %s on %s, contain %s is %v", s, effective, path, mask, exp)
+				}
+			}
+			return true
+		})
+	}
+}